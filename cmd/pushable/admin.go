@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/pushable/lib/pushclient"
+	"github.com/oliverisaac/pushable/types"
+	"github.com/oliverisaac/pushable/views"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+func adminPageData(cfg types.Config, db *gorm.DB, c echo.Context) (types.AdminPageData, error) {
+	pageData := types.AdminPageData{Config: cfg}
+	if user, ok := GetSessionUser(c); ok {
+		pageData.User = &user
+	}
+
+	allowSignup, err := getBoolSetting(db, types.SettingAllowSignup)
+	if err != nil {
+		return pageData, err
+	}
+	pageData.AllowSignupOverride = allowSignup
+
+	pageData.Require2FA, err = require2FA(db)
+	if err != nil {
+		return pageData, err
+	}
+
+	if err := db.Preload("PushSubscriptions").Order("id").Find(&pageData.Users).Error; err != nil {
+		return pageData, errors.Wrap(err, "listing users")
+	}
+
+	if err := db.Where("uses < max_uses AND expires_at > ?", time.Now()).Order("id").Find(&pageData.Invites).Error; err != nil {
+		return pageData, errors.Wrap(err, "listing invites")
+	}
+
+	pageData.VapidKeys, err = vapidKeyUsage(db)
+	if err != nil {
+		return pageData, err
+	}
+
+	return pageData, nil
+}
+
+// renderAdminPanel re-renders the admin panel after an action
+func renderAdminPanel(cfg types.Config, db *gorm.DB, c echo.Context, status int, msg string, actionErr error) error {
+	pageData, err := adminPageData(cfg, db, c)
+	if err != nil {
+		return err
+	}
+	return render(c, status, views.AdminPanel(pageData.WithMessage(msg).WithError(actionErr)))
+}
+
+func adminPage(cfg types.Config, db *gorm.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		pageData, err := adminPageData(cfg, db, c)
+		if err != nil {
+			return err
+		}
+		return render(c, 200, views.AdminPage(pageData))
+	}
+}
+
+func adminSetSignup(cfg types.Config, db *gorm.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		value := c.FormValue("allow")
+		if value == "" {
+			if err := deleteSetting(db, types.SettingAllowSignup); err != nil {
+				return err
+			}
+			return renderAdminPanel(cfg, db, c, 200, "Sign-up is now controlled by PUSHABLE_ALLOW_SIGNUP", nil)
+		}
+
+		allow, err := strconv.ParseBool(value)
+		if err != nil {
+			return renderAdminPanel(cfg, db, c, 422, "", fmt.Errorf("Invalid sign-up value %q", value))
+		}
+		if err := setSetting(db, types.SettingAllowSignup, strconv.FormatBool(allow)); err != nil {
+			return err
+		}
+
+		return renderAdminPanel(cfg, db, c, 200, fmt.Sprintf("Open sign-up set to %t", allow), nil)
+	}
+}
+
+// targetUser loads the user named by the :id path param
+func targetUser(db *gorm.DB, c echo.Context) (types.User, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return types.User{}, errors.Wrap(err, "parsing user id")
+	}
+	return getUserByID(db, uint(id))
+}
+
+func adminSetUserDisabled(cfg types.Config, db *gorm.DB, disabled bool) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, err := targetUser(db, c)
+		if err != nil {
+			return renderAdminPanel(cfg, db, c, 422, "", err)
+		}
+
+		if admin, _ := GetSessionUser(c); admin.ID == user.ID {
+			return renderAdminPanel(cfg, db, c, 422, "", fmt.Errorf("You cannot disable yourself"))
+		}
+
+		if err := db.Model(&user).Update("disabled", disabled).Error; err != nil {
+			return errors.Wrap(err, "updating user")
+		}
+
+		state := "enabled"
+		if disabled {
+			state = "disabled"
+		}
+		return renderAdminPanel(cfg, db, c, 200, fmt.Sprintf("%s has been %s", user.Email, state), nil)
+	}
+}
+
+func adminSetUserRole(cfg types.Config, db *gorm.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, err := targetUser(db, c)
+		if err != nil {
+			return renderAdminPanel(cfg, db, c, 422, "", err)
+		}
+
+		role := c.FormValue("role")
+		if role != types.RoleAdmin && role != types.RoleUser {
+			return renderAdminPanel(cfg, db, c, 422, "", fmt.Errorf("Unknown role %q", role))
+		}
+
+		if admin, _ := GetSessionUser(c); admin.ID == user.ID && role != types.RoleAdmin {
+			return renderAdminPanel(cfg, db, c, 422, "", fmt.Errorf("You cannot remove your own admin role"))
+		}
+
+		if err := db.Model(&user).Update("role", role).Error; err != nil {
+			return errors.Wrap(err, "updating user role")
+		}
+
+		return renderAdminPanel(cfg, db, c, 200, fmt.Sprintf("%s is now %s", user.Email, role), nil)
+	}
+}
+
+func adminResetPassword(cfg types.Config, db *gorm.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, err := targetUser(db, c)
+		if err != nil {
+			return renderAdminPanel(cfg, db, c, 422, "", err)
+		}
+
+		if err := setPassword(db, user, c.FormValue("password")); err != nil {
+			return renderAdminPanel(cfg, db, c, 422, "", err)
+		}
+
+		return renderAdminPanel(cfg, db, c, 200, fmt.Sprintf("Password for %s has been reset", user.Email), nil)
+	}
+}
+
+func testPush(admin types.User) pushclient.Push {
+	return pushclient.Push{
+		Topic: "pushable-test",
+		Title: "Test Push",
+		Body:  fmt.Sprintf("This is a test notification sent by %s", admin.Email),
+		Icon:  "neutral",
+	}
+}
+
+func adminPushUser(cfg types.Config, db *gorm.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, err := targetUser(db, c)
+		if err != nil {
+			return renderAdminPanel(cfg, db, c, 422, "", err)
+		}
+
+		if len(user.PushSubscriptions) == 0 {
+			return renderAdminPanel(cfg, db, c, 422, "", fmt.Errorf("%s has no subscribed devices", user.Email))
+		}
+
+		admin, _ := GetSessionUser(c)
+		if err := sendPush(cfg, db, testPush(admin), user.PushSubscriptions); err != nil {
+			return err
+		}
+
+		return renderAdminPanel(cfg, db, c, 200, fmt.Sprintf("Test push sent to %d device(s) of %s", len(user.PushSubscriptions), user.Email), nil)
+	}
+}
+
+func adminPushSubscription(cfg types.Config, db *gorm.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var sub types.PushSubscription
+		if err := db.First(&sub, "id = ?", c.Param("id")).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return renderAdminPanel(cfg, db, c, 422, "", fmt.Errorf("Subscription not found"))
+			}
+			return errors.Wrap(err, "finding subscription")
+		}
+
+		admin, _ := GetSessionUser(c)
+		if err := sendPush(cfg, db, testPush(admin), []types.PushSubscription{sub}); err != nil {
+			return err
+		}
+
+		return renderAdminPanel(cfg, db, c, 200, fmt.Sprintf("Test push sent to device %d", sub.ID), nil)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/pushable/types"
+	"github.com/oliverisaac/pushable/views"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// auditPageLimit caps how many events the admin page shows
+const auditPageLimit = 500
+
+// audit appends an event to the audit log. actor is nil for anonymous
+// actions. Failures are logged rather than failing the request.
+func audit(db *gorm.DB, c echo.Context, actor *types.User, action string, target string) {
+	event := types.AuditEvent{
+		Action:    action,
+		Target:    target,
+		IP:        c.RealIP(),
+		UserAgent: c.Request().UserAgent(),
+	}
+	if actor != nil {
+		event.ActorID = &actor.ID
+		event.Actor = actor.Email
+	}
+
+	if err := db.Create(&event).Error; err != nil {
+		requestLogger(c).Error(errors.Wrapf(err, "saving audit event %s", action))
+	}
+}
+
+// auditSessionUser audits an action taken by the signed in user, if any
+func auditSessionUser(db *gorm.DB, c echo.Context, action string, target string) {
+	if user, ok := GetSessionUser(c); ok {
+		audit(db, c, &user, action, target)
+		return
+	}
+	audit(db, c, nil, action, target)
+}
+
+// auditFormFields lists the form values each admin route records in its
+// audit target. Anything else posted, such as passwords and webhook
+// secrets, is left out.
+var auditFormFields = map[string][]string{
+	"/admin/settings/signup":  {"allow"},
+	"/admin/settings/2fa":     {"require"},
+	"/admin/users/:id/role":   {"role"},
+	"/admin/invites":          {"role", "max_uses", "expires_in"},
+	"/admin/webhooks":         {"name", "url", "events"},
+	"/admin/inbound-webhooks": {"name", "topic", "verify"},
+}
+
+// auditFormValues rewrites form values before they're recorded. Webhook URLs
+// often carry credentials, so only their scheme and host are kept.
+var auditFormValues = map[string]func(string) string{
+	"url": urlOrigin,
+}
+
+// urlOrigin returns the scheme and host of raw
+func urlOrigin(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// auditTarget describes what an admin route acted on: the user's email or
+// the :id, followed by the route's allowed form values
+func auditTarget(db *gorm.DB, c echo.Context) string {
+	var parts []string
+	if id := c.Param("id"); id != "" {
+		target := id
+		if strings.Contains(c.Path(), "/users/") {
+			var user types.User
+			if db.First(&user, "id = ?", id).Error == nil {
+				target = user.Email
+			}
+		}
+		parts = append(parts, target)
+	}
+
+	if fields := auditFormFields[c.Path()]; len(fields) > 0 {
+		form, _ := c.FormParams()
+		values := url.Values{}
+		for _, field := range fields {
+			v, ok := form[field]
+			if !ok {
+				continue
+			}
+			if rewrite, ok := auditFormValues[field]; ok {
+				v = append([]string(nil), v...)
+				for i := range v {
+					v[i] = rewrite(v[i])
+				}
+			}
+			values[field] = v
+		}
+		if len(values) > 0 {
+			parts = append(parts, values.Encode())
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// AuditAdminMiddleware records every successful admin change
+func AuditAdminMiddleware(db *gorm.DB) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			err := next(c)
+			if err != nil || c.Request().Method != http.MethodPost || c.Response().Status >= 400 {
+				return err
+			}
+
+			action := types.AuditAdminPrefix + strings.TrimPrefix(c.Path(), "/admin/")
+			auditSessionUser(db, c, action, auditTarget(db, c))
+			return nil
+		}
+	}
+}
+
+// auditEvents returns the newest events matching filter
+func auditEvents(db *gorm.DB, filter types.AuditFilter, limit int) ([]types.AuditEvent, error) {
+	query := db.Order("id desc")
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.Actor != "" {
+		query = query.Where("LOWER(actor) LIKE LOWER(?)", "%"+filter.Actor+"%")
+	}
+	if filter.Target != "" {
+		query = query.Where("LOWER(target) LIKE LOWER(?)", "%"+filter.Target+"%")
+	}
+	for _, bound := range []struct {
+		value string
+		op    string
+	}{{filter.Since, ">="}, {filter.Until, "<"}} {
+		if bound.value == "" {
+			continue
+		}
+		t, err := time.ParseInLocation("2006-01-02", bound.value, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("Oops! %q is not a date like 2006-01-02", bound.value)
+		}
+		query = query.Where("created_at "+bound.op+" ?", t)
+	}
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+
+	var events []types.AuditEvent
+	err := query.Find(&events).Error
+	return events, errors.Wrap(err, "listing audit events")
+}
+
+func auditPage(cfg types.Config, db *gorm.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		pageData := types.AuditPageData{Config: cfg, Limit: auditPageLimit}
+		if user, ok := GetSessionUser(c); ok {
+			pageData.User = &user
+		}
+		if err := c.Bind(&pageData.Filter); err != nil {
+			return errors.Wrap(err, "reading audit filter")
+		}
+
+		err := db.Model(&types.AuditEvent{}).Distinct("action").Order("action").Pluck("action", &pageData.Actions).Error
+		if err != nil {
+			return errors.Wrap(err, "listing audit actions")
+		}
+
+		pageData.Events, pageData.Err = auditEvents(db, pageData.Filter, auditPageLimit)
+		return render(c, 200, views.AuditPage(pageData))
+	}
+}
+
+func auditExport(db *gorm.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var filter types.AuditFilter
+		if err := c.Bind(&filter); err != nil {
+			return errors.Wrap(err, "reading audit filter")
+		}
+
+		events, err := auditEvents(db, filter, 0)
+		if err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+
+		c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="audit.json"`)
+		return c.JSON(http.StatusOK, events)
+	}
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/oliverisaac/pushable/types"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+
+	sqlite "github.com/ncruces/go-sqlite3/gormlite"
+)
+
+// commandDB loads config and opens the database for commands that work on
+// it directly. Any flags the command adds to fs are parsed too.
+func commandDB(fs *flag.FlagSet, args []string) (types.Config, *gorm.DB, error) {
+	cfg, _, err := loadConfigFlags(fs, args)
+	if err != nil {
+		return cfg, nil, errors.Wrap(err, "Loading config")
+	}
+	configureLogging(cfg)
+
+	db, err := openDB(cfg)
+	return cfg, db, err
+}
+
+// backupCommand writes a consistent copy of the SQLite database while the
+// server keeps running
+func backupCommand(args []string) error {
+	fs := flag.NewFlagSet("pushable backup", flag.ContinueOnError)
+	cfg, db, err := commandDB(fs, args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: pushable backup [flags] FILE")
+	}
+	if cfg.DBDriver != types.DBDriverSQLite {
+		return fmt.Errorf("backup only supports SQLite, use pg_dump or pushable export for %s", cfg.DBDriver)
+	}
+
+	dest := fs.Arg(0)
+	if _, err := os.Stat(dest); err == nil {
+		return fmt.Errorf("%s already exists", dest)
+	}
+	if err := db.Exec("VACUUM INTO ?", dest).Error; err != nil {
+		return errors.Wrap(err, "backing up database")
+	}
+
+	fmt.Printf("backed up %s to %s\n", cfg.DBPath, dest)
+	return nil
+}
+
+// restoreCommand replaces the SQLite database with a backup. The server must
+// be stopped first.
+func restoreCommand(args []string) error {
+	fs := flag.NewFlagSet("pushable restore", flag.ContinueOnError)
+	force := fs.Bool("force", false, "replace the existing database")
+	cfg, _, err := loadConfigFlags(fs, args)
+	if err != nil {
+		return errors.Wrap(err, "Loading config")
+	}
+	configureLogging(cfg)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: pushable restore [--force] FILE")
+	}
+	if cfg.DBDriver != types.DBDriverSQLite {
+		return fmt.Errorf("restore only supports SQLite, use pushable import for %s", cfg.DBDriver)
+	}
+	if _, err := os.Stat(cfg.DBPath); err == nil && !*force {
+		return fmt.Errorf("%s already exists, stop the server and pass --force to replace it", cfg.DBPath)
+	}
+
+	backup, err := gorm.Open(sqlite.Open(fs.Arg(0)), &gorm.Config{Logger: gormLogger()})
+	if err != nil {
+		return errors.Wrapf(err, "opening %s", fs.Arg(0))
+	}
+	var check string
+	if err := backup.Raw("PRAGMA integrity_check").Scan(&check).Error; err != nil {
+		return errors.Wrapf(err, "checking %s", fs.Arg(0))
+	}
+	if check != "ok" {
+		return fmt.Errorf("%s failed its integrity check: %s", fs.Arg(0), check)
+	}
+	if !backup.Migrator().HasTable(&types.SchemaMigration{}) {
+		return fmt.Errorf("%s is not a Pushable backup", fs.Arg(0))
+	}
+
+	// Copied next to the database and renamed over it, so a failure part way
+	// leaves the old database in place
+	tmp := filepath.Join(filepath.Dir(cfg.DBPath), fmt.Sprintf(".%s.restore-%d", filepath.Base(cfg.DBPath), time.Now().Unix()))
+	if err := backup.Exec("VACUUM INTO ?", tmp).Error; err != nil {
+		os.Remove(tmp)
+		return errors.Wrap(err, "copying backup")
+	}
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(cfg.DBPath + suffix); err != nil && !os.IsNotExist(err) {
+			os.Remove(tmp)
+			return errors.Wrap(err, "removing old journal")
+		}
+	}
+	if err := os.Rename(tmp, cfg.DBPath); err != nil {
+		os.Remove(tmp)
+		return errors.Wrap(err, "replacing database")
+	}
+
+	fmt.Printf("restored %s from %s\n", cfg.DBPath, fs.Arg(0))
+	return nil
+}
+
+func exportCommand(args []string) error {
+	fs := flag.NewFlagSet("pushable export", flag.ContinueOnError)
+	omitSecrets := fs.Bool("omit-secrets", false, "leave out password hashes, two-factor secrets, VAPID private keys and invites")
+	fs.BoolVar(omitSecrets, "omit-passwords", false, "old name for --omit-secrets")
+	cfg, db, err := commandDB(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := migrateOnStart(cfg, db); err != nil {
+		return err
+	}
+
+	export, err := exportData(db, *omitSecrets)
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if fs.NArg() > 0 && fs.Arg(0) != "-" {
+		out, err = os.OpenFile(fs.Arg(0), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return errors.Wrap(err, "creating export file")
+		}
+		defer out.Close()
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return errors.Wrap(enc.Encode(export), "writing export")
+}
+
+// exportData copies the instance into an Export. With omitSecrets it leaves
+// out every credential it would otherwise carry, though subscriptions still
+// identify users' devices.
+func exportData(db *gorm.DB, omitSecrets bool) (types.Export, error) {
+	export := types.Export{Version: types.ExportVersion, ExportedAt: time.Now(), OmitsSecrets: omitSecrets}
+
+	var vapidKeys []types.VapidKey
+	if err := db.Order("id").Find(&vapidKeys).Error; err != nil {
+		return export, errors.Wrap(err, "listing vapid keys")
+	}
+	publicKeys := map[uint]string{}
+	for _, key := range vapidKeys {
+		publicKeys[key.ID] = key.PublicKey
+		k := types.ExportVapidKey{
+			PublicKey:  key.PublicKey,
+			PrivateKey: key.PrivateKey,
+			Active:     key.Active,
+			CreatedAt:  key.CreatedAt,
+		}
+		if omitSecrets {
+			k.PrivateKey = ""
+		}
+		export.VapidKeys = append(export.VapidKeys, k)
+	}
+
+	var users []types.User
+	if err := db.Preload("PushSubscriptions").Order("id").Find(&users).Error; err != nil {
+		return export, errors.Wrap(err, "listing users")
+	}
+	emails := map[uint]string{}
+	for _, user := range users {
+		emails[user.ID] = user.Email
+		u := types.ExportUser{
+			Name:        user.Name,
+			Email:       user.Email,
+			Role:        user.Role,
+			Disabled:    user.Disabled,
+			Password:    user.Password,
+			TOTPSecret:  user.TOTPSecret,
+			TOTPEnabled: user.TOTPEnabled,
+			OIDCSubject: user.OIDCSubject,
+			CreatedAt:   user.CreatedAt,
+		}
+		if omitSecrets {
+			u.Password, u.TOTPSecret, u.TOTPEnabled = "", "", false
+		}
+		for _, sub := range user.PushSubscriptions {
+			u.Subscriptions = append(u.Subscriptions, types.ExportPushSubscription{
+				Endpoint:       sub.Endpoint,
+				P256DH:         sub.P256DH,
+				Auth:           sub.Auth,
+				Keys:           sub.Keys,
+				VapidPublicKey: publicKeys[sub.VapidKeyID],
+				CreatedAt:      sub.CreatedAt,
+			})
+		}
+		export.Users = append(export.Users, u)
+	}
+
+	var settings []types.Setting
+	if err := db.Order("key").Find(&settings).Error; err != nil {
+		return export, errors.Wrap(err, "listing settings")
+	}
+	for _, setting := range settings {
+		if omitSecrets && setting.Key == types.SettingVapidPrivateKey {
+			continue
+		}
+		export.Settings = append(export.Settings, types.ExportSetting{Key: setting.Key, Value: setting.Value})
+	}
+
+	// An invite is only its token, so there is nothing to keep without it
+	if omitSecrets {
+		return export, nil
+	}
+	var invites []types.Invite
+	if err := db.Order("id").Find(&invites).Error; err != nil {
+		return export, errors.Wrap(err, "listing invites")
+	}
+	for _, invite := range invites {
+		export.Invites = append(export.Invites, types.ExportInvite{
+			TokenHash:      invite.TokenHash,
+			CreatedByEmail: emails[invite.CreatedByID],
+			Role:           invite.Role,
+			MaxUses:        invite.MaxUses,
+			Uses:           invite.Uses,
+			ExpiresAt:      invite.ExpiresAt,
+		})
+	}
+
+	return export, nil
+}
+
+func importCommand(args []string) error {
+	fs := flag.NewFlagSet("pushable import", flag.ContinueOnError)
+	cfg, db, err := commandDB(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := migrateOnStart(cfg, db); err != nil {
+		return err
+	}
+
+	var in io.Reader = os.Stdin
+	if fs.NArg() > 0 && fs.Arg(0) != "-" {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return errors.Wrap(err, "opening export file")
+		}
+		defer f.Close()
+		in = f
+	}
+
+	var export types.Export
+	if err := json.NewDecoder(in).Decode(&export); err != nil {
+		return errors.Wrap(err, "reading export")
+	}
+	if export.Version < 1 || export.Version > types.ExportVersion {
+		return fmt.Errorf("export is version %d, this build reads versions 1 to %d", export.Version, types.ExportVersion)
+	}
+
+	var result types.ImportResult
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		result, err = importData(tx, export)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("users: %d added, %d already present\n", result.Users, result.UsersSkipped)
+	fmt.Printf("subscriptions: %d added, %d already present\n", result.Subscriptions, result.SubscriptionsSkipped)
+	if result.SubscriptionsKeyMissing > 0 {
+		fmt.Printf("subscriptions: %d left out, their vapid key wasn't imported\n", result.SubscriptionsKeyMissing)
+	}
+	fmt.Printf("vapid keys: %d added, %d already present\n", result.VapidKeys, result.VapidKeysSkipped)
+	if result.VapidKeysOmitted > 0 {
+		fmt.Printf("vapid keys: %d left out, the export has no private keys\n", result.VapidKeysOmitted)
+	}
+	fmt.Printf("settings: %d added, %d already present\n", result.Settings, result.SettingsSkipped)
+	fmt.Printf("invites: %d added, %d already present\n", result.Invites, result.InvitesSkipped)
+	return nil
+}
+
+// importData adds the rows in export that tx doesn't already have. Existing
+// rows are left alone.
+func importData(tx *gorm.DB, export types.Export) (types.ImportResult, error) {
+	var result types.ImportResult
+
+	var activeCount int64
+	if err := tx.Model(&types.VapidKey{}).Where("active = ?", true).Count(&activeCount).Error; err != nil {
+		return result, errors.Wrap(err, "finding active vapid key")
+	}
+	keyIDs := map[string]uint{}
+	for _, k := range export.VapidKeys {
+		var key types.VapidKey
+		err := tx.First(&key, "public_key = ?", k.PublicKey).Error
+		if err == nil {
+			keyIDs[k.PublicKey] = key.ID
+			result.VapidKeysSkipped++
+			continue
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return result, errors.Wrap(err, "finding vapid key")
+		}
+
+		// Exported with --omit-secrets, so it can't sign anything
+		if k.PrivateKey == "" {
+			result.VapidKeysOmitted++
+			continue
+		}
+
+		// Only takes over as the active key if this instance has none
+		key = types.VapidKey{PublicKey: k.PublicKey, PrivateKey: k.PrivateKey, Active: k.Active && activeCount == 0}
+		key.CreatedAt = k.CreatedAt
+		if err := tx.Create(&key).Error; err != nil {
+			return result, errors.Wrap(err, "saving vapid key")
+		}
+		keyIDs[k.PublicKey] = key.ID
+		result.VapidKeys++
+	}
+
+	userIDs := map[string]uint{}
+	for _, u := range export.Users {
+		var user types.User
+		err := tx.First(&user, "email = ?", u.Email).Error
+		if err == nil {
+			result.UsersSkipped++
+		} else if errors.Is(err, gorm.ErrRecordNotFound) {
+			user = types.User{
+				Name:        u.Name,
+				Email:       u.Email,
+				Role:        u.Role,
+				Disabled:    u.Disabled,
+				Password:    u.Password,
+				TOTPSecret:  u.TOTPSecret,
+				TOTPEnabled: u.TOTPEnabled,
+				OIDCSubject: u.OIDCSubject,
+			}
+			user.CreatedAt = u.CreatedAt
+			if err := tx.Create(&user).Error; err != nil {
+				return result, errors.Wrapf(err, "saving user %s", u.Email)
+			}
+			result.Users++
+		} else {
+			return result, errors.Wrap(err, "finding user")
+		}
+		userIDs[u.Email] = user.ID
+
+		for _, s := range u.Subscriptions {
+			var count int64
+			if err := tx.Model(&types.PushSubscription{}).Where("endpoint = ?", s.Endpoint).Count(&count).Error; err != nil {
+				return result, errors.Wrap(err, "finding subscription")
+			}
+			if count > 0 {
+				result.SubscriptionsSkipped++
+				continue
+			}
+
+			// Without the key it was made with, nothing could sign pushes
+			// to it. The device subscribes again on its next visit.
+			keyID, ok := keyIDs[s.VapidPublicKey]
+			if !ok {
+				result.SubscriptionsKeyMissing++
+				continue
+			}
+
+			sub := types.PushSubscription{
+				UserID:     user.ID,
+				VapidKeyID: keyID,
+				Endpoint:   s.Endpoint,
+				P256DH:     s.P256DH,
+				Auth:       s.Auth,
+				Keys:       s.Keys,
+			}
+			sub.CreatedAt = s.CreatedAt
+			if err := tx.Create(&sub).Error; err != nil {
+				return result, errors.Wrap(err, "saving subscription")
+			}
+			result.Subscriptions++
+		}
+	}
+
+	for _, s := range export.Settings {
+		_, exists, err := getSetting(tx, s.Key)
+		if err != nil {
+			return result, err
+		}
+		if exists {
+			result.SettingsSkipped++
+			continue
+		}
+		if err := tx.Create(&types.Setting{Key: s.Key, Value: s.Value}).Error; err != nil {
+			return result, errors.Wrap(err, "saving setting")
+		}
+		result.Settings++
+	}
+
+	for _, i := range export.Invites {
+		tokenHash := i.TokenHash
+		if tokenHash == "" {
+			tokenHash = hashToken(i.Token)
+		}
+
+		var count int64
+		if err := tx.Model(&types.Invite{}).Where("token_hash = ?", tokenHash).Count(&count).Error; err != nil {
+			return result, errors.Wrap(err, "finding invite")
+		}
+		if count > 0 {
+			result.InvitesSkipped++
+			continue
+		}
+
+		invite := types.Invite{
+			TokenHash:   tokenHash,
+			CreatedByID: userIDs[i.CreatedByEmail],
+			Role:        i.Role,
+			MaxUses:     i.MaxUses,
+			Uses:        i.Uses,
+			ExpiresAt:   i.ExpiresAt,
+		}
+		if err := tx.Create(&invite).Error; err != nil {
+			return result, errors.Wrap(err, "saving invite")
+		}
+		result.Invites++
+	}
+
+	return result, nil
+}
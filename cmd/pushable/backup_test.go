@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oliverisaac/pushable/types"
+	"gorm.io/gorm"
+)
+
+// backupFixture fills db with one of everything an export carries
+func backupFixture(t *testing.T, db *gorm.DB) {
+	created := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	key := types.VapidKey{PublicKey: "public-key", PrivateKey: "private-key", Active: true}
+	key.CreatedAt = created
+	user := types.User{Email: "a@example.com", Role: types.RoleAdmin, Password: "password-hash", TOTPSecret: "totp-secret", TOTPEnabled: true}
+	user.CreatedAt = created
+	for _, row := range []interface{}{&key, &user} {
+		if err := db.Create(row).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sub := types.PushSubscription{UserID: user.ID, VapidKeyID: key.ID, Endpoint: "https://push.example.com/1", P256DH: "p256dh", Auth: "auth"}
+	sub.CreatedAt = created
+	invite := types.Invite{TokenHash: hashToken("invite-token"), CreatedByID: user.ID, Role: types.RoleUser, MaxUses: 2, ExpiresAt: created.Add(time.Hour)}
+	invite.CreatedAt = created
+	rows := []interface{}{
+		&sub,
+		&invite,
+		&types.Setting{Key: types.SettingAllowSignup, Value: "true"},
+		&types.Setting{Key: types.SettingVapidPrivateKey, Value: "private-key"},
+	}
+	for _, row := range rows {
+		if err := db.Create(row).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// exportJSON exports db through JSON, as the export command writes it
+func exportJSON(t *testing.T, db *gorm.DB, omitSecrets bool) (types.Export, string) {
+	export, err := exportData(db, omitSecrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	export.ExportedAt = time.Time{}
+	data, err := json.Marshal(export)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded types.Export
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	return decoded, string(data)
+}
+
+func importInto(t *testing.T, db *gorm.DB, export types.Export) types.ImportResult {
+	var result types.ImportResult
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		result, err = importData(tx, export)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	for driver, src := range testDialects(t) {
+		t.Run(driver, func(t *testing.T) {
+			backupFixture(t, src)
+			export, before := exportJSON(t, src, false)
+
+			dst := testDialects(t)[driver]
+			got := importInto(t, dst, export)
+			want := types.ImportResult{Users: 1, Subscriptions: 1, VapidKeys: 1, Settings: 2, Invites: 1}
+			if got != want {
+				t.Errorf("import = %+v, want %+v", got, want)
+			}
+			if _, after := exportJSON(t, dst, false); after != before {
+				t.Errorf("export after import differs\n got: %s\nwant: %s", after, before)
+			}
+
+			// Importing again finds everything already there
+			got = importInto(t, dst, export)
+			want = types.ImportResult{UsersSkipped: 1, SubscriptionsSkipped: 1, VapidKeysSkipped: 1, SettingsSkipped: 2, InvitesSkipped: 1}
+			if got != want {
+				t.Errorf("second import = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestExportOmitSecrets(t *testing.T) {
+	src := testDialects(t)[types.DBDriverSQLite]
+	backupFixture(t, src)
+	export, data := exportJSON(t, src, true)
+
+	if !export.OmitsSecrets {
+		t.Error("export doesn't say it omits secrets")
+	}
+	for _, secret := range []string{"private-key", "password-hash", "totp-secret", hashToken("invite-token")} {
+		if strings.Contains(data, secret) {
+			t.Errorf("export contains %q", secret)
+		}
+	}
+	if len(export.Users) != 1 || len(export.Users[0].Subscriptions) != 1 {
+		t.Fatalf("export has %d users, want 1 with 1 subscription", len(export.Users))
+	}
+
+	tests := []struct {
+		name string
+		// hasKey gives the destination the exported VAPID key beforehand
+		hasKey bool
+		want   types.ImportResult
+	}{
+		{
+			name: "key omitted",
+			want: types.ImportResult{Users: 1, VapidKeysOmitted: 1, SubscriptionsKeyMissing: 1, Settings: 1},
+		},
+		{
+			name:   "key already present",
+			hasKey: true,
+			want:   types.ImportResult{Users: 1, Subscriptions: 1, VapidKeysSkipped: 1, Settings: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := testDialects(t)[types.DBDriverSQLite]
+			var key types.VapidKey
+			if tt.hasKey {
+				key = types.VapidKey{PublicKey: "public-key", PrivateKey: "private-key", Active: true}
+				if err := dst.Create(&key).Error; err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if got := importInto(t, dst, export); got != tt.want {
+				t.Errorf("import = %+v, want %+v", got, tt.want)
+			}
+
+			var subs []types.PushSubscription
+			if err := dst.Find(&subs).Error; err != nil {
+				t.Fatal(err)
+			}
+			for _, sub := range subs {
+				if sub.VapidKeyID == 0 || sub.VapidKeyID != key.ID {
+					t.Errorf("subscription %s has vapid key %d, want %d", sub.Endpoint, sub.VapidKeyID, key.ID)
+				}
+			}
+
+			var user types.User
+			if err := dst.First(&user, "email = ?", "a@example.com").Error; err != nil {
+				t.Fatal(err)
+			}
+			if user.Password != "" || user.TOTPSecret != "" || user.TOTPEnabled {
+				t.Error("imported user has credentials from an export that omits them")
+			}
+		})
+	}
+}
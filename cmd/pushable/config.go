@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"github.com/oliverisaac/pushable/types"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// configFlags are the command line flags that override config keys
+var configFlags = []struct {
+	name  string
+	key   string
+	usage string
+}{
+	{"listen", "PUSHABLE_LISTEN_ADDRESS", "address to listen on"},
+	{"hostname", "PUSHABLE_HOSTNAME", "public hostname used in links"},
+	{"db-path", "PUSHABLE_DB_PATH", "path to the sqlite database"},
+	{"db-url", "PUSHABLE_DB_URL", "postgres:// or sqlite:// database URL"},
+	{"log-level", "PUSHABLE_LOG_LEVEL", "log level"},
+	{"log-format", "PUSHABLE_LOG_FORMAT", "log format, text or json"},
+	{"tls-cert", "PUSHABLE_TLS_CERT_FILE", "TLS certificate file"},
+	{"tls-key", "PUSHABLE_TLS_KEY_FILE", "TLS private key file"},
+}
+
+// configSecretWords mark keys whose values config print hides
+var configSecretWords = []string{"SECRET", "PASSWORD", "PRIVATE_KEY", "TOKEN", "DB_URL"}
+
+// loadConfig layers .env, the config file and flags from args over the
+// defaults and env
+func loadConfig(name string, args []string) (types.Config, *types.ConfigSource, error) {
+	return loadConfigFlags(flag.NewFlagSet(name, flag.ContinueOnError), args)
+}
+
+// loadConfigFlags is loadConfig for commands that add their own flags to fs.
+// Arguments after the flags are left in fs.Args().
+func loadConfigFlags(fs *flag.FlagSet, args []string) (types.Config, *types.ConfigSource, error) {
+	if err := godotenv.Load(".env"); err != nil && !os.IsNotExist(err) {
+		logrus.Error(errors.Wrap(err, "Failed to load .env"))
+	}
+
+	configFile := fs.String("config", os.Getenv("PUSHABLE_CONFIG"), "YAML or TOML config file (PUSHABLE_CONFIG)")
+	for _, f := range configFlags {
+		fs.String(f.name, "", fmt.Sprintf("%s (%s)", f.usage, f.key))
+	}
+	if err := fs.Parse(args); err != nil {
+		return types.Config{}, nil, err
+	}
+
+	src := &types.ConfigSource{Flags: map[string]string{}}
+	fs.Visit(func(set *flag.Flag) {
+		for _, f := range configFlags {
+			if f.name == set.Name {
+				src.Flags[f.key] = set.Value.String()
+			}
+		}
+	})
+
+	if *configFile != "" {
+		file, err := types.LoadConfigFile(*configFile)
+		if err != nil {
+			return types.Config{}, src, err
+		}
+		src.File = file
+	}
+
+	cfg, err := types.LoadConfig(src)
+	return cfg, src, err
+}
+
+func configCommand(args []string) error {
+	if len(args) == 0 || args[0] != "print" {
+		return fmt.Errorf("usage: pushable config print [flags]")
+	}
+
+	_, src, err := loadConfig("pushable config print", args[1:])
+	if src == nil {
+		return err
+	}
+
+	for _, v := range src.Resolved() {
+		fmt.Printf("%s=%s\t# %s\n", v.Key, printableConfigValue(v), v.From)
+	}
+
+	return errors.Wrap(err, "invalid config")
+}
+
+// printableConfigValue is v's value, or [REDACTED] if it is a secret
+func printableConfigValue(v types.ResolvedConfigValue) string {
+	for _, word := range configSecretWords {
+		if v.Value != "" && strings.Contains(v.Key, word) {
+			return "[REDACTED]"
+		}
+	}
+	return v.Value
+}
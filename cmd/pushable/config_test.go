@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/oliverisaac/pushable/types"
+)
+
+func TestLoadConfigLayers(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     bool
+		env      bool
+		flag     bool
+		want     string
+		wantFrom string
+	}{
+		{name: "default", want: "localhost", wantFrom: types.ConfigLayerDefault},
+		{name: "file", file: true, want: "file.example.com", wantFrom: types.ConfigLayerFile},
+		{name: "env over file", file: true, env: true, want: "env.example.com", wantFrom: types.ConfigLayerEnv},
+		{name: "flag over env", env: true, flag: true, want: "flag.example.com", wantFrom: types.ConfigLayerFlag},
+		{name: "flag over everything", file: true, env: true, flag: true, want: "flag.example.com", wantFrom: types.ConfigLayerFlag},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PUSHABLE_CONFIG", "")
+			t.Setenv("PUSHABLE_HOSTNAME", "env.example.com")
+			if !tt.env {
+				os.Unsetenv("PUSHABLE_HOSTNAME")
+			}
+
+			var args []string
+			if tt.file {
+				path := filepath.Join(t.TempDir(), "pushable.yaml")
+				if err := os.WriteFile(path, []byte("hostname: file.example.com\n"), 0o600); err != nil {
+					t.Fatal(err)
+				}
+				args = append(args, "-config", path)
+			}
+			if tt.flag {
+				args = append(args, "-hostname", "flag.example.com")
+			}
+
+			// Other required settings may be missing, the sources are still
+			// resolved
+			_, src, _ := loadConfig("pushable", args)
+			if src == nil {
+				t.Fatal("no config source")
+			}
+			for _, v := range src.Resolved() {
+				if v.Key != "PUSHABLE_HOSTNAME" {
+					continue
+				}
+				if v.Value != tt.want || v.From != tt.wantFrom {
+					t.Errorf("PUSHABLE_HOSTNAME = %q from %s, want %q from %s", v.Value, v.From, tt.want, tt.wantFrom)
+				}
+				return
+			}
+			t.Error("PUSHABLE_HOSTNAME was not resolved")
+		})
+	}
+}
+
+func TestPrintableConfigValue(t *testing.T) {
+	tests := []struct {
+		key   string
+		value string
+		want  string
+	}{
+		{key: "PUSHABLE_HOSTNAME", value: "push.example.com", want: "push.example.com"},
+		{key: "PUSHABLE_COOKIE_STORE_SECRET", value: "s3cret", want: "[REDACTED]"},
+		{key: "PUSHABLE_SMTP_PASSWORD", value: "s3cret", want: "[REDACTED]"},
+		{key: "PUSHABLE_OIDC_CLIENT_SECRET", value: "s3cret", want: "[REDACTED]"},
+		{key: "VAPID_PRIVATE_KEY", value: "s3cret", want: "[REDACTED]"},
+		{key: "PUSHABLE_DB_URL", value: "postgres://u:p@db/pushable", want: "[REDACTED]"},
+		{key: "VAPID_PUBLIC_KEY", value: "public", want: "public"},
+		{key: "PUSHABLE_SMTP_PASSWORD", value: "", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key+"="+tt.value, func(t *testing.T) {
+			got := printableConfigValue(types.ResolvedConfigValue{Key: tt.key, Value: tt.value})
+			if got != tt.want {
+				t.Errorf("printableConfigValue = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunCommandUnknown(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{name: "misspelled command", args: []string{"bakup"}},
+		{name: "command after flags", args: []string{"-listen", "127.0.0.1:0", "bakup"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := runCommand(tt.args)
+			if err == nil || !strings.Contains(err.Error(), `unknown command "bakup"`) {
+				t.Errorf("runCommand(%q) = %v, want an unknown command error", tt.args, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/oliverisaac/pushable/types"
+	"github.com/oliverisaac/pushable/views"
+)
+
+const CSRFKey = "csrf"
+
+// csrfExemptPaths are called by machines, which have no session cookie to
+// ride on and no way to read a token
+var csrfExemptPaths = map[string]bool{
+	"/push":             true,
+	"/push/resubscribe": true,
+	"/hooks/:token":     true,
+}
+
+func CSRFMiddleware(cfg types.Config) echo.MiddlewareFunc {
+	return middleware.CSRFWithConfig(middleware.CSRFConfig{
+		Skipper: func(c echo.Context) bool {
+			return csrfExemptPaths[c.Path()]
+		},
+		TokenLookup:    "header:X-CSRF-Token,form:_csrf",
+		ContextKey:     CSRFKey,
+		CookieName:     "_csrf",
+		CookiePath:     "/",
+		CookieMaxAge:   3600 * 24 * 365,
+		CookieHTTPOnly: true,
+		CookieSecure:   cfg.SecureCookies,
+		CookieSameSite: http.SameSiteLaxMode,
+	})
+}
+
+// CSRFTemplateMiddleware passes the CSRF token on to the templates
+func CSRFTemplateMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if token, ok := c.Get(CSRFKey).(string); ok {
+				req := c.Request()
+				c.SetRequest(req.WithContext(views.WithCSRFToken(req.Context(), token)))
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/pushable/types"
+)
+
+func TestCSRFMiddleware(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		target string
+		// token is sent as the _csrf form value, "valid" for the issued one
+		token    string
+		wantCode int
+	}{
+		{name: "read", method: http.MethodGet, target: "/account", wantCode: http.StatusOK},
+		{name: "form without a token", method: http.MethodPost, target: "/account", wantCode: http.StatusBadRequest},
+		{name: "form with the wrong token", method: http.MethodPost, target: "/account", token: "wrong", wantCode: http.StatusForbidden},
+		{name: "form with the token", method: http.MethodPost, target: "/account", token: "valid", wantCode: http.StatusOK},
+		{name: "push", method: http.MethodPost, target: "/push", wantCode: http.StatusOK},
+		{name: "resubscribe", method: http.MethodPost, target: "/push/resubscribe", wantCode: http.StatusOK},
+		{name: "inbound webhook", method: http.MethodPost, target: "/hooks/abc", wantCode: http.StatusOK},
+		{name: "subscribe isn't exempt", method: http.MethodPost, target: "/push/subscribe", wantCode: http.StatusBadRequest},
+		{name: "exempt paths match the route, not the prefix", method: http.MethodPost, target: "/push/", wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			e.Use(CSRFMiddleware(types.Config{}))
+			ok := func(c echo.Context) error { return c.String(http.StatusOK, "ok") }
+			for _, path := range []string{"/account", "/push", "/push/", "/push/resubscribe", "/push/subscribe", "/hooks/:token"} {
+				e.Match([]string{http.MethodGet, http.MethodPost}, path, ok)
+			}
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/account", nil))
+			cookies := rec.Result().Cookies()
+			if len(cookies) != 1 {
+				t.Fatalf("GET /account set %d cookies, want the CSRF cookie", len(cookies))
+			}
+
+			token := tt.token
+			if token == "valid" {
+				token = cookies[0].Value
+			}
+			form := ""
+			if token != "" {
+				form = "_csrf=" + token
+			}
+			if rec := serveWithCookies(e, tt.method, tt.target, strings.NewReader(form), cookies); rec.Code != tt.wantCode {
+				t.Errorf("%s %s = %d, want %d", tt.method, tt.target, rec.Code, tt.wantCode)
+			}
+		})
+	}
+}
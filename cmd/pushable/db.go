@@ -0,0 +1,32 @@
+package main
+
+import (
+	"github.com/oliverisaac/pushable/types"
+	"github.com/pkg/errors"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/plugin/opentelemetry/tracing"
+
+	_ "github.com/ncruces/go-sqlite3/embed"
+	sqlite "github.com/ncruces/go-sqlite3/gormlite"
+)
+
+// openDB connects to the configured SQLite file or Postgres database
+func openDB(cfg types.Config) (*gorm.DB, error) {
+	var dialector gorm.Dialector
+	switch cfg.DBDriver {
+	case types.DBDriverPostgres:
+		dialector = postgres.Open(cfg.DBURL)
+	default:
+		dialector = sqlite.Open(cfg.DBPath)
+	}
+
+	db, err := gorm.Open(dialector, &gorm.Config{Logger: gormLogger()})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to connect to %s database", cfg.DBDriver)
+	}
+	if err := db.Use(tracing.NewPlugin(tracing.WithoutMetrics(), tracing.WithoutQueryVariables())); err != nil {
+		return nil, errors.Wrap(err, "adding db tracing")
+	}
+	return db, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/oliverisaac/pushable/migrations"
+	"github.com/oliverisaac/pushable/types"
+	"gorm.io/gorm"
+)
+
+// testDialects opens a migrated, empty database for each driver under test.
+// Postgres only runs when PUSHABLE_TEST_POSTGRES_URL names a server, and
+// gets a schema of its own that is dropped afterwards.
+func testDialects(t *testing.T) map[string]*gorm.DB {
+	dbs := map[string]*gorm.DB{}
+
+	sqliteDB, err := openDB(types.Config{DBDriver: types.DBDriverSQLite, DBPath: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbs[types.DBDriverSQLite] = sqliteDB
+
+	if pgURL := os.Getenv("PUSHABLE_TEST_POSTGRES_URL"); pgURL != "" {
+		admin, err := openDB(types.Config{DBDriver: types.DBDriverPostgres, DBURL: pgURL})
+		if err != nil {
+			t.Fatal(err)
+		}
+		schema := fmt.Sprintf("pushable_test_%d", time.Now().UnixNano())
+		if err := admin.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { admin.Exec("DROP SCHEMA " + schema + " CASCADE") })
+
+		u, err := url.Parse(pgURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q := u.Query()
+		q.Set("search_path", schema)
+		u.RawQuery = q.Encode()
+		pgDB, err := openDB(types.Config{DBDriver: types.DBDriverPostgres, DBURL: u.String()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		dbs[types.DBDriverPostgres] = pgDB
+	} else {
+		t.Log("PUSHABLE_TEST_POSTGRES_URL is not set, skipping Postgres")
+	}
+
+	for driver, db := range dbs {
+		if _, err := migrations.Up(db); err != nil {
+			t.Fatalf("migrating %s: %v", driver, err)
+		}
+	}
+	return dbs
+}
+
+func TestAuditEventsFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter types.AuditFilter
+		want   []string
+	}{
+		{"everything", types.AuditFilter{}, []string{types.AuditSignIn, types.AuditPushSent}},
+		{"action", types.AuditFilter{Action: types.AuditPushSent}, []string{types.AuditPushSent}},
+		{"actor ignores case", types.AuditFilter{Actor: "ALICE"}, []string{types.AuditSignIn}},
+		{"target ignores case", types.AuditFilter{Target: "alerts"}, []string{types.AuditPushSent}},
+		{"no match", types.AuditFilter{Actor: "carol"}, nil},
+	}
+	for driver, db := range testDialects(t) {
+		events := []types.AuditEvent{
+			{Actor: "Alice@Example.com", Action: types.AuditSignIn, Target: "alice@example.com"},
+			{Actor: "bob@example.com", Action: types.AuditPushSent, Target: "Alerts: disk full"},
+		}
+		if err := db.Create(&events).Error; err != nil {
+			t.Fatal(err)
+		}
+
+		for _, tt := range tests {
+			t.Run(driver+"/"+tt.name, func(t *testing.T) {
+				got, err := auditEvents(db, tt.filter, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var actions []string
+				for i := len(got) - 1; i >= 0; i-- {
+					actions = append(actions, got[i].Action)
+				}
+				if fmt.Sprint(actions) != fmt.Sprint(tt.want) {
+					t.Errorf("auditEvents() = %v, want %v", actions, tt.want)
+				}
+			})
+		}
+	}
+}
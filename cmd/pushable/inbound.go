@@ -0,0 +1,236 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/pushable/lib/pushclient"
+	"github.com/oliverisaac/pushable/types"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// maxInboundBody caps how much of a posted body is read
+const maxInboundBody = 1 << 20
+
+// inboundTemplate parses one of an inbound webhook's templates
+func inboundTemplate(name string, text string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=zero").Parse(text)
+}
+
+func renderInboundTemplate(name string, text string, body interface{}) (string, error) {
+	if text == "" {
+		return "", nil
+	}
+	tmpl, err := inboundTemplate(name, text)
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	if err := tmpl.Execute(&out, body); err != nil {
+		return "", err
+	}
+	// missingkey=zero still prints a missing key in a JSON object as
+	// "<no value>", since the zero interface is nil
+	return strings.TrimSpace(strings.ReplaceAll(out.String(), "<no value>", "")), nil
+}
+
+// verifyInboundSignature checks the body against the endpoint's secret with
+// its verification scheme
+func verifyInboundSignature(hook types.InboundWebhook, header http.Header, body []byte) bool {
+	var signature string
+	switch hook.Verify {
+	case types.InboundVerifyGitHub:
+		signature = header.Get("X-Hub-Signature-256")
+		if !strings.HasPrefix(signature, "sha256=") {
+			return false
+		}
+	case types.InboundVerifySHA256:
+		name := hook.SignatureHeader
+		if name == "" {
+			name = types.DefaultInboundSignatureHeader
+		}
+		signature = header.Get(name)
+	default:
+		return true
+	}
+
+	got, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(hook.Secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// inboundPush renders hook's templates against a decoded body
+func inboundPush(hook types.InboundWebhook, body interface{}) (pushclient.Push, error) {
+	push := pushclient.Push{Topic: hook.Topic}
+	fields := []struct {
+		name string
+		text string
+		dest *string
+	}{
+		{"title", hook.TitleTemplate, &push.Title},
+		{"body", hook.BodyTemplate, &push.Body},
+		{"link", hook.LinkTemplate, &push.Link},
+		{"icon", hook.IconTemplate, &push.Icon},
+	}
+	for _, field := range fields {
+		value, err := renderInboundTemplate(field.name, field.text, body)
+		if err != nil {
+			return push, fmt.Errorf("rendering %s: %s", field.name, err)
+		}
+		*field.dest = value
+	}
+	if push.Title == "" {
+		return push, fmt.Errorf("title template rendered empty")
+	}
+	return push, nil
+}
+
+// inboundWebhook turns JSON posted by another service into a push, using the
+// endpoint's templates
+func inboundWebhook(cfg types.Config, db *gorm.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		db := db.WithContext(c.Request().Context())
+
+		var hook types.InboundWebhook
+		err := db.Where("token_hash = ? AND enabled = ?", hashToken(c.Param("token")), true).First(&hook).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.String(http.StatusNotFound, "webhook not found")
+		} else if err != nil {
+			return errors.Wrap(err, "finding inbound webhook")
+		}
+
+		raw, err := io.ReadAll(io.LimitReader(c.Request().Body, maxInboundBody+1))
+		if err != nil {
+			return errors.Wrap(err, "reading webhook body")
+		}
+		if len(raw) > maxInboundBody {
+			return c.String(http.StatusRequestEntityTooLarge, "body is too large")
+		}
+
+		if !verifyInboundSignature(hook, c.Request().Header, raw) {
+			return c.String(http.StatusUnauthorized, "invalid signature")
+		}
+
+		var body interface{}
+		if err := json.Unmarshal(raw, &body); err != nil {
+			return c.String(http.StatusBadRequest, "body is not JSON")
+		}
+
+		push, err := inboundPush(hook, body)
+		if err != nil {
+			return c.String(http.StatusUnprocessableEntity, err.Error())
+		}
+
+		return acceptPush(c, cfg, db, push, fmt.Sprintf("hook:%d", hook.ID))
+	}
+}
+
+func adminCreateInboundWebhook(cfg types.Config, db *gorm.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		name := strings.TrimSpace(c.FormValue("name"))
+		if name == "" {
+			return renderWebhooksPanel(cfg, db, c, 422, "", fmt.Errorf("Give the endpoint a name"))
+		}
+
+		verify := c.FormValue("verify")
+		if verify == "" {
+			verify = types.InboundVerifyNone
+		}
+		if !slices.Contains(types.InboundVerifySchemes, verify) {
+			return renderWebhooksPanel(cfg, db, c, 422, "", fmt.Errorf("Unknown verification scheme %q", verify))
+		}
+
+		hook := types.InboundWebhook{
+			Name:          name,
+			Verify:        verify,
+			Secret:        strings.TrimSpace(c.FormValue("secret")),
+			Topic:         strings.TrimSpace(c.FormValue("topic")),
+			TitleTemplate: c.FormValue("title_template"),
+			BodyTemplate:  c.FormValue("body_template"),
+			LinkTemplate:  c.FormValue("link_template"),
+			IconTemplate:  c.FormValue("icon_template"),
+			Enabled:       true,
+		}
+		if verify == types.InboundVerifySHA256 {
+			hook.SignatureHeader = strings.TrimSpace(c.FormValue("signature_header"))
+			if hook.SignatureHeader == "" {
+				hook.SignatureHeader = types.DefaultInboundSignatureHeader
+			}
+		}
+
+		if strings.TrimSpace(hook.TitleTemplate) == "" {
+			return renderWebhooksPanel(cfg, db, c, 422, "", fmt.Errorf("A title template is required"))
+		}
+		templates := map[string]string{
+			"title": hook.TitleTemplate,
+			"body":  hook.BodyTemplate,
+			"link":  hook.LinkTemplate,
+			"icon":  hook.IconTemplate,
+		}
+		for field, text := range templates {
+			if _, err := inboundTemplate(field, text); err != nil {
+				return renderWebhooksPanel(cfg, db, c, 422, "", fmt.Errorf("Invalid %s template: %s", field, err))
+			}
+		}
+
+		token, err := randomToken(24)
+		if err != nil {
+			return err
+		}
+		hook.TokenHash = hashToken(token)
+		msg := fmt.Sprintf("Endpoint %s created, copy its URL now as it won't be shown again: %s", name, types.InboundWebhookURL(cfg.Hostname, token))
+		if verify != types.InboundVerifyNone && hook.Secret == "" {
+			if hook.Secret, err = randomToken(32); err != nil {
+				return err
+			}
+			msg += fmt.Sprintf(", its signing secret is %s", hook.Secret)
+		}
+
+		if err := db.Create(&hook).Error; err != nil {
+			return errors.Wrap(err, "creating inbound webhook")
+		}
+
+		return renderWebhooksPanel(cfg, db, c, 200, msg, nil)
+	}
+}
+
+func adminSetInboundWebhookEnabled(cfg types.Config, db *gorm.DB, enabled bool) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		res := db.Model(&types.InboundWebhook{}).Where("id = ?", c.Param("id")).Update("enabled", enabled)
+		if res.Error != nil {
+			return errors.Wrap(res.Error, "updating inbound webhook")
+		}
+		if res.RowsAffected == 0 {
+			return renderWebhooksPanel(cfg, db, c, 422, "", fmt.Errorf("Endpoint not found"))
+		}
+
+		msg := "Endpoint disabled"
+		if enabled {
+			msg = "Endpoint enabled"
+		}
+		return renderWebhooksPanel(cfg, db, c, 200, msg, nil)
+	}
+}
+
+func adminDeleteInboundWebhook(cfg types.Config, db *gorm.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if err := db.Delete(&types.InboundWebhook{}, "id = ?", c.Param("id")).Error; err != nil {
+			return errors.Wrap(err, "deleting inbound webhook")
+		}
+		return renderWebhooksPanel(cfg, db, c, 200, "Endpoint deleted", nil)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/pushable/lib/pushclient"
+	"github.com/oliverisaac/pushable/types"
+)
+
+func TestInboundPush(t *testing.T) {
+	hook := types.InboundWebhook{
+		Topic:         "ci",
+		TitleTemplate: "{{ .repository.full_name }} {{ .action }}",
+		BodyTemplate:  "{{ .sender.login }} {{ .missing }}",
+		LinkTemplate:  "{{ .html_url }}",
+		IconTemplate:  "{{ if eq .action \"failed\" }}fail{{ else }}success{{ end }}",
+	}
+	tests := []struct {
+		name    string
+		hook    types.InboundWebhook
+		body    string
+		want    pushclient.Push
+		wantErr string
+	}{
+		{
+			name: "every field",
+			hook: hook,
+			body: `{"action": "failed", "repository": {"full_name": "o/r"}, "sender": {"login": "alice"}, "html_url": "https://ci.example.com/1"}`,
+			want: pushclient.Push{Topic: "ci", Title: "o/r failed", Body: "alice", Link: "https://ci.example.com/1", Icon: "fail"},
+		},
+		{
+			name: "missing keys render empty",
+			hook: hook,
+			body: `{"action": "passed", "repository": {}, "sender": {}}`,
+			want: pushclient.Push{Topic: "ci", Title: "passed", Icon: "success"},
+		},
+		{
+			name:    "empty title",
+			hook:    types.InboundWebhook{TitleTemplate: "{{ .title }}"},
+			body:    `{}`,
+			wantErr: "title template rendered empty",
+		},
+		{
+			name:    "template fails",
+			hook:    types.InboundWebhook{TitleTemplate: "{{ index .items 5 }}"},
+			body:    `{"items": [1]}`,
+			wantErr: "rendering title",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body interface{}
+			if err := json.Unmarshal([]byte(tt.body), &body); err != nil {
+				t.Fatal(err)
+			}
+			got, err := inboundPush(tt.hook, body)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("inboundPush error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("inboundPush = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInboundWebhook(t *testing.T) {
+	sign := func(body string) string {
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write([]byte(body))
+		return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	tests := []struct {
+		name      string
+		token     string
+		signature string
+		body      string
+		wantCode  int
+		wantTitle string
+	}{
+		{name: "push", token: "enabled-token", body: `{"title": "deployed"}`, wantCode: http.StatusOK, wantTitle: "deployed"},
+		{name: "unknown token", token: "unknown-token", body: `{"title": "deployed"}`, wantCode: http.StatusNotFound},
+		{name: "disabled hook", token: "disabled-token", body: `{"title": "deployed"}`, wantCode: http.StatusNotFound},
+		{name: "the stored hash is not a token", token: hashToken("enabled-token"), body: `{"title": "deployed"}`, wantCode: http.StatusNotFound},
+		{name: "signed", token: "signed-token", signature: sign(`{"title": "deployed"}`), body: `{"title": "deployed"}`, wantCode: http.StatusOK, wantTitle: "deployed"},
+		{name: "bad signature", token: "signed-token", signature: sign("other"), body: `{"title": "deployed"}`, wantCode: http.StatusUnauthorized},
+		{name: "not JSON", token: "enabled-token", body: "deployed", wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := testDialects(t)[types.DBDriverSQLite]
+			hooks := []types.InboundWebhook{
+				{Name: "enabled", TokenHash: hashToken("enabled-token"), Verify: types.InboundVerifyNone, TitleTemplate: "{{ .title }}", Enabled: true},
+				{Name: "disabled", TokenHash: hashToken("disabled-token"), Verify: types.InboundVerifyNone, TitleTemplate: "{{ .title }}"},
+				{Name: "signed", TokenHash: hashToken("signed-token"), Verify: types.InboundVerifyGitHub, Secret: "secret", TitleTemplate: "{{ .title }}", Enabled: true},
+			}
+			for i := range hooks {
+				if err := db.Create(&hooks[i]).Error; err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			e := echo.New()
+			e.POST("/hooks/:token", inboundWebhook(types.Config{}, db))
+			req := httptest.NewRequest(http.MethodPost, "/hooks/"+tt.token, strings.NewReader(tt.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			if tt.signature != "" {
+				req.Header.Set("X-Hub-Signature-256", tt.signature)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("POST /hooks/%s = %d %s, want %d", tt.token, rec.Code, rec.Body, tt.wantCode)
+			}
+
+			var notifications []types.Notification
+			if err := db.Find(&notifications).Error; err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantTitle == "" {
+				if len(notifications) != 0 {
+					t.Errorf("%d notifications sent, want none", len(notifications))
+				}
+				return
+			}
+			if len(notifications) != 1 || notifications[0].Title != tt.wantTitle {
+				t.Errorf("notifications = %+v, want one titled %q", notifications, tt.wantTitle)
+			}
+		})
+	}
+}
+
+func TestAdminCreateInboundWebhook(t *testing.T) {
+	db := testDialects(t)[types.DBDriverSQLite]
+	cfg := types.Config{Hostname: "push.example.com"}
+	e := echo.New()
+	e.POST("/admin/inbound-webhooks", adminCreateInboundWebhook(cfg, db))
+	e.GET("/admin/webhooks", webhooksPage(cfg, db))
+	e.POST("/hooks/:token", inboundWebhook(cfg, db))
+
+	form := "name=ci&title_template=" + url.QueryEscape("{{ .title }}")
+	req := httptest.NewRequest(http.MethodPost, "/admin/inbound-webhooks", strings.NewReader(form))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create = %d %s", rec.Code, rec.Body)
+	}
+	hookURL := regexp.MustCompile(`https://push\.example\.com/hooks/([\w-]+)`).FindStringSubmatch(rec.Body.String())
+	if hookURL == nil {
+		t.Fatalf("created endpoint's URL not shown in %s", rec.Body)
+	}
+	token := hookURL[1]
+
+	var hook types.InboundWebhook
+	if err := db.First(&hook).Error; err != nil {
+		t.Fatal(err)
+	}
+	if hook.TokenHash != hashToken(token) {
+		t.Errorf("stored token hash = %q, want the hash of the token in the URL", hook.TokenHash)
+	}
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/webhooks", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "ci") {
+		t.Fatalf("webhooks page = %d %s", rec.Code, rec.Body)
+	}
+	if strings.Contains(rec.Body.String(), token) {
+		t.Error("webhooks page shows the endpoint's token after creation")
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/hooks/"+token, strings.NewReader(`{"title": "deployed"}`))
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("POST to the created URL = %d %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/pushable/types"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+const maxInviteUses = 100
+
+// getUsableInvite returns the invite for token, or false if it is unknown,
+// expired or used up
+func getUsableInvite(db *gorm.DB, token string) (types.Invite, bool, error) {
+	if token == "" {
+		return types.Invite{}, false, nil
+	}
+
+	var invite types.Invite
+	err := db.First(&invite, "token_hash = ?", hashToken(token)).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return invite, false, nil
+	}
+	if err != nil {
+		return invite, false, errors.Wrap(err, "finding invite")
+	}
+
+	return invite, invite.Usable(time.Now()), nil
+}
+
+// redeemInvite claims one use of the invite, failing if another sign-up
+// took the last use first
+func redeemInvite(tx *gorm.DB, invite types.Invite) error {
+	res := tx.Model(&types.Invite{}).
+		Where("id = ? AND uses < max_uses AND expires_at > ?", invite.ID, time.Now()).
+		Update("uses", gorm.Expr("uses + 1"))
+	if res.Error != nil {
+		return errors.Wrap(res.Error, "redeeming invite")
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("Oops! That invitation is no longer valid")
+	}
+	return nil
+}
+
+func adminCreateInvite(cfg types.Config, db *gorm.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		role := c.FormValue("role")
+		if role != types.RoleAdmin && role != types.RoleUser {
+			return renderAdminPanel(cfg, db, c, 422, "", fmt.Errorf("Unknown role %q", role))
+		}
+
+		maxUses, err := strconv.Atoi(c.FormValue("max_uses"))
+		if err != nil || maxUses < 1 || maxUses > maxInviteUses {
+			return renderAdminPanel(cfg, db, c, 422, "", fmt.Errorf("Max uses must be between 1 and %d", maxInviteUses))
+		}
+
+		expiresIn, err := time.ParseDuration(c.FormValue("expires_in"))
+		if err != nil || expiresIn <= 0 {
+			return renderAdminPanel(cfg, db, c, 422, "", fmt.Errorf("Invalid expiry %q, use a duration like 72h", c.FormValue("expires_in")))
+		}
+
+		token, err := randomToken(24)
+		if err != nil {
+			return err
+		}
+
+		admin, _ := GetSessionUser(c)
+		invite := types.Invite{
+			TokenHash:   hashToken(token),
+			CreatedByID: admin.ID,
+			Role:        role,
+			MaxUses:     maxUses,
+			ExpiresAt:   time.Now().Add(expiresIn),
+		}
+		if err := db.Create(&invite).Error; err != nil {
+			return errors.Wrap(err, "creating invite")
+		}
+
+		return renderAdminPanel(cfg, db, c, 200, fmt.Sprintf("Invitation created, copy the link now as it won't be shown again: %s", types.InviteLink(cfg.Hostname, token)), nil)
+	}
+}
+
+func adminRevokeInvite(cfg types.Config, db *gorm.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if err := db.Delete(&types.Invite{}, "id = ?", c.Param("id")).Error; err != nil {
+			return errors.Wrap(err, "revoking invite")
+		}
+		return renderAdminPanel(cfg, db, c, 200, "Invitation revoked", nil)
+	}
+}
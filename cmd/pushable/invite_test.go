@@ -0,0 +1,94 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oliverisaac/pushable/types"
+)
+
+func TestGetUsableInvite(t *testing.T) {
+	tests := []struct {
+		name       string
+		invite     types.Invite
+		token      string
+		wantUsable bool
+	}{
+		{name: "usable", invite: types.Invite{MaxUses: 1, ExpiresAt: time.Now().Add(time.Hour)}, token: "token", wantUsable: true},
+		{name: "no token", invite: types.Invite{MaxUses: 1, ExpiresAt: time.Now().Add(time.Hour)}},
+		{name: "unknown token", invite: types.Invite{MaxUses: 1, ExpiresAt: time.Now().Add(time.Hour)}, token: "other"},
+		{name: "expired", invite: types.Invite{MaxUses: 1, ExpiresAt: time.Now().Add(-time.Second)}, token: "token"},
+		{name: "used up", invite: types.Invite{Uses: 2, MaxUses: 2, ExpiresAt: time.Now().Add(time.Hour)}, token: "token"},
+		{name: "uses left", invite: types.Invite{Uses: 1, MaxUses: 2, ExpiresAt: time.Now().Add(time.Hour)}, token: "token", wantUsable: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := testDialects(t)[types.DBDriverSQLite]
+			tt.invite.TokenHash = hashToken("token")
+			tt.invite.Role = types.RoleUser
+			if err := db.Create(&tt.invite).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			_, usable, err := getUsableInvite(db, tt.token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if usable != tt.wantUsable {
+				t.Errorf("getUsableInvite usable = %v, want %v", usable, tt.wantUsable)
+			}
+		})
+	}
+}
+
+func TestRedeemInvite(t *testing.T) {
+	tests := []struct {
+		name   string
+		invite types.Invite
+		// redeem is how many sign-ups try the invite at once
+		redeem   int
+		wantUses int
+	}{
+		{name: "one use", invite: types.Invite{MaxUses: 1, ExpiresAt: time.Now().Add(time.Hour)}, redeem: 1, wantUses: 1},
+		{name: "racing for the last use", invite: types.Invite{Uses: 2, MaxUses: 3, ExpiresAt: time.Now().Add(time.Hour)}, redeem: 5, wantUses: 3},
+		{name: "racing for every use", invite: types.Invite{MaxUses: 3, ExpiresAt: time.Now().Add(time.Hour)}, redeem: 10, wantUses: 3},
+		{name: "expired since it was looked up", invite: types.Invite{MaxUses: 1, ExpiresAt: time.Now().Add(-time.Second)}, redeem: 1},
+	}
+	for _, tt := range tests {
+		for driver, db := range testDialects(t) {
+			t.Run(tt.name+"/"+driver, func(t *testing.T) {
+				invite := tt.invite
+				invite.TokenHash = hashToken("token")
+				invite.Role = types.RoleUser
+				if err := db.Create(&invite).Error; err != nil {
+					t.Fatal(err)
+				}
+				startUses := invite.Uses
+
+				var wg sync.WaitGroup
+				var mu sync.Mutex
+				redeemed := 0
+				for range tt.redeem {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						if err := redeemInvite(db, invite); err == nil {
+							mu.Lock()
+							redeemed++
+							mu.Unlock()
+						}
+					}()
+				}
+				wg.Wait()
+
+				if err := db.First(&invite, invite.ID).Error; err != nil {
+					t.Fatal(err)
+				}
+				if invite.Uses != tt.wantUses || redeemed != tt.wantUses-startUses {
+					t.Errorf("%d redeemed and uses = %d, want %d and %d", redeemed, invite.Uses, tt.wantUses-startUses, tt.wantUses)
+				}
+			})
+		}
+	}
+}
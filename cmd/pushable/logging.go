@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/oliverisaac/pushable/types"
+	"github.com/sirupsen/logrus"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitiveNames marks log fields and query params whose values are never
+// logged
+var sensitiveNames = []string{"password", "secret", "token", "key", "auth", "sig", "code", "cookie", "csrf"}
+
+func isSensitive(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range sensitiveNames {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// secretPathPrefixes are routes whose next path segment is a credential,
+// such as the token in /hooks/:token
+var secretPathPrefixes = []string{"/hooks/"}
+
+// redactURI blanks credentials in a request URI: secret path segments, the
+// values of sensitive query params such as reset tokens, and the same inside
+// URLs passed as params, such as a /redirect target
+func redactURI(uri string) string {
+	u, err := url.ParseRequestURI(uri)
+	if err != nil {
+		return uri
+	}
+
+	for _, prefix := range secretPathPrefixes {
+		if rest, ok := strings.CutPrefix(u.Path, prefix); ok && rest != "" {
+			_, after, _ := strings.Cut(rest, "/")
+			u.Path = prefix + redacted
+			if after != "" {
+				u.Path += "/" + after
+			}
+			u.RawPath = ""
+		}
+	}
+
+	if u.RawQuery != "" {
+		q := u.Query()
+		for name, values := range q {
+			for i, v := range values {
+				if isSensitive(name) {
+					values[i] = redacted
+				} else {
+					values[i] = redactURI(v)
+				}
+			}
+		}
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
+
+// redactingFormatter hides sensitive fields before handing the entry to the
+// real formatter
+type redactingFormatter struct {
+	logrus.Formatter
+}
+
+func (f redactingFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	// logrus hands formatters a copy of the entry's fields, so they can be
+	// changed in place
+	for name, value := range entry.Data {
+		if isSensitive(name) {
+			entry.Data[name] = redacted
+		} else if s, ok := value.(string); ok && name == "uri" {
+			entry.Data[name] = redactURI(s)
+		}
+	}
+	return f.Formatter.Format(entry)
+}
+
+func configureLogging(cfg types.Config) {
+	logrus.SetLevel(cfg.LogLevel)
+
+	var formatter logrus.Formatter = &logrus.TextFormatter{}
+	if cfg.LogFormat == types.LogFormatJSON {
+		formatter = &logrus.JSONFormatter{}
+	}
+	logrus.SetFormatter(redactingFormatter{formatter})
+}
+
+// gormLogger sends slow queries and errors through logrus, leaving out query
+// arguments since they include token hashes and passwords
+func gormLogger() gormlogger.Interface {
+	return gormlogger.New(log.New(logrus.StandardLogger().WriterLevel(logrus.WarnLevel), "", 0), gormlogger.Config{
+		SlowThreshold:             200 * time.Millisecond,
+		LogLevel:                  gormlogger.Warn,
+		IgnoreRecordNotFoundError: true,
+		ParameterizedQueries:      true,
+	})
+}
+
+type loggerKey struct{}
+
+// loggerFrom returns the request scoped logger carried by ctx, or the
+// standard logger outside of a request
+func loggerFrom(ctx context.Context) *logrus.Entry {
+	if ctx != nil {
+		if entry, ok := ctx.Value(loggerKey{}).(*logrus.Entry); ok {
+			return entry
+		}
+	}
+	return logrus.NewEntry(logrus.StandardLogger())
+}
+
+func requestLogger(c echo.Context) *logrus.Entry {
+	return loggerFrom(c.Request().Context())
+}
+
+// RequestLoggerMiddleware tags every log line written while handling a
+// request with its request ID, and logs the request once it completes
+func RequestLoggerMiddleware() echo.MiddlewareFunc {
+	logRequest := middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
+		Skipper: func(c echo.Context) bool {
+			return c.Request().URL.Path == "/healthz"
+		},
+		LogMethod:   true,
+		LogURI:      true,
+		LogStatus:   true,
+		LogLatency:  true,
+		LogRemoteIP: true,
+		LogValuesFunc: func(c echo.Context, v middleware.RequestLoggerValues) error {
+			requestLogger(c).WithFields(logrus.Fields{
+				"method":     v.Method,
+				"uri":        v.URI,
+				"status":     v.Status,
+				"latency_ms": v.Latency.Milliseconds(),
+				"remote_ip":  v.RemoteIP,
+			}).Info("request")
+			return nil
+		},
+	})
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		handler := logRequest(next)
+		return func(c echo.Context) error {
+			id := c.Response().Header().Get(echo.HeaderXRequestID)
+			entry := logrus.WithField("request_id", id)
+			c.SetRequest(c.Request().WithContext(context.WithValue(c.Request().Context(), loggerKey{}, entry)))
+			return handler(c)
+		}
+	}
+}
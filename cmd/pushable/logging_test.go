@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestRedactURI(t *testing.T) {
+	reset := url.QueryEscape("https://push.example.com/auth/reset?token=abc")
+	tests := []struct {
+		name string
+		uri  string
+		want string
+	}{
+		{name: "nothing sensitive", uri: "/settings?tab=devices", want: "/settings?tab=devices"},
+		{name: "sensitive param", uri: "/auth/reset?token=abc", want: "/auth/reset?token=%5BREDACTED%5D"},
+		{name: "sensitive name in any case", uri: "/x?API_KEY=abc", want: "/x?API_KEY=%5BREDACTED%5D"},
+		{
+			name: "redirect target",
+			uri:  "/redirect?nid=1&sig=abc&target=" + reset,
+			want: "/redirect?nid=1&sig=%5BREDACTED%5D&target=" + url.QueryEscape("https://push.example.com/auth/reset?token=%5BREDACTED%5D"),
+		},
+		{name: "link param", uri: "/push?link=" + reset, want: "/push?link=" + url.QueryEscape("https://push.example.com/auth/reset?token=%5BREDACTED%5D")},
+		{name: "inbound webhook token", uri: "/hooks/abc", want: "/hooks/%5BREDACTED%5D"},
+		{name: "inbound webhook token with query", uri: "/hooks/abc?x=1", want: "/hooks/%5BREDACTED%5D?x=1"},
+		{name: "hooks without a token", uri: "/hooks/", want: "/hooks/"},
+		{name: "not a uri", uri: "::", want: "::"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redactURI(tt.uri); got != tt.want {
+				t.Errorf("redactURI(%q) = %q, want %q", tt.uri, got, tt.want)
+			}
+		})
+	}
+}
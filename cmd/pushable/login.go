@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/pushable/lib/pushclient"
+	"github.com/oliverisaac/pushable/types"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// maxLoginDelay caps the progressive delay between failed sign ins
+const maxLoginDelay = time.Minute
+
+// loginWait returns how long the account or address must wait before it may
+// try to sign in again
+func loginWait(cfg types.Config, db *gorm.DB, email string, ip string) (time.Duration, error) {
+	since := time.Now().Add(-cfg.Login.Lockout)
+
+	// A successful sign in clears the account's failures, but not the address's
+	var lastSuccess types.LoginAttempt
+	err := db.Where("email = ? AND success = ?", email, true).Order("id desc").Limit(1).Find(&lastSuccess).Error
+	if err != nil {
+		return 0, errors.Wrap(err, "finding last sign in")
+	}
+	accountSince := since
+	if lastSuccess.CreatedAt.After(since) {
+		accountSince = lastSuccess.CreatedAt
+	}
+
+	accountWait, err := failureWait(db.Where("email = ?", email), accountSince, cfg.Login.MaxFailures, cfg.Login.Lockout)
+	if err != nil {
+		return 0, err
+	}
+	ipWait, err := failureWait(db.Where("ip = ?", ip), since, cfg.Login.MaxIPFailures, cfg.Login.Lockout)
+	if err != nil {
+		return 0, err
+	}
+
+	return max(accountWait, ipWait), nil
+}
+
+func failureWait(scope *gorm.DB, since time.Time, maxFailures int, lockout time.Duration) (time.Duration, error) {
+	var failures []types.LoginAttempt
+	err := scope.Where("success = ? AND created_at > ?", false, since).Order("id desc").Find(&failures).Error
+	if err != nil {
+		return 0, errors.Wrap(err, "finding failed sign ins")
+	}
+	if len(failures) == 0 {
+		return 0, nil
+	}
+
+	last := failures[0].CreatedAt
+	until := last.Add(min(time.Second<<min(len(failures)-1, 16), maxLoginDelay))
+	if maxFailures > 0 && len(failures) >= maxFailures {
+		until = last.Add(lockout)
+	}
+	return max(time.Until(until), 0), nil
+}
+
+func tooManySignIns(c echo.Context, wait time.Duration) error {
+	signIns.WithLabelValues("locked").Inc()
+	c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	return fmt.Errorf("Too many failed sign ins, please try again in %s", wait.Round(time.Second))
+}
+
+func recordLoginAttempt(db *gorm.DB, c echo.Context, email string, success bool) error {
+	signIns.WithLabelValues(map[bool]string{true: "success", false: "failure"}[success]).Inc()
+	if !success {
+		requestLogger(c).Warnf("Failed sign in for %s from %s", email, c.RealIP())
+		audit(db, c, nil, types.AuditSignInFailed, email)
+	}
+
+	attempt := types.LoginAttempt{
+		Email:     email,
+		IP:        c.RealIP(),
+		UserAgent: c.Request().UserAgent(),
+		Success:   success,
+	}
+	return errors.Wrap(db.Create(&attempt).Error, "saving sign in attempt")
+}
+
+// completeSignIn starts a session for a user who has passed every sign in
+// step
+func completeSignIn(cfg types.Config, db *gorm.DB, c echo.Context, user types.User) error {
+	if err := recordLoginAttempt(db, c, user.Email, true); err != nil {
+		return err
+	}
+	audit(db, c, &user, types.AuditSignIn, user.Email)
+
+	if cfg.Login.NotifyNewDevice {
+		if err := notifyNewDevice(cfg, db, c, user); err != nil {
+			requestLogger(c).Error(errors.Wrapf(err, "notifying %s of new sign in", user.Email))
+		}
+	}
+
+	return startSession(c, db, user)
+}
+
+// notifyNewDevice pushes a notice to the user's existing subscriptions when
+// they sign in from a browser none of their sessions have used
+func notifyNewDevice(cfg types.Config, db *gorm.DB, c echo.Context, user types.User) error {
+	userAgent := c.Request().UserAgent()
+
+	var known int64
+	err := db.Unscoped().Model(&types.Session{}).Where("user_id = ? AND user_agent = ?", user.ID, userAgent).Count(&known).Error
+	if err != nil {
+		return errors.Wrap(err, "finding sessions")
+	}
+	if known > 0 {
+		return nil
+	}
+
+	var subscriptions []types.PushSubscription
+	if err := db.Where("user_id = ?", user.ID).Find(&subscriptions).Error; err != nil {
+		return errors.Wrap(err, "finding subscriptions")
+	}
+	if len(subscriptions) == 0 {
+		return nil
+	}
+
+	return sendPush(cfg, db, pushclient.Push{
+		Title: "New sign-in to Pushable",
+		Body:  fmt.Sprintf("Signed in from %s using %s", c.RealIP(), userAgent),
+		Icon:  "neutral",
+	}, subscriptions)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/oliverisaac/pushable/types"
+)
+
+// loginAttempts are n attempts by email from ip, the last one ago
+type loginAttempts struct {
+	n       int
+	email   string
+	ip      string
+	ago     time.Duration
+	success bool
+}
+
+func (a loginAttempts) rows(now time.Time) []types.LoginAttempt {
+	var rows []types.LoginAttempt
+	for i := a.n - 1; i >= 0; i-- {
+		row := types.LoginAttempt{Email: a.email, IP: a.ip, Success: a.success}
+		row.CreatedAt = now.Add(-a.ago - time.Duration(i)*time.Millisecond)
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func TestLoginWait(t *testing.T) {
+	const email, ip = "a@example.com", "192.0.2.1"
+	cfg := types.Config{Login: types.LoginConfig{MaxFailures: 5, MaxIPFailures: 10, Lockout: 15 * time.Minute}}
+	tests := []struct {
+		name     string
+		attempts []loginAttempts
+		// maxFailures overrides the account's lockout threshold
+		maxFailures *int
+		want        time.Duration
+	}{
+		{name: "no failures"},
+		{name: "first failure", attempts: []loginAttempts{{1, email, ip, 0, false}}, want: time.Second},
+		{name: "third failure", attempts: []loginAttempts{{3, email, ip, 0, false}}, want: 4 * time.Second},
+		{name: "delay has passed", attempts: []loginAttempts{{3, email, ip, 5 * time.Second, false}}},
+		{name: "delay is capped", attempts: []loginAttempts{{9, email, ip, 0, false}}, maxFailures: ptr(0), want: maxLoginDelay},
+		{name: "account locked out", attempts: []loginAttempts{{5, email, "192.0.2.2", 0, false}}, want: 15 * time.Minute},
+		{name: "lockout has passed", attempts: []loginAttempts{{5, email, ip, 16 * time.Minute, false}}},
+		{
+			name: "sign in clears the account's failures",
+			attempts: []loginAttempts{
+				{5, email, "192.0.2.2", 2 * time.Second, false},
+				{1, email, "192.0.2.2", time.Second, true},
+			},
+		},
+		{
+			name: "sign in doesn't clear the address's failures",
+			attempts: []loginAttempts{
+				{10, "b@example.com", ip, 2 * time.Second, false},
+				{1, email, ip, time.Second, true},
+			},
+			want: 15*time.Minute - 2*time.Second,
+		},
+		{name: "address locked out across accounts", attempts: []loginAttempts{{10, "b@example.com", ip, 0, false}}, want: 15 * time.Minute},
+		{name: "another account from another address", attempts: []loginAttempts{{10, "b@example.com", "192.0.2.2", 0, false}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := testDialects(t)[types.DBDriverSQLite]
+			now := time.Now()
+			for _, attempts := range tt.attempts {
+				rows := attempts.rows(now)
+				if err := db.Create(&rows).Error; err != nil {
+					t.Fatal(err)
+				}
+			}
+			cfg := cfg
+			if tt.maxFailures != nil {
+				cfg.Login.MaxFailures = *tt.maxFailures
+			}
+
+			got, err := loginWait(cfg, db, email, ip)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The attempts are a little older by the time loginWait runs
+			if got > tt.want || got < tt.want-time.Second {
+				t.Errorf("loginWait = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,39 +1,38 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"maps"
 	"net/http"
 	"os"
+	"os/signal"
+	"slices"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/a-h/templ"
 	"github.com/gorilla/sessions"
-	"github.com/joho/godotenv"
 	"github.com/labstack/echo-contrib/session"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/labstack/gommon/log"
-	"github.com/oliverisaac/goli"
 	"github.com/oliverisaac/pushable/static"
 	"github.com/oliverisaac/pushable/types"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
-
-	_ "github.com/ncruces/go-sqlite3/embed"
-	sqlite "github.com/ncruces/go-sqlite3/gormlite"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/labstack/echo/otelecho"
 	"gorm.io/gorm"
 )
 
-func init() {
-	goli.InitLogrus(logrus.DebugLevel)
-}
-
 const SessionKey = "session"
 const UserKey = "session-user"
 const SessionUserIDKey = "userid"
 
 func render(ctx echo.Context, status int, t templ.Component) error {
-	ctx.Response().Writer.WriteHeader(status)
+	ctx.Response().WriteHeader(status)
 
 	err := t.Render(ctx.Request().Context(), ctx.Response().Writer)
 	if err != nil {
@@ -43,17 +42,56 @@ func render(ctx echo.Context, status int, t templ.Component) error {
 	return nil
 }
 
+func isHTMX(c echo.Context) bool {
+	return c.Request().Header.Get("HX-Request") == "true"
+}
+
+// commands are the subcommands pushable runs instead of the server
+var commands = map[string]func(args []string) error{
+	"config":  configCommand,
+	"vapid":   vapidCommand,
+	"migrate": migrateCommand,
+	"backup":  backupCommand,
+	"restore": restoreCommand,
+	"export":  exportCommand,
+	"import":  importCommand,
+}
+
 func main() {
-	err := run()
-	if err != nil {
+	if err := runCommand(os.Args[1:]); err != nil {
 		logrus.Fatal(err)
 	}
 }
 
-func run() error {
-	err := godotenv.Load(".env")
+// runCommand runs the subcommand named by args, or the server when args is
+// empty or starts with a flag
+func runCommand(args []string) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return run(args)
+	}
+	command, ok := commands[args[0]]
+	if !ok {
+		return unknownCommand(args[0])
+	}
+	return command(args[1:])
+}
+
+func unknownCommand(name string) error {
+	return fmt.Errorf("unknown command %q\nusage: pushable [flags], or pushable <%s> ...", name, strings.Join(slices.Sorted(maps.Keys(commands)), "|"))
+}
+
+func run(args []string) error {
+	fs := flag.NewFlagSet("pushable", flag.ContinueOnError)
+	cfg, _, err := loadConfigFlags(fs, args)
+	if fs.NArg() > 0 {
+		return unknownCommand(fs.Arg(0))
+	}
 	if err != nil {
-		logrus.Error(errors.Wrap(err, "Failed to load .env"))
+		return errors.Wrap(err, "Loading config")
+	}
+	configureLogging(cfg)
+	if len(cfg.AllowSignupEmails) > 0 {
+		logrus.Infof("Allowed signup emails: %v", cfg.AllowSignupEmails)
 	}
 
 	tz := os.Getenv("TZ")
@@ -65,18 +103,22 @@ func run() error {
 		time.Local = loc
 	}
 
-	cfg, err := types.ConfigFromEnv()
+	shutdownTracing, err := setupTracing(context.Background(), cfg)
 	if err != nil {
-		return errors.Wrap(err, "Loading config from env")
+		return err
 	}
+	defer shutdownTracing(context.Background())
 
 	e := echo.New()
+	e.HideBanner = cfg.LogFormat == types.LogFormatJSON
+	e.HidePort = e.HideBanner
+	e.IPExtractor = ipExtractor(cfg)
 
 	e.StaticFS("/static", static.FS)
 
 	origErrHandler := e.HTTPErrorHandler
 	e.HTTPErrorHandler = func(err error, c echo.Context) {
-		logrus.Error(err)
+		requestLogger(c).Error(err)
 		origErrHandler(err, c)
 	}
 
@@ -87,37 +129,52 @@ func run() error {
 		DisablePrintStack: false,
 		LogLevel:          log.ERROR,
 		LogErrorFunc: func(c echo.Context, err error, stack []byte) error {
-			logrus.Error(errors.Wrap(err, "recovered panic:"))
+			requestLogger(c).Error(errors.Wrap(err, "recovered panic:"))
 			for _, l := range strings.Split(string(stack), "\n") {
-				logrus.Errorf("stack: %s", strings.ReplaceAll(l, "\t", "  "))
+				requestLogger(c).Errorf("stack: %s", strings.ReplaceAll(l, "\t", "  "))
 			}
 			return nil
 		},
 		DisableErrorHandler: false,
 	}))
 
-	e.Use(middleware.Secure())
+	e.Use(middleware.SecureWithConfig(secureConfig(cfg)))
 
-	e.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{
-		Format: "method=${method}, uri=${uri}, status=${status}\n",
-		Skipper: func(c echo.Context) bool {
-			return c.Request().URL.Path == "/healthz"
-		},
-	}))
+	e.Use(otelecho.Middleware("pushable", otelecho.WithSkipper(func(c echo.Context) bool {
+		return c.Request().URL.Path == "/healthz" || c.Request().URL.Path == "/readyz" || c.Request().URL.Path == "/metrics"
+	})))
+
+	e.Use(middleware.RequestID())
+	e.Use(RequestLoggerMiddleware())
 
-	db, err := gorm.Open(sqlite.Open(cfg.DBPath), &gorm.Config{})
+	db, err := openDB(cfg)
 	if err != nil {
-		return errors.Wrap(err, "failed to connect database")
+		return err
 	}
 
-	err = db.AutoMigrate(&types.User{}, &types.PushSubscription{})
+	if err := migrateOnStart(cfg, db); err != nil {
+		return err
+	}
+
+	keyring, err := NewKeyring(cfg, db)
 	if err != nil {
-		return errors.Wrap(err, "Failed to migrate")
+		return err
 	}
 
 	store := sessions.NewCookieStore(cfg.CookeSecret)
+	store.Options = &sessions.Options{
+		Path:     "/",
+		MaxAge:   3600 * 24 * 365,
+		HttpOnly: true,
+		Secure:   cfg.SecureCookies,
+		SameSite: http.SameSiteLaxMode,
+	}
 	e.Use(session.Middleware(store))
-	e.Use(UserMiddleware(db))
+	e.Use(CSRFMiddleware(cfg))
+	e.Use(CSRFTemplateMiddleware())
+	e.Use(VapidKeyMiddleware(keyring))
+	e.Use(UserMiddleware(db, cfg))
+	e.Use(Require2FAMiddleware(db))
 
 	e.GET("/serviceWorker.js", func(c echo.Context) error {
 		sw, err := static.FS.ReadFile("serviceWorker.js")
@@ -129,39 +186,176 @@ func run() error {
 
 	// Pages
 	e.GET("/", homePageHandler(cfg, db))
+	registerGauges(db)
+	e.GET("/metrics", metricsHandler(cfg))
 	e.GET("/healthz", func(c echo.Context) error {
 		return c.String(http.StatusOK, "ok")
 	})
+	e.GET("/readyz", readyz())
 
 	// Blocks
-	e.GET("/auth/sign-in", signIn(cfg))
-	e.POST("/auth/sign-in", signInWithEmailAndPassword(db, cfg))
-	if cfg.AllowSignup || len(cfg.AllowSignupEmails) > 0 {
-		e.GET("/auth/sign-up", signUp())
+	// The proxy authenticates every request, so the built-in sign in is off
+	if !cfg.ProxyAuth.Enabled() {
+		e.GET("/auth/sign-in", signIn(db, cfg))
+		e.POST("/auth/sign-in", signInWithEmailAndPassword(db, cfg))
+		e.GET("/auth/sign-in/2fa", twoFactorSignIn(cfg))
+		e.POST("/auth/sign-in/2fa", signInWithTOTP(cfg, db))
+		if cfg.OIDC.Enabled() {
+			oidcAuth := NewOIDCAuth(cfg, db)
+			e.GET("/auth/oidc/login", oidcAuth.Login())
+			e.GET("/auth/oidc/callback", oidcAuth.Callback())
+		}
+		e.GET("/auth/sign-up", signUp(db, cfg))
 		e.POST("/auth/sign-up", signUpWithEmailAndPassword(db, cfg))
+		e.POST("/auth/sign-out", signOut(db))
+		e.GET("/auth/forgot", forgotPassword())
+		e.POST("/auth/forgot", requestPasswordReset(cfg, db, newResetNotifier(cfg, db)))
+		e.GET("/auth/reset", resetPassword(cfg, db))
+		e.POST("/auth/reset", resetPasswordWithToken(db))
+		e.GET("/auth/password", changePassword())
+		e.POST("/auth/password", changePasswordWithCurrent(db))
+	}
+
+	// account
+	if !cfg.ProxyAuth.Enabled() {
+		e.GET("/account/sessions", sessionsPage(cfg, db))
+		e.POST("/account/sessions/:id/revoke", revokeSession(cfg, db))
+		e.POST("/account/sessions/revoke-others", revokeOtherSessions(cfg, db))
+		e.GET("/account/2fa", twoFactorPage(cfg, db))
+		e.POST("/account/2fa/enable", enableTwoFactor(cfg, db))
+		e.POST("/account/2fa/disable", disableTwoFactor(cfg, db))
+		e.POST("/account/2fa/recovery-codes", regenerateRecoveryCodes(cfg, db))
 	}
-	e.POST("/auth/sign-out", signOut())
+
+	// admin
+	admin := e.Group("/admin", RequireRole(types.RoleAdmin), AuditAdminMiddleware(db))
+	admin.GET("", adminPage(cfg, db))
+	admin.GET("/audit", auditPage(cfg, db))
+	admin.GET("/audit.json", auditExport(db))
+	admin.POST("/settings/signup", adminSetSignup(cfg, db))
+	admin.POST("/settings/2fa", adminSetRequire2FA(cfg, db))
+	admin.POST("/users/:id/disable", adminSetUserDisabled(cfg, db, true))
+	admin.POST("/users/:id/enable", adminSetUserDisabled(cfg, db, false))
+	admin.POST("/users/:id/role", adminSetUserRole(cfg, db))
+	admin.POST("/users/:id/password", adminResetPassword(cfg, db))
+	admin.POST("/users/:id/reset-link", adminResetLink(cfg, db))
+	admin.POST("/users/:id/push", adminPushUser(cfg, db))
+	admin.POST("/subscriptions/:id/push", adminPushSubscription(cfg, db))
+	admin.POST("/invites", adminCreateInvite(cfg, db))
+	admin.POST("/invites/:id/revoke", adminRevokeInvite(cfg, db))
+	admin.POST("/vapid/rotate", adminRotateVapidKey(cfg, db, keyring))
+	admin.GET("/webhooks", webhooksPage(cfg, db))
+	admin.POST("/webhooks", adminCreateWebhook(cfg, db))
+	admin.POST("/webhooks/:id/enable", adminSetWebhookEnabled(cfg, db, true))
+	admin.POST("/webhooks/:id/disable", adminSetWebhookEnabled(cfg, db, false))
+	admin.POST("/webhooks/:id/delete", adminDeleteWebhook(cfg, db))
+	admin.POST("/webhooks/deliveries/:id/retry", adminRetryWebhookDelivery(cfg, db))
+	admin.POST("/inbound-webhooks", adminCreateInboundWebhook(cfg, db))
+	admin.POST("/inbound-webhooks/:id/enable", adminSetInboundWebhookEnabled(cfg, db, true))
+	admin.POST("/inbound-webhooks/:id/disable", adminSetInboundWebhookEnabled(cfg, db, false))
+	admin.POST("/inbound-webhooks/:id/delete", adminDeleteInboundWebhook(cfg, db))
 
 	// push
-	e.POST("/push/subscribe", saveSubscription(db))
+	e.GET("/push/vapid-key", vapidPublicKey(keyring))
+	e.POST("/push/subscribe", saveSubscription(db, keyring))
+	e.POST("/push/resubscribe", resubscribe(db, keyring))
 	e.POST("/push/unsubscribe", removeSubscription(db))
 	e.POST("/push", pushNotification(cfg, db))
-	e.GET("/redirect", redirect())
+	e.POST("/hooks/:token", inboundWebhook(cfg, db))
+	e.GET("/redirect", redirect(cfg, db))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if cfg.RateLimit.Collapse {
+		go summarizeSuppressed(ctx, cfg, db)
+	}
+	go runWebhooks(ctx, db)
+	go func() {
+		if err := resumePendingDeliveries(cfg, db); err != nil {
+			logrus.Error(err)
+		}
+	}()
+
+	server := e.Server
+	var redirectServer *http.Server
+	if cfg.TLSCertFile != "" {
+		server = e.TLSServer
+		server.TLSConfig, err = tlsConfig(cfg)
+		if err != nil {
+			return err
+		}
+		if cfg.HTTPRedirectAddress != "" {
+			redirectServer = &http.Server{Addr: cfg.HTTPRedirectAddress, Handler: redirectToHTTPS(cfg), ReadHeaderTimeout: 10 * time.Second}
+			go func() {
+				if err := redirectServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+					logrus.Error(errors.Wrap(err, "serving HTTPS redirects"))
+				}
+			}()
+		}
+	}
+	server.Addr = cfg.ListenAddress
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- e.StartServer(server)
+	}()
 
-	return e.Start(":8080")
+	select {
+	case err := <-serveErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+	case <-ctx.Done():
+		stop()
+		drain(cfg, e, redirectServer)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return errors.Wrap(err, "getting database handle")
+	}
+	return errors.Wrap(sqlDB.Close(), "closing database")
 }
 
-func UserMiddleware(db *gorm.DB) echo.MiddlewareFunc {
+func UserMiddleware(db *gorm.DB, cfg types.Config) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
-			sess, _ := session.Get(SessionKey, c)
-			if sess.Values[SessionUserIDKey] != nil {
-				userID := sess.Values[SessionUserIDKey].(uint)
-				user, err := getUserByID(db, userID)
+			if cfg.ProxyAuth.Enabled() {
+				user, ok, err := proxyUser(c, db, cfg)
 				if err != nil {
-					return errors.Wrap(err, "getting user by id")
+					return err
+				}
+				if ok {
+					c.Set(UserKey, user)
+					c.Set(ProxyAuthKey, true)
 				}
+				return next(c)
+			}
+
+			user, record, ok, err := sessionUser(c, db)
+			if err != nil {
+				return err
+			}
+			if ok {
 				c.Set(UserKey, user)
+				c.Set(SessionRecordKey, record)
+			}
+			return next(c)
+		}
+	}
+}
+
+// RequireRole only lets signed in users with the given role through
+func RequireRole(role string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			user, ok := GetSessionUser(c)
+			if !ok {
+				return c.String(http.StatusUnauthorized, "unauthorized")
+			}
+			if user.Role != role {
+				return c.String(http.StatusForbidden, "forbidden")
 			}
 			return next(c)
 		}
@@ -172,15 +366,8 @@ func GetSessionUser(c echo.Context) (types.User, bool) {
 	u := c.Get(UserKey)
 	if u != nil {
 		user := u.(types.User)
-		logrus.Debugf("Found session user %s", user.Email)
+		requestLogger(c).Debugf("Found session user %s", user.Email)
 		return user, true
 	}
 	return types.User{}, false
 }
-
-func redirect() echo.HandlerFunc {
-	return func(c echo.Context) error {
-		target := c.FormValue("target")
-		return c.Redirect(http.StatusFound, target)
-	}
-}
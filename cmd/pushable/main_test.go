@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/pushable/types"
+	"gorm.io/gorm"
+)
+
+// sessionServer is an echo with the cookie session and user middleware, and
+// a route that signs in the user with the given id
+func sessionServer(db *gorm.DB, cfg types.Config) *echo.Echo {
+	e := echo.New()
+	e.Use(session.Middleware(sessions.NewCookieStore([]byte("test"))))
+	e.Use(UserMiddleware(db, cfg))
+	e.POST("/test/sign-in/:id", func(c echo.Context) error {
+		var user types.User
+		if err := db.First(&user, "id = ?", c.Param("id")).Error; err != nil {
+			return err
+		}
+		return startSession(c, db, user)
+	})
+	return e
+}
+
+// signInCookies signs user in on e and returns the session cookies
+func signInCookies(t *testing.T, e *echo.Echo, user types.User) []*http.Cookie {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, fmt.Sprintf("/test/sign-in/%d", user.ID), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("signing in %s = %d %s", user.Email, rec.Code, rec.Body)
+	}
+	return rec.Result().Cookies()
+}
+
+// serveWithCookies sends a request with body to e with cookies set
+func serveWithCookies(e *echo.Echo, method string, target string, body io.Reader, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, body)
+	if body != nil {
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	}
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRequireRole(t *testing.T) {
+	tests := []struct {
+		name string
+		// role is the signed in user's role, empty for no user
+		role string
+		// after changes the user once they have signed in
+		after    map[string]interface{}
+		wantCode int
+	}{
+		{name: "signed out", wantCode: http.StatusUnauthorized},
+		{name: "user", role: types.RoleUser, wantCode: http.StatusForbidden},
+		{name: "admin", role: types.RoleAdmin, wantCode: http.StatusOK},
+		{name: "admin demoted since signing in", role: types.RoleAdmin, after: map[string]interface{}{"role": types.RoleUser}, wantCode: http.StatusForbidden},
+		{name: "admin disabled since signing in", role: types.RoleAdmin, after: map[string]interface{}{"disabled": true}, wantCode: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := testDialects(t)[types.DBDriverSQLite]
+			e := sessionServer(db, types.Config{})
+			e.GET("/admin", func(c echo.Context) error { return c.String(http.StatusOK, "ok") }, RequireRole(types.RoleAdmin))
+
+			var cookies []*http.Cookie
+			if tt.role != "" {
+				user := types.User{Email: "a@example.com", Role: tt.role}
+				if err := db.Create(&user).Error; err != nil {
+					t.Fatal(err)
+				}
+				cookies = signInCookies(t, e, user)
+				if tt.after != nil {
+					if err := db.Model(&user).Updates(tt.after).Error; err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			if rec := serveWithCookies(e, http.MethodGet, "/admin", nil, cookies); rec.Code != tt.wantCode {
+				t.Errorf("GET /admin = %d, want %d", rec.Code, tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/pushable/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+var (
+	pushesReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pushable_pushes_received_total",
+		Help: "Pushes received on /push by result",
+	}, []string{"result"})
+
+	deliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pushable_deliveries_total",
+		Help: "Deliveries to push services by host and status code",
+	}, []string{"host", "code"})
+
+	deliveryRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pushable_delivery_retries_total",
+		Help: "Deliveries retried after a transient failure",
+	}, []string{"host"})
+
+	subscriptionsPruned = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "pushable_subscriptions_pruned_total",
+		Help: "Subscriptions deleted because the push service answered 410 Gone",
+	})
+
+	deliveryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pushable_delivery_duration_seconds",
+		Help:    "Time taken by a single delivery to a push service",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"host"})
+
+	fanoutDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "pushable_fanout_duration_seconds",
+		Help:    "Time taken to deliver a push to every subscription",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 12),
+	})
+
+	signIns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pushable_sign_ins_total",
+		Help: "Sign in attempts by result",
+	}, []string{"result"})
+)
+
+// registerGauges reports table sizes, counted on each scrape
+func registerGauges(db *gorm.DB) {
+	count := func(model interface{}) func() float64 {
+		return func() float64 {
+			var n int64
+			if err := db.Model(model).Count(&n).Error; err != nil {
+				logrus.Errorf("counting for metrics: %v", err)
+			}
+			return float64(n)
+		}
+	}
+
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "pushable_subscriptions",
+		Help: "Stored push subscriptions",
+	}, count(&types.PushSubscription{}))
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "pushable_users",
+		Help: "Registered users",
+	}, count(&types.User{}))
+}
+
+// metricsHandler serves /metrics, requiring the bearer token when one is
+// configured
+func metricsHandler(cfg types.Config) echo.HandlerFunc {
+	handler := echo.WrapHandler(promhttp.Handler())
+	return func(c echo.Context) error {
+		if cfg.MetricsToken != "" {
+			token, _ := strings.CutPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(cfg.MetricsToken)) != 1 {
+				return c.String(http.StatusUnauthorized, "unauthorized")
+			}
+		}
+		return handler(c)
+	}
+}
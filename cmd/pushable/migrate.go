@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/oliverisaac/pushable/migrations"
+	"github.com/oliverisaac/pushable/types"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const migrateUsage = "usage: pushable migrate status|up|down [flags]"
+
+func migrateCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf(migrateUsage)
+	}
+
+	_, db, err := commandDB(flag.NewFlagSet("pushable migrate "+args[0], flag.ContinueOnError), args[1:])
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "status":
+		status, err := migrations.Status(db)
+		if err != nil {
+			return err
+		}
+		for _, s := range status {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d %-24s %s\n", s.Version, s.Name, applied)
+		}
+	case "up":
+		ran, err := migrations.Up(db)
+		for _, m := range ran {
+			fmt.Printf("applied %04d %s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(ran) == 0 {
+			fmt.Println("already up to date")
+		}
+	case "down":
+		m, err := migrations.Down(db)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("reverted %04d %s\n", m.Version, m.Name)
+	default:
+		return fmt.Errorf(migrateUsage)
+	}
+	return nil
+}
+
+// migrateOnStart brings the schema up to date, or checks that it is when
+// auto migration is off
+func migrateOnStart(cfg types.Config, db *gorm.DB) error {
+	if !cfg.AutoMigrate {
+		pending, err := migrations.Pending(db)
+		if err != nil {
+			return err
+		}
+		if pending > 0 {
+			return fmt.Errorf("%d migrations are pending, run pushable migrate up", pending)
+		}
+		return nil
+	}
+
+	ran, err := migrations.Up(db)
+	for _, m := range ran {
+		logrus.Infof("Applied migration %04d %s", m.Version, m.Name)
+	}
+	return errors.Wrap(err, "Failed to migrate")
+}
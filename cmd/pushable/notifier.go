@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oliverisaac/pushable/types"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// ResetNotifier delivers a password reset link to a user
+type ResetNotifier interface {
+	NotifyReset(user types.User, link string) error
+}
+
+func newResetNotifier(cfg types.Config, db *gorm.DB) ResetNotifier {
+	switch cfg.ResetNotifier {
+	case types.ResetNotifierSMTP:
+		return SMTPResetNotifier{SMTP: cfg.SMTP, Hostname: cfg.Hostname}
+	case types.ResetNotifierPush:
+		return PushResetNotifier{cfg: cfg, db: db}
+	default:
+		return AdminResetNotifier{}
+	}
+}
+
+// AdminResetNotifier does not deliver anything. The user has to ask an admin
+// to generate a reset link from the admin panel.
+type AdminResetNotifier struct{}
+
+func (AdminResetNotifier) NotifyReset(user types.User, link string) error {
+	logrus.Infof("Password reset requested for %s, an admin can generate a reset link from /admin", user.Email)
+	return nil
+}
+
+// SMTPResetNotifier emails the reset link
+type SMTPResetNotifier struct {
+	SMTP     types.SMTPConfig
+	Hostname string
+}
+
+func (n SMTPResetNotifier) NotifyReset(user types.User, link string) error {
+	var auth smtp.Auth
+	if n.SMTP.Username != "" {
+		auth = smtp.PlainAuth("", n.SMTP.Username, n.SMTP.Password, n.SMTP.Host)
+	}
+
+	msg := strings.Join([]string{
+		fmt.Sprintf("From: %s", n.SMTP.From),
+		fmt.Sprintf("To: %s", user.Email),
+		"Subject: Reset your Pushable password",
+		fmt.Sprintf("Date: %s", time.Now().Format(time.RFC1123Z)),
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		fmt.Sprintf("Someone asked to reset the password for your account on %s.", n.Hostname),
+		"",
+		fmt.Sprintf("Open this link to choose a new password: %s", link),
+		"",
+		"If this wasn't you, you can ignore this email.",
+	}, "\r\n")
+
+	addr := n.SMTP.Host + ":" + strconv.Itoa(n.SMTP.Port)
+	err := smtp.SendMail(addr, auth, n.SMTP.From, []string{user.Email}, []byte(msg))
+	return errors.Wrap(err, "sending password reset email")
+}
+
+// PushResetNotifier sends the reset link to the devices the user already
+// subscribed
+type PushResetNotifier struct {
+	cfg types.Config
+	db  *gorm.DB
+}
+
+func (n PushResetNotifier) NotifyReset(user types.User, link string) error {
+	if len(user.PushSubscriptions) == 0 {
+		return fmt.Errorf("%s has no subscribed devices", user.Email)
+	}
+
+	// The link carries the reset token, so it's kept out of sendPush, which
+	// would hand it to anything that saves the push
+	notification := types.Notification{
+		Topic: "pushable-password-reset",
+		Title: "Reset your Pushable password",
+		Body:  "Tap to choose a new password. If this wasn't you, ignore this notification.",
+		Icon:  fmt.Sprintf("https://%s/static/neutral.png", n.cfg.Hostname),
+	}
+	if err := n.db.Create(&notification).Error; err != nil {
+		return errors.Wrap(err, "saving notification")
+	}
+	notification.Link = link
+	notification.Secret = true
+	return fanOut(n.cfg, n.db, notification, user.PushSubscriptions)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/base64"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/oliverisaac/pushable/types"
+)
+
+// smtpMessage is what the stand-in server received
+type smtpMessage struct {
+	auth string
+	from string
+	to   []string
+	data string
+}
+
+// smtpStandIn accepts one SMTP session on a local port and sends what it
+// received on the returned channel. rejectRcpt makes it refuse recipients.
+func smtpStandIn(t *testing.T, rejectRcpt bool) (string, int, <-chan smtpMessage) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	received := make(chan smtpMessage, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		text := textproto.NewConn(conn)
+		var msg smtpMessage
+		defer func() { received <- msg }()
+
+		text.PrintfLine("220 localhost ESMTP stand-in")
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return
+			}
+			verb, arg, _ := strings.Cut(line, " ")
+			switch strings.ToUpper(verb) {
+			case "EHLO", "HELO":
+				text.PrintfLine("250-localhost")
+				text.PrintfLine("250 AUTH PLAIN")
+			case "AUTH":
+				_, creds, _ := strings.Cut(arg, " ")
+				decoded, _ := base64.StdEncoding.DecodeString(creds)
+				msg.auth = string(decoded)
+				text.PrintfLine("235 ok")
+			case "MAIL":
+				msg.from = arg
+				text.PrintfLine("250 ok")
+			case "RCPT":
+				if rejectRcpt {
+					text.PrintfLine("550 no such user")
+					continue
+				}
+				msg.to = append(msg.to, arg)
+				text.PrintfLine("250 ok")
+			case "DATA":
+				text.PrintfLine("354 go ahead")
+				data, err := text.ReadDotLines()
+				if err != nil {
+					return
+				}
+				msg.data = strings.Join(data, "\n")
+				text.PrintfLine("250 queued")
+			case "QUIT":
+				text.PrintfLine("221 bye")
+				return
+			default:
+				text.PrintfLine("250 ok")
+			}
+		}
+	}()
+
+	addr := l.Addr().(*net.TCPAddr)
+	return "localhost", addr.Port, received
+}
+
+func TestSMTPResetNotifier(t *testing.T) {
+	tests := []struct {
+		name       string
+		username   string
+		password   string
+		rejectRcpt bool
+		wantErr    bool
+		wantAuth   string
+	}{
+		{name: "anonymous"},
+		{name: "plain auth", username: "pushable", password: "hunter2", wantAuth: "\x00pushable\x00hunter2"},
+		{name: "recipient refused", rejectRcpt: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, port, received := smtpStandIn(t, tt.rejectRcpt)
+			notifier := SMTPResetNotifier{
+				SMTP: types.SMTPConfig{
+					Host:     host,
+					Port:     port,
+					Username: tt.username,
+					Password: tt.password,
+					From:     "pushable@example.com",
+				},
+				Hostname: "push.example.com",
+			}
+
+			user := types.User{Email: "a@example.com"}
+			link := "https://push.example.com/auth/reset?token=abc"
+			err := notifier.NotifyReset(user, link)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NotifyReset() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			msg := <-received
+			if tt.wantErr {
+				return
+			}
+
+			if msg.auth != tt.wantAuth {
+				t.Errorf("auth = %q, want %q", msg.auth, tt.wantAuth)
+			}
+			if msg.from != "FROM:<pushable@example.com>" {
+				t.Errorf("MAIL %s, want FROM:<pushable@example.com>", msg.from)
+			}
+			if len(msg.to) != 1 || msg.to[0] != "TO:<a@example.com>" {
+				t.Errorf("RCPT %v, want TO:<a@example.com>", msg.to)
+			}
+			for _, want := range []string{"To: a@example.com", "Subject: Reset your Pushable password", link} {
+				if !strings.Contains(msg.data, want) {
+					t.Errorf("message is missing %q:\n%s", want, msg.data)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/pushable/types"
+	"github.com/oliverisaac/pushable/views"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+const SessionOIDCStateKey = "oidc-state"
+const SessionOIDCNonceKey = "oidc-nonce"
+const SessionOIDCVerifierKey = "oidc-verifier"
+
+// OIDCAuth signs users in with an OpenID Connect provider. The provider is
+// discovered on first use so an unreachable issuer doesn't stop startup.
+type OIDCAuth struct {
+	cfg types.Config
+	db  *gorm.DB
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+}
+
+func NewOIDCAuth(cfg types.Config, db *gorm.DB) *OIDCAuth {
+	return &OIDCAuth{cfg: cfg, db: db}
+}
+
+func (o *OIDCAuth) discover(ctx context.Context) (*oidc.Provider, oauth2.Config, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.provider == nil {
+		provider, err := oidc.NewProvider(ctx, o.cfg.OIDC.Issuer)
+		if err != nil {
+			return nil, oauth2.Config{}, errors.Wrap(err, "discovering oidc provider")
+		}
+		o.provider = provider
+	}
+
+	return o.provider, oauth2.Config{
+		ClientID:     o.cfg.OIDC.ClientID,
+		ClientSecret: o.cfg.OIDC.ClientSecret,
+		RedirectURL:  o.cfg.OIDC.RedirectURL,
+		Endpoint:     o.provider.Endpoint(),
+		Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+	}, nil
+}
+
+func (o *OIDCAuth) Login() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		_, oauthCfg, err := o.discover(c.Request().Context())
+		if err != nil {
+			return err
+		}
+
+		state, err := randomToken(24)
+		if err != nil {
+			return err
+		}
+		nonce, err := randomToken(24)
+		if err != nil {
+			return err
+		}
+		verifier := oauth2.GenerateVerifier()
+
+		sess, _ := session.Get(SessionKey, c)
+		sess.Values[SessionOIDCStateKey] = state
+		sess.Values[SessionOIDCNonceKey] = nonce
+		sess.Values[SessionOIDCVerifierKey] = verifier
+		if err := sess.Save(c.Request(), c.Response()); err != nil {
+			return errors.Wrap(err, "saving session cookie")
+		}
+
+		url := oauthCfg.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+		return c.Redirect(http.StatusFound, url)
+	}
+}
+
+type oidcClaims struct {
+	Email         string `json:"email"`
+	EmailVerified *bool  `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+// groups reads the configured groups claim, which providers send as either a
+// list or a single string
+func (o *OIDCAuth) groups(idToken *oidc.IDToken) []string {
+	var raw map[string]interface{}
+	if err := idToken.Claims(&raw); err != nil {
+		return nil
+	}
+
+	switch v := raw[o.cfg.OIDC.GroupsClaim].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var groups []string
+		for _, g := range v {
+			if s, ok := g.(string); ok {
+				groups = append(groups, s)
+			}
+		}
+		return groups
+	}
+	return nil
+}
+
+func (o *OIDCAuth) Callback() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		cfg, err := effectiveConfig(o.db, o.cfg)
+		if err != nil {
+			return err
+		}
+
+		user, err := o.callbackUser(c, cfg)
+		if err != nil {
+			// Saves the session without the state and verifier, so the
+			// callback can't be tried again
+			sess, _ := session.Get(SessionKey, c)
+			if err := sess.Save(c.Request(), c.Response()); err != nil {
+				return errors.Wrap(err, "saving session cookie")
+			}
+			return render(c, 422, views.SignInPage(cfg, err))
+		}
+
+		if user.TOTPEnabled {
+			if err := beginTwoFactorSignIn(c, user); err != nil {
+				return err
+			}
+			return c.Redirect(http.StatusFound, "/auth/sign-in/2fa")
+		}
+
+		if err := completeSignIn(cfg, o.db, c, user); err != nil {
+			return err
+		}
+		return c.Redirect(http.StatusFound, "/")
+	}
+}
+
+// callbackUser completes the code exchange and returns the matching user,
+// creating them if sign-up rules allow it
+func (o *OIDCAuth) callbackUser(c echo.Context, cfg types.Config) (types.User, error) {
+	ctx := c.Request().Context()
+	provider, oauthCfg, err := o.discover(ctx)
+	if err != nil {
+		return types.User{}, err
+	}
+
+	sess, _ := session.Get(SessionKey, c)
+	state, _ := sess.Values[SessionOIDCStateKey].(string)
+	nonce, _ := sess.Values[SessionOIDCNonceKey].(string)
+	verifier, _ := sess.Values[SessionOIDCVerifierKey].(string)
+	delete(sess.Values, SessionOIDCStateKey)
+	delete(sess.Values, SessionOIDCNonceKey)
+	delete(sess.Values, SessionOIDCVerifierKey)
+
+	if state == "" || c.QueryParam("state") != state {
+		return types.User{}, fmt.Errorf("Oops! Your sign in expired, please try again")
+	}
+	if e := c.QueryParam("error"); e != "" {
+		return types.User{}, fmt.Errorf("Oops! %s refused the sign in: %s", cfg.OIDC.Name, e)
+	}
+
+	token, err := oauthCfg.Exchange(ctx, c.QueryParam("code"), oauth2.VerifierOption(verifier))
+	if err != nil {
+		return types.User{}, errors.Wrap(err, "exchanging oidc code")
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return types.User{}, fmt.Errorf("%s did not return an id token", cfg.OIDC.Name)
+	}
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: cfg.OIDC.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return types.User{}, errors.Wrap(err, "verifying id token")
+	}
+	if idToken.Nonce != nonce {
+		return types.User{}, fmt.Errorf("Oops! Your sign in expired, please try again")
+	}
+
+	var claims oidcClaims
+	if err := idToken.Claims(&claims); err != nil {
+		return types.User{}, errors.Wrap(err, "reading id token claims")
+	}
+	if claims.Email == "" {
+		return types.User{}, fmt.Errorf("%s did not share your email address", cfg.OIDC.Name)
+	}
+	if claims.EmailVerified != nil && !*claims.EmailVerified {
+		return types.User{}, fmt.Errorf("Oops! Your email address is not verified with %s", cfg.OIDC.Name)
+	}
+	email := claims.Email
+
+	// An account that signed in with this provider before is found by
+	// its subject, which the provider vouches for
+	var user types.User
+	err = o.db.Preload("PushSubscriptions").First(&user, "oidc_subject = ?", idToken.Subject).Error
+	if err == nil {
+		if user.Disabled {
+			return user, fmt.Errorf("This account has been disabled")
+		}
+		return user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return user, errors.Wrap(err, "finding user")
+	}
+
+	err = o.db.Preload("PushSubscriptions").First(&user, "email = ?", email).Error
+	if err == nil {
+		// Otherwise anyone who can set that address at a provider that
+		// doesn't check it could take the account over
+		if claims.EmailVerified == nil || !*claims.EmailVerified {
+			return types.User{}, fmt.Errorf("Oops! %s did not confirm that %s is verified, so it can't sign in to the existing account", cfg.OIDC.Name, email)
+		}
+		if user.Disabled {
+			return user, fmt.Errorf("This account has been disabled")
+		}
+		if err := o.db.Model(&user).Update("oidc_subject", idToken.Subject).Error; err != nil {
+			return user, errors.Wrap(err, "linking oidc subject")
+		}
+		return user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return user, errors.Wrap(err, "finding user")
+	}
+
+	if !o.mayProvision(cfg, email, o.groups(idToken)) {
+		return user, fmt.Errorf("Oops! %s is not allowed to use Pushable", email)
+	}
+
+	var count int64
+	if err := o.db.Model(&types.User{}).Count(&count).Error; err != nil {
+		return user, errors.Wrap(err, "counting users")
+	}
+
+	user = types.User{
+		Name:        claims.Name,
+		Email:       email,
+		Role:        types.RoleUser,
+		OIDCSubject: idToken.Subject,
+	}
+	if count == 0 {
+		user.Role = types.RoleAdmin
+	}
+	if err := o.db.Create(&user).Error; err != nil {
+		return user, errors.Wrap(err, "creating user")
+	}
+
+	return user, nil
+}
+
+func (o *OIDCAuth) mayProvision(cfg types.Config, email string, groups []string) bool {
+	if cfg.AllowSignup || slices.Contains(cfg.AllowSignupEmails, email) {
+		return true
+	}
+	for _, g := range groups {
+		if slices.Contains(cfg.OIDC.AllowedGroups, g) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/pushable/types"
+)
+
+// mockIssuer is an OpenID provider that signs in whoever the test tells it
+// to. It serves discovery, its signing key and a token endpoint that checks
+// the PKCE verifier.
+type mockIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu     sync.Mutex
+	grants map[string]mockGrant
+}
+
+type mockGrant struct {
+	challenge string
+	claims    map[string]interface{}
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockIssuer{key: key, grants: map[string]mockGrant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                m.URL,
+			"authorization_endpoint":                m.URL + "/authorize",
+			"token_endpoint":                        m.URL + "/token",
+			"jwks_uri":                              m.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test",
+				"alg": "RS256",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		grant, ok := m.grants[r.FormValue("code")]
+		delete(m.grants, r.FormValue("code"))
+		m.mu.Unlock()
+
+		sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     m.sign(t, grant.claims),
+		})
+	})
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+// authorize stands in for the user signing in at the provider. It returns
+// the code the provider would redirect back with.
+func (m *mockIssuer) authorize(t *testing.T, authURL string, clientID string, claims map[string]interface{}) string {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" {
+		t.Fatalf("code_challenge_method = %q, want S256", q.Get("code_challenge_method"))
+	}
+
+	idClaims := map[string]interface{}{
+		"iss":   m.URL,
+		"aud":   clientID,
+		"sub":   "subject",
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": q.Get("nonce"),
+	}
+	for k, v := range claims {
+		idClaims[k] = v
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	code := "code-" + q.Get("state")
+	m.grants[code] = mockGrant{challenge: q.Get("code_challenge"), claims: idClaims}
+	return code
+}
+
+func (m *mockIssuer) sign(t *testing.T, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestOIDCCallbackUser(t *testing.T) {
+	issuer := newMockIssuer(t)
+
+	tests := []struct {
+		name string
+		// existing is signed up before the OIDC sign in
+		existing    *types.User
+		allowSignup bool
+		allowEmails []string
+		groups      []string
+		claims      map[string]interface{}
+		// tamper changes the callback query before it is sent
+		tamper   func(q url.Values)
+		wantErr  string
+		wantRole string
+	}{
+		{
+			name:        "first user is provisioned as admin",
+			allowSignup: true,
+			claims:      map[string]interface{}{"email": "a@example.com", "name": "A"},
+			wantRole:    types.RoleAdmin,
+		},
+		{
+			name:     "existing user signs in",
+			existing: &types.User{Email: "a@example.com", Role: types.RoleUser},
+			claims:   map[string]interface{}{"email": "a@example.com", "email_verified": true},
+			wantRole: types.RoleUser,
+		},
+		{
+			name:     "returning user found by subject",
+			existing: &types.User{Email: "a@example.com", Role: types.RoleUser, OIDCSubject: "subject"},
+			claims:   map[string]interface{}{"email": "a@example.com"},
+			wantRole: types.RoleUser,
+		},
+		{
+			name:     "existing user without email_verified",
+			existing: &types.User{Email: "a@example.com", Role: types.RoleUser},
+			claims:   map[string]interface{}{"email": "a@example.com"},
+			wantErr:  "did not confirm",
+		},
+		{
+			name:     "existing user with an unverified email",
+			existing: &types.User{Email: "a@example.com", Role: types.RoleUser},
+			claims:   map[string]interface{}{"email": "a@example.com", "email_verified": false},
+			wantErr:  "not verified",
+		},
+		{
+			name:     "disabled user is refused",
+			existing: &types.User{Email: "a@example.com", Role: types.RoleUser, Disabled: true},
+			claims:   map[string]interface{}{"email": "a@example.com", "email_verified": true},
+			wantErr:  "disabled",
+		},
+		{
+			name:    "sign up closed",
+			claims:  map[string]interface{}{"email": "a@example.com"},
+			wantErr: "not allowed",
+		},
+		{
+			name:        "allowed email",
+			allowEmails: []string{"a@example.com"},
+			claims:      map[string]interface{}{"email": "a@example.com"},
+			wantRole:    types.RoleAdmin,
+		},
+		{
+			name:     "allowed group",
+			existing: &types.User{Email: "admin@example.com", Role: types.RoleAdmin},
+			groups:   []string{"pushers"},
+			claims:   map[string]interface{}{"email": "a@example.com", "groups": []string{"staff", "pushers"}},
+			wantRole: types.RoleUser,
+		},
+		{
+			name:    "group claim as a string",
+			groups:  []string{"pushers"},
+			claims:  map[string]interface{}{"email": "a@example.com", "groups": "staff"},
+			wantErr: "not allowed",
+		},
+		{
+			name:        "unverified email",
+			allowSignup: true,
+			claims:      map[string]interface{}{"email": "a@example.com", "email_verified": false},
+			wantErr:     "not verified",
+		},
+		{
+			name:        "no email claim",
+			allowSignup: true,
+			claims:      map[string]interface{}{},
+			wantErr:     "did not share",
+		},
+		{
+			name:        "wrong nonce",
+			allowSignup: true,
+			claims:      map[string]interface{}{"email": "a@example.com", "nonce": "replayed"},
+			wantErr:     "expired",
+		},
+		{
+			name:        "wrong state",
+			allowSignup: true,
+			claims:      map[string]interface{}{"email": "a@example.com"},
+			tamper:      func(q url.Values) { q.Set("state", "forged") },
+			wantErr:     "expired",
+		},
+		{
+			name:        "code not issued to this sign in",
+			allowSignup: true,
+			claims:      map[string]interface{}{"email": "a@example.com"},
+			tamper:      func(q url.Values) { q.Set("code", "code-forged") },
+			wantErr:     "invalid_grant",
+		},
+		{
+			name:        "provider error",
+			allowSignup: true,
+			claims:      map[string]interface{}{"email": "a@example.com"},
+			tamper:      func(q url.Values) { q.Set("error", "access_denied") },
+			wantErr:     "access_denied",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := testDialects(t)[types.DBDriverSQLite]
+			if tt.existing != nil {
+				if err := db.Create(tt.existing).Error; err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			cfg := types.Config{
+				AllowSignup:       tt.allowSignup,
+				AllowSignupEmails: tt.allowEmails,
+				OIDC: types.OIDCConfig{
+					Name:          "Test",
+					Issuer:        issuer.URL,
+					ClientID:      "pushable",
+					ClientSecret:  "secret",
+					RedirectURL:   "https://push.example.com/auth/oidc/callback",
+					GroupsClaim:   "groups",
+					AllowedGroups: tt.groups,
+				},
+			}
+			oidcAuth := NewOIDCAuth(cfg, db)
+
+			e := echo.New()
+			e.Use(session.Middleware(sessions.NewCookieStore([]byte("test"))))
+			e.GET("/login", oidcAuth.Login())
+			e.GET("/callback", oidcAuth.Callback())
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/login", nil))
+			if rec.Code != http.StatusFound {
+				t.Fatalf("login = %d %s", rec.Code, rec.Body)
+			}
+			authURL := rec.Header().Get("Location")
+			if !strings.HasPrefix(authURL, issuer.URL+"/authorize") {
+				t.Fatalf("login redirected to %s", authURL)
+			}
+
+			code := issuer.authorize(t, authURL, cfg.OIDC.ClientID, tt.claims)
+			u, _ := url.Parse(authURL)
+			q := url.Values{"code": {code}, "state": {u.Query().Get("state")}}
+			if tt.tamper != nil {
+				tt.tamper(q)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/callback?"+q.Encode(), nil)
+			for _, cookie := range rec.Result().Cookies() {
+				req.AddCookie(cookie)
+			}
+			rec = httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if tt.wantErr != "" {
+				if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), tt.wantErr) {
+					t.Fatalf("callback = %d, want an error containing %q in\n%s", rec.Code, tt.wantErr, rec.Body)
+				}
+				// The state and verifier are gone from the saved session
+				if len(rec.Result().Cookies()) == 0 {
+					t.Error("session was not saved after the error")
+				}
+				return
+			}
+			if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/" {
+				t.Fatalf("callback = %d to %q, want a redirect to /\n%s", rec.Code, rec.Header().Get("Location"), rec.Body)
+			}
+
+			var users []types.User
+			db.Where("email = ?", "a@example.com").Find(&users)
+			if len(users) != 1 || users[0].Role != tt.wantRole || users[0].OIDCSubject != "subject" {
+				t.Errorf("users with the email = %+v, want one %s linked to the subject", users, tt.wantRole)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/pushable/types"
+	"github.com/oliverisaac/pushable/views"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const passwordResetTTL = time.Hour
+
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), 10)
+	if err != nil {
+		return "", errors.Wrap(err, "hashing password")
+	}
+	return string(hash), nil
+}
+
+// createPasswordReset stores a new one-time reset token for user and returns
+// the link that redeems it
+func createPasswordReset(cfg types.Config, db *gorm.DB, user types.User) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	reset := types.PasswordReset{
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(passwordResetTTL),
+	}
+	if err := db.Create(&reset).Error; err != nil {
+		return "", errors.Wrap(err, "saving password reset")
+	}
+
+	return fmt.Sprintf("https://%s/auth/reset?token=%s", cfg.Hostname, url.QueryEscape(token)), nil
+}
+
+// getPasswordReset returns the unused, unexpired reset for token
+func getPasswordReset(db *gorm.DB, token string) (types.PasswordReset, error) {
+	var reset types.PasswordReset
+	err := db.First(&reset, "token_hash = ? AND used_at IS NULL AND expires_at > ?", hashToken(token), time.Now()).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return reset, fmt.Errorf("Oops! That reset link is invalid or has expired")
+	}
+	return reset, errors.Wrap(err, "finding password reset")
+}
+
+func setPassword(tx *gorm.DB, user types.User, password string) error {
+	if password == "" {
+		return fmt.Errorf("Oops! The new password cannot be empty")
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Model(&user).Update("password", hash).Error; err != nil {
+		return errors.Wrap(err, "updating password")
+	}
+
+	return revokeAllSessions(tx, user.ID)
+}
+
+func forgotPassword() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return render(c, 200, views.ForgotPasswordForm(false, nil))
+	}
+}
+
+func requestPasswordReset(cfg types.Config, db *gorm.DB, notifier ResetNotifier) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		email := c.FormValue("email")
+		parsedEmail, err := mail.ParseAddress(email)
+		if err != nil {
+			return render(c, 422, views.ForgotPasswordForm(false, fmt.Errorf("Oops! That email address appears to be invalid")))
+		}
+
+		var user types.User
+		err = db.Preload("PushSubscriptions").First(&user, "email = ?", parsedEmail.Address).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.Wrap(err, "finding user")
+		}
+
+		// Respond the same way whether or not the account exists. The
+		// notifier runs in the background so the response time doesn't
+		// give it away either.
+		if err == nil && !user.Disabled {
+			link, err := createPasswordReset(cfg, db, user)
+			if err != nil {
+				return err
+			}
+			audit(db, c, nil, types.AuditPasswordResetSent, user.Email)
+			log := requestLogger(c)
+			go func() {
+				if err := notifier.NotifyReset(user, link); err != nil {
+					log.Error(errors.Wrapf(err, "notifying %s of password reset", user.Email))
+				}
+			}()
+		}
+
+		return render(c, 200, views.ForgotPasswordForm(true, nil))
+	}
+}
+
+func resetPassword(cfg types.Config, db *gorm.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		token := c.QueryParam("token")
+		_, err := getPasswordReset(db, token)
+		return render(c, 200, views.ResetPasswordPage(cfg, token, false, err))
+	}
+}
+
+func resetPasswordWithToken(db *gorm.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		token := c.FormValue("token")
+		password := c.FormValue("password")
+
+		reset, err := getPasswordReset(db, token)
+		if err != nil {
+			return render(c, 422, views.ResetPasswordForm(token, false, err))
+		}
+
+		var user types.User
+		err = db.Transaction(func(tx *gorm.DB) error {
+			res := tx.Model(&reset).Where("used_at IS NULL").Update("used_at", time.Now())
+			if res.Error != nil {
+				return errors.Wrap(res.Error, "marking password reset used")
+			}
+			if res.RowsAffected == 0 {
+				return fmt.Errorf("Oops! That reset link has already been used")
+			}
+
+			user, err = getUserByID(tx, reset.UserID)
+			if err != nil {
+				return err
+			}
+			// Disabled after the link was sent
+			if user.Disabled {
+				return fmt.Errorf("Oops! That reset link is invalid or has expired")
+			}
+			return setPassword(tx, user, password)
+		})
+		if err != nil {
+			return render(c, 422, views.ResetPasswordForm(token, false, err))
+		}
+		audit(db, c, &user, types.AuditPasswordReset, user.Email)
+
+		return render(c, 200, views.ResetPasswordForm("", true, nil))
+	}
+}
+
+func changePassword() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if _, ok := GetSessionUser(c); !ok {
+			return c.String(http.StatusUnauthorized, "unauthorized")
+		}
+		return render(c, 200, views.ChangePasswordForm(false, nil))
+	}
+}
+
+func changePasswordWithCurrent(db *gorm.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, ok := GetSessionUser(c)
+		if !ok {
+			return c.String(http.StatusUnauthorized, "unauthorized")
+		}
+
+		current := c.FormValue("current_password")
+		password := c.FormValue("password")
+
+		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(current)); err != nil {
+			return render(c, 422, views.ChangePasswordForm(false, fmt.Errorf("Oops! Your current password is incorrect")))
+		}
+
+		if password != c.FormValue("password_confirm") {
+			return render(c, 422, views.ChangePasswordForm(false, fmt.Errorf("Oops! The new passwords do not match")))
+		}
+
+		if err := setPassword(db, user, password); err != nil {
+			return render(c, 422, views.ChangePasswordForm(false, err))
+		}
+		audit(db, c, &user, types.AuditPasswordChanged, user.Email)
+
+		// Every other browser is now signed out, keep this one signed in
+		user, err := getUserByID(db, user.ID)
+		if err != nil {
+			return err
+		}
+		if err := startSession(c, db, user); err != nil {
+			return err
+		}
+
+		return render(c, 200, views.ChangePasswordForm(true, nil))
+	}
+}
+
+func adminResetLink(cfg types.Config, db *gorm.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, err := targetUser(db, c)
+		if err != nil {
+			return renderAdminPanel(cfg, db, c, 422, "", err)
+		}
+		if user.Disabled {
+			return renderAdminPanel(cfg, db, c, 422, "", fmt.Errorf("Enable %s before resetting their password", user.Email))
+		}
+
+		link, err := createPasswordReset(cfg, db, user)
+		if err != nil {
+			return err
+		}
+
+		return renderAdminPanel(cfg, db, c, 200, fmt.Sprintf("Reset link for %s (valid for %s): %s", user.Email, passwordResetTTL, link), nil)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/pushable/types"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// blockingNotifier holds NotifyReset until release is closed
+type blockingNotifier struct {
+	release chan struct{}
+	sent    chan string
+}
+
+func (n blockingNotifier) NotifyReset(user types.User, link string) error {
+	<-n.release
+	n.sent <- user.Email
+	return nil
+}
+
+func TestRequestPasswordReset(t *testing.T) {
+	tests := []struct {
+		name     string
+		email    string
+		disabled bool
+		wantSent bool
+	}{
+		{name: "account", email: "a@example.com", wantSent: true},
+		{name: "disabled account", email: "a@example.com", disabled: true},
+		{name: "no account", email: "b@example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := testDialects(t)[types.DBDriverSQLite]
+			user := types.User{Email: "a@example.com", Role: types.RoleUser, Disabled: tt.disabled}
+			if err := db.Create(&user).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			notifier := blockingNotifier{release: make(chan struct{}), sent: make(chan string, 1)}
+			e := echo.New()
+			e.POST("/forgot", requestPasswordReset(types.Config{Hostname: "push.example.com"}, db, notifier))
+
+			// Answered while the notifier is still blocked
+			req := httptest.NewRequest(http.MethodPost, "/forgot", strings.NewReader(url.Values{"email": {tt.email}}.Encode()))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("forgot = %d %s", rec.Code, rec.Body)
+			}
+			close(notifier.release)
+
+			wait := 200 * time.Millisecond
+			if tt.wantSent {
+				wait = 5 * time.Second
+			}
+			select {
+			case email := <-notifier.sent:
+				if !tt.wantSent {
+					t.Errorf("reset sent to %s", email)
+				}
+			case <-time.After(wait):
+				if tt.wantSent {
+					t.Error("reset was not sent")
+				}
+			}
+		})
+	}
+}
+
+func TestResetPasswordWithToken(t *testing.T) {
+	tests := []struct {
+		name string
+		// disable disables the account after the link was sent
+		disable  bool
+		reuse    bool
+		wantCode int
+	}{
+		{name: "reset", wantCode: http.StatusOK},
+		{name: "account disabled since", disable: true, wantCode: http.StatusUnprocessableEntity},
+		{name: "link used twice", reuse: true, wantCode: http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := testDialects(t)[types.DBDriverSQLite]
+			user := types.User{Email: "a@example.com", Role: types.RoleUser}
+			if err := db.Create(&user).Error; err != nil {
+				t.Fatal(err)
+			}
+			link, err := createPasswordReset(types.Config{Hostname: "push.example.com"}, db, user)
+			if err != nil {
+				t.Fatal(err)
+			}
+			u, _ := url.Parse(link)
+			token := u.Query().Get("token")
+			if tt.disable {
+				db.Model(&user).Update("disabled", true)
+			}
+
+			e := echo.New()
+			e.POST("/reset", resetPasswordWithToken(db))
+			reset := func(password string) int {
+				form := url.Values{"token": {token}, "password": {password}}
+				req := httptest.NewRequest(http.MethodPost, "/reset", strings.NewReader(form.Encode()))
+				req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+				rec := httptest.NewRecorder()
+				e.ServeHTTP(rec, req)
+				return rec.Code
+			}
+
+			password := "new password"
+			if tt.reuse {
+				if code := reset("first"); code != http.StatusOK {
+					t.Fatalf("first reset = %d", code)
+				}
+				password = "second"
+			}
+			if code := reset(password); code != tt.wantCode {
+				t.Errorf("reset = %d, want %d", code, tt.wantCode)
+			}
+
+			if err := db.First(&user, user.ID).Error; err != nil {
+				t.Fatal(err)
+			}
+			changed := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) == nil
+			if changed != (tt.wantCode == http.StatusOK) {
+				t.Errorf("password changed = %v", changed)
+			}
+		})
+	}
+}
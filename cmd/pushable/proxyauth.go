@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net"
+	"net/mail"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/pushable/types"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+const ProxyAuthKey = "proxy-auth"
+
+// proxyUser returns the user named by the reverse proxy's identity headers.
+// The headers are ignored unless the connection itself comes from a trusted
+// CIDR; X-Forwarded-For is deliberately not consulted.
+func proxyUser(c echo.Context, db *gorm.DB, cfg types.Config) (types.User, bool, error) {
+	host, _, err := net.SplitHostPort(c.Request().RemoteAddr)
+	if err != nil {
+		host = c.Request().RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !cfg.ProxyAuth.Trusts(ip) {
+		return types.User{}, false, nil
+	}
+
+	header := c.Request().Header.Get(cfg.ProxyAuth.EmailHeader)
+	if header == "" {
+		return types.User{}, false, nil
+	}
+	parsedEmail, err := mail.ParseAddress(header)
+	if err != nil {
+		requestLogger(c).Warnf("Ignoring invalid %s header %q from proxy", cfg.ProxyAuth.EmailHeader, header)
+		return types.User{}, false, nil
+	}
+	email := parsedEmail.Address
+
+	var user types.User
+	err = db.Preload("PushSubscriptions").First(&user, "email = ?", email).Error
+	if err == nil {
+		return user, !user.Disabled, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return user, false, errors.Wrap(err, "finding proxy user")
+	}
+	if !cfg.ProxyAuth.AutoCreate {
+		return user, false, nil
+	}
+
+	var count int64
+	if err := db.Model(&types.User{}).Count(&count).Error; err != nil {
+		return user, false, errors.Wrap(err, "counting users")
+	}
+
+	user = types.User{
+		Name:  c.Request().Header.Get(cfg.ProxyAuth.UserHeader),
+		Email: email,
+		Role:  types.RoleUser,
+	}
+	if count == 0 {
+		user.Role = types.RoleAdmin
+	}
+	if err := db.Create(&user).Error; err != nil {
+		return user, false, errors.Wrap(err, "creating proxy user")
+	}
+	requestLogger(c).Infof("Created user %s from proxy headers", user.Email)
+
+	return user, true, nil
+}
+
+// ipExtractor decides what c.RealIP() returns. X-Forwarded-For is only
+// believed from PUSHABLE_TRUSTED_PROXIES, otherwise any caller could pick
+// its own address and dodge the per-IP limits.
+func ipExtractor(cfg types.Config) echo.IPExtractor {
+	if len(cfg.TrustedProxies) == 0 {
+		return echo.ExtractIPDirect()
+	}
+	options := []echo.TrustOption{
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false),
+	}
+	for _, cidr := range cfg.TrustedProxies {
+		options = append(options, echo.TrustIPRange(cidr))
+	}
+	return echo.ExtractIPFromXFFHeader(options...)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/pushable/types"
+)
+
+func mustCIDRs(t *testing.T, cidrs ...string) []*net.IPNet {
+	t.Helper()
+	var nets []*net.IPNet
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets
+}
+
+func TestProxyUser(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		autoCreate bool
+		// disabled disables the existing user
+		disabled  bool
+		wantEmail string
+	}{
+		{name: "trusted proxy", remoteAddr: "10.1.2.3:4000", headers: map[string]string{"X-Email": "a@example.com"}, wantEmail: "a@example.com"},
+		{name: "trusted IPv6 proxy", remoteAddr: "[fd00::1]:4000", headers: map[string]string{"X-Email": "a@example.com"}, wantEmail: "a@example.com"},
+		{name: "named address", remoteAddr: "10.1.2.3:4000", headers: map[string]string{"X-Email": "Alice <a@example.com>"}, wantEmail: "a@example.com"},
+		{name: "outside the trusted CIDRs", remoteAddr: "10.2.0.1:4000", headers: map[string]string{"X-Email": "a@example.com"}},
+		{name: "just outside the trusted CIDRs", remoteAddr: "10.1.0.0:4000", headers: map[string]string{"X-Email": "a@example.com"}},
+		{name: "forwarded for a trusted address", remoteAddr: "192.0.2.1:4000", headers: map[string]string{"X-Email": "a@example.com", "X-Forwarded-For": "10.1.2.3"}},
+		{name: "no header", remoteAddr: "10.1.2.3:4000"},
+		{name: "invalid email", remoteAddr: "10.1.2.3:4000", headers: map[string]string{"X-Email": "not an email"}},
+		{name: "disabled user", remoteAddr: "10.1.2.3:4000", headers: map[string]string{"X-Email": "a@example.com"}, disabled: true},
+		{name: "unknown user", remoteAddr: "10.1.2.3:4000", headers: map[string]string{"X-Email": "b@example.com"}},
+		{name: "unknown user created", remoteAddr: "10.1.2.3:4000", headers: map[string]string{"X-Email": "b@example.com", "X-User": "Bob"}, autoCreate: true, wantEmail: "b@example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := testDialects(t)[types.DBDriverSQLite]
+			existing := types.User{Email: "a@example.com", Role: types.RoleUser, Disabled: tt.disabled}
+			if err := db.Create(&existing).Error; err != nil {
+				t.Fatal(err)
+			}
+			cfg := types.Config{ProxyAuth: types.ProxyAuthConfig{
+				TrustedCIDRs: mustCIDRs(t, "10.1.2.0/24", "fd00::/8"),
+				UserHeader:   "X-User",
+				EmailHeader:  "X-Email",
+				AutoCreate:   tt.autoCreate,
+			}}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			c := echo.New().NewContext(req, httptest.NewRecorder())
+
+			user, ok, err := proxyUser(c, db, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.wantEmail != ""; ok != got {
+				t.Fatalf("proxyUser ok = %v, want %v", ok, got)
+			}
+			if ok && user.Email != tt.wantEmail {
+				t.Errorf("proxyUser email = %q, want %q", user.Email, tt.wantEmail)
+			}
+
+			var count int64
+			if err := db.Model(&types.User{}).Count(&count).Error; err != nil {
+				t.Fatal(err)
+			}
+			wantCount := int64(1)
+			if tt.autoCreate {
+				wantCount = 2
+			}
+			if count != wantCount {
+				t.Errorf("%d users, want %d", count, wantCount)
+			}
+		})
+	}
+}
+
+func TestIPExtractor(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustedProxies []string
+		remoteAddr     string
+		forwardedFor   string
+		want           string
+	}{
+		{name: "no trusted proxies", remoteAddr: "10.1.2.3:4000", forwardedFor: "192.0.2.1", want: "10.1.2.3"},
+		{name: "trusted proxy", trustedProxies: []string{"10.1.2.0/24"}, remoteAddr: "10.1.2.3:4000", forwardedFor: "192.0.2.1", want: "192.0.2.1"},
+		{name: "untrusted proxy", trustedProxies: []string{"10.1.2.0/24"}, remoteAddr: "10.9.0.1:4000", forwardedFor: "192.0.2.1", want: "10.9.0.1"},
+		{name: "loopback isn't trusted by default", trustedProxies: []string{"10.1.2.0/24"}, remoteAddr: "127.0.0.1:4000", forwardedFor: "192.0.2.1", want: "127.0.0.1"},
+		{name: "spoofed hop before the proxy", trustedProxies: []string{"10.1.2.0/24"}, remoteAddr: "10.1.2.3:4000", forwardedFor: "203.0.113.9, 192.0.2.1", want: "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := types.Config{TrustedProxies: mustCIDRs(t, tt.trustedProxies...)}
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set(echo.HeaderXForwardedFor, tt.forwardedFor)
+			if got := ipExtractor(cfg)(req); got != tt.want {
+				t.Errorf("ipExtractor = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,10 +1,16 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	webpush "github.com/SherClockHolmes/webpush-go"
 	"github.com/labstack/echo/v4"
@@ -12,6 +18,9 @@ import (
 	"github.com/oliverisaac/pushable/types"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
 )
 
@@ -25,39 +34,113 @@ func removeSubscription(db *gorm.DB) echo.HandlerFunc {
 		if err := db.Delete(user.PushSubscriptions).Error; err != nil {
 			return errors.Wrap(err, "removing subscription")
 		}
+		audit(db, c, &user, types.AuditSubscriptionRemoved, fmt.Sprintf("%d device(s)", len(user.PushSubscriptions)))
 
 		return c.String(http.StatusOK, "subscription removed")
 	}
 }
 
-func saveSubscription(db *gorm.DB) echo.HandlerFunc {
+// subscriptionRequest is a browser subscription along with the VAPID public
+// key it was made with
+type subscriptionRequest struct {
+	webpush.Subscription
+	VapidPublicKey string `json:"vapidPublicKey"`
+}
+
+// apply copies the browser subscription onto a stored one
+func (r subscriptionRequest) apply(keyring *Keyring, pushSubscription *types.PushSubscription) error {
+	keys, err := json.Marshal(r.Keys)
+	if err != nil {
+		return errors.Wrap(err, "marshalling subscription keys")
+	}
+	key, err := keyring.KeyFor(r.VapidPublicKey)
+	if err != nil {
+		return err
+	}
+
+	pushSubscription.VapidKeyID = key.ID
+	pushSubscription.Endpoint = r.Endpoint
+	pushSubscription.P256DH = r.Keys.P256dh
+	pushSubscription.Auth = r.Keys.Auth
+	pushSubscription.Keys = string(keys)
+	return nil
+}
+
+func saveSubscription(db *gorm.DB, keyring *Keyring) echo.HandlerFunc {
 	return func(c echo.Context) error {
+		db := db.WithContext(c.Request().Context())
 		user, ok := GetSessionUser(c)
 		if !ok {
 			return c.String(http.StatusUnauthorized, "unauthorized")
 		}
 
-		var sub webpush.Subscription
-		if err := c.Bind(&sub); err != nil {
+		var req subscriptionRequest
+		if err := c.Bind(&req); err != nil {
 			return errors.Wrap(err, "binding subscription")
 		}
 
-		keys, err := json.Marshal(sub.Keys)
-		if err != nil {
-			return errors.Wrap(err, "marshalling subscription keys")
+		pushSubscription := types.PushSubscription{UserID: user.ID}
+		if err := req.apply(keyring, &pushSubscription); err != nil {
+			return err
 		}
 
-		pushSubscription := types.PushSubscription{
-			UserID:   user.ID,
-			Endpoint: sub.Endpoint,
-			P256DH:   sub.Keys.P256dh,
-			Auth:     sub.Keys.Auth,
-			Keys:     string(keys),
+		if err := db.Create(&pushSubscription).Error; err != nil {
+			return errors.Wrap(err, "saving subscription")
 		}
+		audit(db, c, &user, types.AuditSubscriptionCreated, fmt.Sprintf("subscription %d", pushSubscription.ID))
+		emitWebhook(db, types.WebhookSubscriptionCreated, map[string]interface{}{
+			"subscription_id": pushSubscription.ID,
+			"user_id":         user.ID,
+			"email":           user.Email,
+			"endpoint_host":   pushServiceHost(pushSubscription.Endpoint),
+		})
 
-		if err := db.Create(&pushSubscription).Error; err != nil {
+		return c.String(http.StatusOK, "subscription saved")
+	}
+}
+
+// resubscribeRequest replaces a subscription made with an old VAPID key. The
+// old endpoint and auth secret prove the caller owns the subscription, since
+// service workers re-subscribe without a session.
+type resubscribeRequest struct {
+	Old struct {
+		Endpoint string       `json:"endpoint"`
+		Keys     webpush.Keys `json:"keys"`
+	} `json:"old"`
+	Subscription subscriptionRequest `json:"subscription"`
+}
+
+func resubscribe(db *gorm.DB, keyring *Keyring) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		db := db.WithContext(c.Request().Context())
+
+		var req resubscribeRequest
+		if err := c.Bind(&req); err != nil {
+			return errors.Wrap(err, "binding subscription")
+		}
+		if req.Old.Endpoint == "" || req.Old.Keys.Auth == "" || req.Subscription.Endpoint == "" {
+			return c.String(http.StatusBadRequest, "old and new subscriptions are required")
+		}
+
+		var pushSubscription types.PushSubscription
+		err := db.First(&pushSubscription, "endpoint = ?", req.Old.Endpoint).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.String(http.StatusNotFound, "subscription not found")
+		}
+		if err != nil {
+			return errors.Wrap(err, "finding subscription")
+		}
+		if subtle.ConstantTimeCompare([]byte(pushSubscription.Auth), []byte(req.Old.Keys.Auth)) != 1 {
+			return c.String(http.StatusNotFound, "subscription not found")
+		}
+
+		if err := req.Subscription.apply(keyring, &pushSubscription); err != nil {
+			return err
+		}
+		if err := db.Save(&pushSubscription).Error; err != nil {
 			return errors.Wrap(err, "saving subscription")
 		}
+		requestLogger(c).WithField("subscription_id", pushSubscription.ID).Infof("Moved subscription to vapid key %d", pushSubscription.VapidKeyID)
 
 		return c.String(http.StatusOK, "subscription saved")
 	}
@@ -65,6 +148,7 @@ func saveSubscription(db *gorm.DB) echo.HandlerFunc {
 
 func pushNotification(cfg types.Config, db *gorm.DB) echo.HandlerFunc {
 	return func(c echo.Context) error {
+		db := db.WithContext(c.Request().Context())
 		push := pushclient.Push{
 			Topic: c.FormValue("topic"),
 			Title: c.FormValue("title"),
@@ -73,62 +157,317 @@ func pushNotification(cfg types.Config, db *gorm.DB) echo.HandlerFunc {
 			Link:  c.FormValue("link"),
 			Badge: c.FormValue("badge"),
 		}
+		return acceptPush(c, cfg, db, push, c.RealIP())
+	}
+}
+
+// acceptPush applies the rate limits to a push from caller and sends it to
+// every enabled user
+func acceptPush(c echo.Context, cfg types.Config, db *gorm.DB, push pushclient.Push, caller string) error {
+	limits := cfg.RateLimit
+	if wait, err := takeToken(db, "caller:"+caller, limits.Caller); err != nil {
+		return err
+	} else if wait > 0 {
+		pushesReceived.WithLabelValues("rate_limited").Inc()
+		return tooManyRequests(c, wait, caller)
+	}
 
-		var users []types.User
-		if err := db.Preload("PushSubscriptions").Find(&users).Error; err != nil {
-			return errors.Wrap(err, "finding users by topic")
+	topicKey := topicBucketPrefix + push.Topic
+	if wait, err := takeToken(db, topicKey, limits.Topic); err != nil {
+		return err
+	} else if wait > 0 {
+		if !limits.Collapse {
+			pushesReceived.WithLabelValues("rate_limited").Inc()
+			return tooManyRequests(c, wait, "topic "+push.Topic)
 		}
+		if err := suppressPush(db, topicKey); err != nil {
+			return err
+		}
+		pushesReceived.WithLabelValues("collapsed").Inc()
+		return c.String(http.StatusAccepted, "push collapsed into a summary notification")
+	}
+
+	users, err := pushRecipients(db)
+	if err != nil {
+		return err
+	}
 
-		for _, i := range []string{"fail", "success", "good", "bad", "neutral", "mid"} {
-			if strings.HasPrefix(strings.ToLower(push.Icon), i) {
-				push.Icon = fmt.Sprintf("https://%s/static/%s.png", cfg.Hostname, i)
+	var subscriptions []types.PushSubscription
+	var allowed int
+	var minWait time.Duration
+	for _, user := range users {
+		wait, err := takeToken(db, fmt.Sprintf("user:%d", user.ID), limits.User)
+		if err != nil {
+			return err
+		}
+		if wait > 0 {
+			requestLogger(c).Infof("Skipping push to %s, rate limited for %s", user.Email, wait.Round(time.Second))
+			if minWait == 0 || wait < minWait {
+				minWait = wait
 			}
+			continue
 		}
+		allowed++
+		subscriptions = append(subscriptions, user.PushSubscriptions...)
+	}
+	if allowed == 0 && minWait > 0 {
+		pushesReceived.WithLabelValues("rate_limited").Inc()
+		return tooManyRequests(c, minWait, "every recipient")
+	}
+
+	if err := sendPush(cfg, db, push, subscriptions); err != nil {
+		return err
+	}
+	pushesReceived.WithLabelValues("sent").Inc()
+	auditSessionUser(db, c, types.AuditPushSent, fmt.Sprintf("%s: %s", push.Topic, push.Title))
 
-		for _, user := range users {
-			for _, subData := range user.PushSubscriptions {
-				sub := &webpush.Subscription{
-					Endpoint: subData.Endpoint,
-					Keys: webpush.Keys{
-						P256dh: subData.P256DH,
-						Auth:   subData.Auth,
-					},
-				}
-
-				pushPayload, err := json.Marshal(map[string]interface{}{
-					"title": push.Title,
-					"body":  push.Body,
-					"icon":  push.Icon,
-					"badge": push.Badge,
-					"data": map[string]string{
-						"link": push.Link,
-					},
-				})
-				if err != nil {
-					return errors.Wrap(err, "marshalling push payload")
-				}
-
-				resp, err := webpush.SendNotification(pushPayload, sub, &webpush.Options{
-					Topic:           push.Topic,
-					VAPIDPublicKey:  cfg.VapidPublicKey,
-					VAPIDPrivateKey: cfg.VapidPrivateKey,
-					TTL:             3600,
-					Urgency:         webpush.UrgencyNormal,
-				})
-				if err != nil {
-					logrus.Error(errors.Wrap(err, "sending push notification"))
-					continue
-				}
-				defer resp.Body.Close()
-
-				if resp.StatusCode == 410 {
-					if err := db.Delete(&subData).Error; err != nil {
-						logrus.Error(errors.Wrap(err, "deleting subscription"))
-					}
-				}
+	return c.String(http.StatusOK, "push notifications sent")
+}
+
+// pushRecipients returns the enabled users with their subscriptions
+func pushRecipients(db *gorm.DB) ([]types.User, error) {
+	var users []types.User
+	if err := db.Preload("PushSubscriptions").Where("disabled IS NOT TRUE").Find(&users).Error; err != nil {
+		return nil, errors.Wrap(err, "finding users")
+	}
+	return users, nil
+}
+
+// sendPush delivers push to every subscription. Subscriptions the push
+// service reports as gone are removed.
+func sendPush(cfg types.Config, db *gorm.DB, push pushclient.Push, subscriptions []types.PushSubscription) error {
+	for _, i := range []string{"fail", "success", "good", "bad", "neutral", "mid"} {
+		if strings.HasPrefix(strings.ToLower(push.Icon), i) {
+			push.Icon = fmt.Sprintf("https://%s/static/%s.png", cfg.Hostname, i)
+		}
+	}
+
+	notification := types.Notification{
+		Topic: push.Topic,
+		Title: push.Title,
+		Body:  push.Body,
+		Icon:  push.Icon,
+		Badge: push.Badge,
+		Link:  push.Link,
+	}
+	if err := db.Create(&notification).Error; err != nil {
+		return errors.Wrap(err, "saving notification")
+	}
+
+	return fanOut(cfg, db, notification, subscriptions)
+}
+
+// fanOut delivers a saved notification to each subscription. If the drain
+// timeout passes during shutdown, the deliveries it didn't finish are saved
+// for the next start, as are all of them if the drain has already stopped
+// waiting for fan-outs.
+func fanOut(cfg types.Config, db *gorm.DB, notification types.Notification, subscriptions []types.PushSubscription) error {
+	if !startFanOut() {
+		return savePendingDeliveries(db, notification, subscriptions)
+	}
+	defer fanouts.Done()
+
+	// Each subscription is signed with the key it was made with
+	var vapidKeys []types.VapidKey
+	if err := db.Find(&vapidKeys).Error; err != nil {
+		return errors.Wrap(err, "finding vapid keys")
+	}
+	keysByID := map[uint]types.VapidKey{}
+	var activeKey types.VapidKey
+	for _, key := range vapidKeys {
+		keysByID[key.ID] = key
+		if key.Active {
+			activeKey = key
+		}
+	}
+
+	data := map[string]string{
+		"link": notification.Link,
+		"nid":  strconv.FormatUint(uint64(notification.ID), 10),
+		// Lets the service worker notice the key has been rotated
+		"vapid": activeKey.PublicKey,
+	}
+	if notification.Link != "" {
+		data["sig"] = signLink(cfg, notification.ID, notification.Link)
+	}
+
+	pushPayload, err := json.Marshal(map[string]interface{}{
+		"title": notification.Title,
+		"body":  notification.Body,
+		"icon":  notification.Icon,
+		"badge": notification.Badge,
+		"data":  data,
+	})
+	if err != nil {
+		return errors.Wrap(err, "marshalling push payload")
+	}
+
+	// A caller hanging up shouldn't stop the fan-out, only shutdown should
+	ctx, cancel := abandonable(context.WithoutCancel(db.Statement.Context))
+	defer cancel()
+	db = db.WithContext(context.WithoutCancel(ctx))
+	log := loggerFrom(ctx).WithField("notification_id", notification.ID)
+
+	fanoutStart := time.Now()
+	defer func() { fanoutDuration.Observe(time.Since(fanoutStart).Seconds()) }()
+
+	var delivered, failed int
+	defer func() {
+		emitWebhook(db, types.WebhookNotificationSent, map[string]interface{}{
+			"notification_id": notification.ID,
+			"topic":           notification.Topic,
+			"title":           notification.Title,
+			"subscriptions":   len(subscriptions),
+			"delivered":       delivered,
+			"failed":          failed,
+		})
+	}()
+
+	for i, subData := range subscriptions {
+		if ctx.Err() != nil {
+			return savePendingDeliveries(db, notification, subscriptions[i:])
+		}
+
+		sub := &webpush.Subscription{
+			Endpoint: subData.Endpoint,
+			Keys: webpush.Keys{
+				P256dh: subData.P256DH,
+				Auth:   subData.Auth,
+			},
+		}
+
+		key, ok := keysByID[subData.VapidKeyID]
+		if !ok {
+			key = activeKey
+		}
+
+		start := time.Now()
+		resp, err := deliver(ctx, subData.ID, pushPayload, sub, &webpush.Options{
+			Topic:           notification.Topic,
+			VAPIDPublicKey:  key.PublicKey,
+			VAPIDPrivateKey: key.PrivateKey,
+			Subscriber:      cfg.VapidSubscriber,
+			TTL:             int(cfg.PushTTL.Seconds()),
+			Urgency:         webpush.UrgencyNormal,
+		})
+		entry := log.WithFields(logrus.Fields{
+			"subscription_id": subData.ID,
+			"endpoint_host":   pushServiceHost(subData.Endpoint),
+			"latency_ms":      time.Since(start).Milliseconds(),
+		})
+		if err != nil && ctx.Err() != nil {
+			return savePendingDeliveries(db, notification, subscriptions[i:])
+		}
+		failure := map[string]interface{}{
+			"notification_id": notification.ID,
+			"subscription_id": subData.ID,
+			"user_id":         subData.UserID,
+			"endpoint_host":   pushServiceHost(subData.Endpoint),
+		}
+		if err != nil {
+			entry.WithError(err).Error("push delivery failed")
+			failed++
+			failure["error"] = err.Error()
+			emitWebhook(db, types.WebhookDeliveryFailed, failure)
+			continue
+		}
+		resp.Body.Close()
+
+		entry = entry.WithField("status", resp.StatusCode)
+		if resp.StatusCode >= 400 {
+			entry.Warn("push service rejected delivery")
+			failed++
+			failure["status"] = resp.StatusCode
+			emitWebhook(db, types.WebhookDeliveryFailed, failure)
+		} else {
+			entry.Info("push delivered")
+			delivered++
+		}
+
+		if resp.StatusCode == 410 {
+			if err := db.Delete(&subData).Error; err != nil {
+				entry.WithError(err).Error("deleting gone subscription")
+				continue
 			}
+			subscriptionsPruned.Inc()
+			emitWebhook(db, types.WebhookSubscriptionPruned, map[string]interface{}{
+				"subscription_id": subData.ID,
+				"user_id":         subData.UserID,
+				"endpoint_host":   pushServiceHost(subData.Endpoint),
+			})
 		}
+	}
+
+	return nil
+}
+
+// deliveryAttempts is how many times a delivery is tried when the push
+// service fails transiently
+const deliveryAttempts = 3
+
+// deliver sends one notification, retrying network errors, 429s and 5xx
+// responses with a short backoff
+func deliver(ctx context.Context, subscriptionID uint, payload []byte, sub *webpush.Subscription, options *webpush.Options) (*http.Response, error) {
+	host := pushServiceHost(sub.Endpoint)
+
+	var resp *http.Response
+	var err error
+	for attempt := 1; ; attempt++ {
+		spanCtx, span := tracer.Start(ctx, "webpush.SendNotification", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+			attribute.String("server.address", host),
+			attribute.Int("pushable.subscription.id", int(subscriptionID)),
+			attribute.Int("pushable.delivery.attempt", attempt),
+		))
+		start := time.Now()
+		resp, err = webpush.SendNotificationWithContext(spanCtx, payload, sub, options)
+		deliveryDuration.WithLabelValues(host).Observe(time.Since(start).Seconds())
+
+		code := "error"
+		if err == nil {
+			code = strconv.Itoa(resp.StatusCode)
+			span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+			if resp.StatusCode >= 400 {
+				span.SetStatus(codes.Error, resp.Status)
+			}
+		} else {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+		deliveries.WithLabelValues(host, code).Inc()
+
+		if !transientDeliveryFailure(resp, err) || attempt == deliveryAttempts {
+			return resp, err
+		}
+		if err == nil {
+			resp.Body.Close()
+		}
+
+		deliveryRetries.WithLabelValues(host).Inc()
+		select {
+		case <-time.After(time.Duration(attempt) * 500 * time.Millisecond):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// transientDeliveryFailure reports whether a delivery is worth trying again.
+// Only network errors and the push service asking us to back off are;
+// errors from building the request, such as a bad subscription key, would
+// fail the same way every time.
+func transientDeliveryFailure(resp *http.Response, err error) bool {
+	if err != nil {
+		var netErr net.Error
+		return errors.As(err, &netErr) && !errors.Is(err, context.Canceled)
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
 
-		return c.String(http.StatusOK, "push notifications sent")
+func pushServiceHost(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "unknown"
 	}
+	return u.Host
 }
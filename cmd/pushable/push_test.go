@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/pushable/types"
+	"github.com/pkg/errors"
+)
+
+func TestTransientDeliveryFailure(t *testing.T) {
+	refused := &url.Error{Op: "Post", URL: "https://push.example.com", Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}}
+
+	tests := []struct {
+		name   string
+		status int
+		err    error
+		want   bool
+	}{
+		{name: "created", status: http.StatusCreated},
+		{name: "gone", status: http.StatusGone},
+		{name: "bad request", status: http.StatusBadRequest},
+		{name: "too many requests", status: http.StatusTooManyRequests, want: true},
+		{name: "server error", status: http.StatusBadGateway, want: true},
+		{name: "connection refused", err: refused, want: true},
+		{name: "wrapped network error", err: errors.Wrap(refused, "sending"), want: true},
+		{name: "canceled", err: &url.Error{Op: "Post", URL: "https://push.example.com", Err: context.Canceled}},
+		{name: "bad subscription key", err: errors.New("crypto/ecdh: invalid public key")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp *http.Response
+			if tt.err == nil {
+				resp = &http.Response{StatusCode: tt.status}
+			}
+			if got := transientDeliveryFailure(resp, tt.err); got != tt.want {
+				t.Errorf("transientDeliveryFailure = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResubscribe(t *testing.T) {
+	tests := []struct {
+		name        string
+		oldEndpoint string
+		oldAuth     string
+		wantCode    int
+	}{
+		{name: "moves to the active key", oldEndpoint: "https://push.example.com/old", oldAuth: "old-auth", wantCode: http.StatusOK},
+		{name: "wrong auth secret", oldEndpoint: "https://push.example.com/old", oldAuth: "guessed", wantCode: http.StatusNotFound},
+		{name: "unknown endpoint", oldEndpoint: "https://push.example.com/other", oldAuth: "old-auth", wantCode: http.StatusNotFound},
+		{name: "no auth secret", oldEndpoint: "https://push.example.com/old", wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := testDialects(t)[types.DBDriverSQLite]
+			keyring, err := NewKeyring(types.Config{}, db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			old := keyring.Active()
+			active, err := keyring.Rotate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			user := types.User{Email: "a@example.com", Role: types.RoleUser}
+			if err := db.Create(&user).Error; err != nil {
+				t.Fatal(err)
+			}
+			sub := types.PushSubscription{UserID: user.ID, VapidKeyID: old.ID, Endpoint: "https://push.example.com/old", Auth: "old-auth", P256DH: "old-p256dh"}
+			if err := db.Create(&sub).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			var req resubscribeRequest
+			req.Old.Endpoint = tt.oldEndpoint
+			req.Old.Keys.Auth = tt.oldAuth
+			req.Subscription.Endpoint = "https://push.example.com/new"
+			req.Subscription.Keys.Auth = "new-auth"
+			req.Subscription.Keys.P256dh = "new-p256dh"
+			req.Subscription.VapidPublicKey = active.PublicKey
+			body, err := json.Marshal(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Service workers call this without a session
+			e := echo.New()
+			e.POST("/push/resubscribe", resubscribe(db, keyring))
+			httpReq := httptest.NewRequest(http.MethodPost, "/push/resubscribe", strings.NewReader(string(body)))
+			httpReq.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httpReq)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("POST /push/resubscribe = %d %s, want %d", rec.Code, rec.Body, tt.wantCode)
+			}
+
+			want := sub
+			if tt.wantCode == http.StatusOK {
+				want.VapidKeyID, want.Endpoint, want.Auth = active.ID, "https://push.example.com/new", "new-auth"
+			}
+			var got types.PushSubscription
+			if err := db.First(&got, sub.ID).Error; err != nil {
+				t.Fatal(err)
+			}
+			if got.VapidKeyID != want.VapidKeyID || got.Endpoint != want.Endpoint || got.Auth != want.Auth || got.UserID != user.ID {
+				t.Errorf("subscription = key %d %s %s, want key %d %s %s", got.VapidKeyID, got.Endpoint, got.Auth, want.VapidKeyID, want.Endpoint, want.Auth)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/pushable/lib/pushclient"
+	"github.com/oliverisaac/pushable/types"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// summaryInterval is how often suppressed pushes are folded into a summary
+const summaryInterval = 30 * time.Second
+
+const topicBucketPrefix = "topic:"
+
+// bucketRetries is how many times takeToken tries again after another
+// request spent from the same bucket between reading and updating it
+const bucketRetries = 20
+
+// takeToken spends a token from the bucket at key. When the bucket is empty
+// it returns how long until the next token is available. The bucket row is
+// updated with a compare-and-swap, so requests on any replica sharing the
+// database can't both spend the last token.
+func takeToken(db *gorm.DB, key string, limit types.RateLimit) (time.Duration, error) {
+	if !limit.Enabled() {
+		return 0, nil
+	}
+
+	// Times are kept to the microsecond, which Postgres stores exactly, so
+	// the updated_at read back still matches the row
+	bucket := types.RateLimitBucket{Key: key, Tokens: float64(limit.Burst), UpdatedAt: time.Now().Truncate(time.Microsecond)}
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&bucket).Error; err != nil {
+		return 0, errors.Wrap(err, "creating rate limit bucket")
+	}
+
+	perToken := limit.Per / time.Duration(limit.Burst)
+	for range bucketRetries {
+		var bucket types.RateLimitBucket
+		if err := db.First(&bucket, "key = ?", key).Error; err != nil {
+			return 0, errors.Wrap(err, "finding rate limit bucket")
+		}
+
+		now := time.Now().Truncate(time.Microsecond)
+		if now.Before(bucket.UpdatedAt) {
+			// Another replica's clock is ahead
+			now = bucket.UpdatedAt
+		}
+		tokens := math.Min(float64(limit.Burst), bucket.Tokens+float64(now.Sub(bucket.UpdatedAt))/float64(perToken))
+		if tokens < 1 {
+			return time.Duration((1 - tokens) * float64(perToken)), nil
+		}
+
+		res := db.Model(&types.RateLimitBucket{}).
+			Where("key = ? AND tokens = ? AND updated_at = ?", key, bucket.Tokens, bucket.UpdatedAt).
+			Updates(map[string]interface{}{"tokens": tokens - 1, "updated_at": now})
+		if res.Error != nil {
+			return 0, errors.Wrap(res.Error, "spending rate limit token")
+		}
+		if res.RowsAffected == 1 {
+			return 0, nil
+		}
+	}
+	return 0, errors.Errorf("rate limit bucket %s changed under every attempt to spend from it", key)
+}
+
+// suppressPush counts a push dropped by the bucket at key so it can be
+// reported in the next summary
+func suppressPush(db *gorm.DB, key string) error {
+	err := db.Model(&types.RateLimitBucket{}).
+		Where("key = ?", key).
+		Update("suppressed", gorm.Expr("suppressed + 1")).Error
+	return errors.Wrap(err, "counting suppressed push")
+}
+
+func tooManyRequests(c echo.Context, wait time.Duration, what string) error {
+	c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	return c.String(http.StatusTooManyRequests, fmt.Sprintf("rate limit exceeded for %s, retry in %s", what, wait.Round(time.Second)))
+}
+
+// summarizeSuppressed periodically sends one summary per topic whose pushes
+// were collapsed, once that topic's bucket has a token to spend
+func summarizeSuppressed(ctx context.Context, cfg types.Config, db *gorm.DB) {
+	ticker := time.NewTicker(summaryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var buckets []types.RateLimitBucket
+		err := db.Where("suppressed > 0 AND key LIKE ?", topicBucketPrefix+"%").Find(&buckets).Error
+		if err != nil {
+			logrus.Error(errors.Wrap(err, "finding suppressed pushes"))
+			continue
+		}
+
+		for _, bucket := range buckets {
+			if err := sendSummary(cfg, db, bucket); err != nil {
+				logrus.Error(errors.Wrapf(err, "summarizing %s", bucket.Key))
+			}
+		}
+	}
+}
+
+func sendSummary(cfg types.Config, db *gorm.DB, bucket types.RateLimitBucket) error {
+	wait, err := takeToken(db, bucket.Key, cfg.RateLimit.Topic)
+	if err != nil || wait > 0 {
+		return err
+	}
+
+	// Another replica summarizing the same pushes has already taken them
+	res := db.Model(&types.RateLimitBucket{}).
+		Where("key = ? AND suppressed >= ?", bucket.Key, bucket.Suppressed).
+		Update("suppressed", gorm.Expr("suppressed - ?", bucket.Suppressed))
+	if res.Error != nil {
+		return errors.Wrap(res.Error, "resetting suppressed count")
+	}
+	if res.RowsAffected == 0 {
+		return nil
+	}
+
+	topic := strings.TrimPrefix(bucket.Key, topicBucketPrefix)
+	push := pushclient.Push{
+		Topic: topic,
+		Title: fmt.Sprintf("%d notifications suppressed", bucket.Suppressed),
+		Body:  fmt.Sprintf("%d pushes were collapsed by the rate limit", bucket.Suppressed),
+	}
+	if topic != "" {
+		push.Body = fmt.Sprintf("%d pushes to %s were collapsed by the rate limit", bucket.Suppressed, topic)
+	}
+
+	users, err := pushRecipients(db)
+	if err != nil {
+		return err
+	}
+	var subscriptions []types.PushSubscription
+	for _, user := range users {
+		subscriptions = append(subscriptions, user.PushSubscriptions...)
+	}
+	return sendPush(cfg, db, push, subscriptions)
+}
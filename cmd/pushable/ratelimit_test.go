@@ -0,0 +1,196 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/pushable/lib/pushclient"
+	"github.com/oliverisaac/pushable/types"
+)
+
+func TestTakeToken(t *testing.T) {
+	limit := types.RateLimit{Burst: 2, Per: time.Minute}
+	tests := []struct {
+		name     string
+		keys     []string
+		wantWait []bool
+	}{
+		{"within burst", []string{"a", "a"}, []bool{false, false}},
+		{"over burst", []string{"a", "a", "a"}, []bool{false, false, true}},
+		{"buckets are per key", []string{"a", "a", "b", "a"}, []bool{false, false, false, true}},
+	}
+	for driver, db := range testDialects(t) {
+		for _, tt := range tests {
+			t.Run(driver+"/"+tt.name, func(t *testing.T) {
+				prefix := strings.ReplaceAll(tt.name, " ", "-") + ":"
+				for i, key := range tt.keys {
+					wait, err := takeToken(db, prefix+key, limit)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if (wait > 0) != tt.wantWait[i] {
+						t.Errorf("take %d from %s: wait %s, want waiting %t", i+1, key, wait, tt.wantWait[i])
+					}
+					if wait > limit.Per/time.Duration(limit.Burst) {
+						t.Errorf("take %d from %s: wait %s is longer than one token", i+1, key, wait)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestTakeTokenRefill(t *testing.T) {
+	limit := types.RateLimit{Burst: 2, Per: time.Minute}
+	tests := []struct {
+		name     string
+		elapsed  time.Duration
+		wantTake int
+	}{
+		{name: "nothing refilled", elapsed: 0, wantTake: 0},
+		{name: "half a token", elapsed: 15 * time.Second, wantTake: 0},
+		{name: "one token", elapsed: 45 * time.Second, wantTake: 1},
+		{name: "refills up to the burst", elapsed: time.Hour, wantTake: 2},
+	}
+	for driver, db := range testDialects(t) {
+		for _, tt := range tests {
+			t.Run(driver+"/"+tt.name, func(t *testing.T) {
+				key := "refill:" + tt.name
+				for range limit.Burst {
+					if _, err := takeToken(db, key, limit); err != nil {
+						t.Fatal(err)
+					}
+				}
+				err := db.Model(&types.RateLimitBucket{}).Where("key = ?", key).
+					Update("updated_at", time.Now().Add(-tt.elapsed).Truncate(time.Microsecond)).Error
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				took := 0
+				for range limit.Burst + 1 {
+					wait, err := takeToken(db, key, limit)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if wait == 0 {
+						took++
+					}
+				}
+				if took != tt.wantTake {
+					t.Errorf("took %d tokens after %s, want %d", took, tt.elapsed, tt.wantTake)
+				}
+			})
+		}
+	}
+}
+
+func TestTakeTokenConcurrently(t *testing.T) {
+	limit := types.RateLimit{Burst: 5, Per: time.Hour}
+	for driver, db := range testDialects(t) {
+		t.Run(driver, func(t *testing.T) {
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			took := 0
+			for range 2 * limit.Burst {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					wait, err := takeToken(db, "concurrent", limit)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					if wait == 0 {
+						mu.Lock()
+						took++
+						mu.Unlock()
+					}
+				}()
+			}
+			wg.Wait()
+			if took != limit.Burst {
+				t.Errorf("%d requests took a token, want %d", took, limit.Burst)
+			}
+		})
+	}
+}
+
+func TestCollapsedPushes(t *testing.T) {
+	db := testDialects(t)[types.DBDriverSQLite]
+	cfg := types.Config{RateLimit: types.RateLimitConfig{
+		Topic:    types.RateLimit{Burst: 1, Per: time.Hour},
+		Collapse: true,
+	}}
+
+	e := echo.New()
+	e.POST("/push", func(c echo.Context) error {
+		return acceptPush(c, cfg, db, pushclient.Push{Topic: c.FormValue("topic"), Title: "disk full"}, "192.0.2.1")
+	})
+	push := func(topic string) int {
+		req := httptest.NewRequest(http.MethodPost, "/push", strings.NewReader("topic="+topic))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	suppressed := func(topic string) int {
+		var bucket types.RateLimitBucket
+		if err := db.First(&bucket, "key = ?", topicBucketPrefix+topic).Error; err != nil {
+			t.Fatal(err)
+		}
+		return bucket.Suppressed
+	}
+
+	for i, want := range []int{http.StatusOK, http.StatusAccepted, http.StatusAccepted, http.StatusAccepted} {
+		if code := push("alerts"); code != want {
+			t.Errorf("push %d = %d, want %d", i+1, code, want)
+		}
+	}
+	if code := push("other"); code != http.StatusOK {
+		t.Errorf("push to another topic = %d, want %d", code, http.StatusOK)
+	}
+	if got := suppressed("alerts"); got != 3 {
+		t.Errorf("alerts suppressed %d pushes, want 3", got)
+	}
+	if got := suppressed("other"); got != 0 {
+		t.Errorf("other suppressed %d pushes, want 0", got)
+	}
+
+	var bucket types.RateLimitBucket
+	if err := db.First(&bucket, "key = ?", topicBucketPrefix+"alerts").Error; err != nil {
+		t.Fatal(err)
+	}
+	// The summary waits for the topic's bucket to refill
+	if err := sendSummary(cfg, db, bucket); err != nil {
+		t.Fatal(err)
+	}
+	if got := suppressed("alerts"); got != 3 {
+		t.Errorf("summary sent before the bucket refilled, %d suppressed left", got)
+	}
+
+	db.Model(&types.RateLimitBucket{}).Where("key = ?", bucket.Key).Update("updated_at", time.Now().Add(-time.Hour).Truncate(time.Microsecond))
+	if err := sendSummary(cfg, db, bucket); err != nil {
+		t.Fatal(err)
+	}
+	if got := suppressed("alerts"); got != 0 {
+		t.Errorf("%d suppressed left after the summary, want 0", got)
+	}
+	// A replica summarizing the same bucket finds nothing left to send
+	if err := sendSummary(cfg, db, bucket); err != nil {
+		t.Fatal(err)
+	}
+	if got := suppressed("alerts"); got != 0 {
+		t.Errorf("%d suppressed left after a second summary, want 0", got)
+	}
+	var summaries int64
+	db.Model(&types.Notification{}).Where("title = ?", "3 notifications suppressed").Count(&summaries)
+	if summaries != 1 {
+		t.Errorf("%d summaries sent, want 1", summaries)
+	}
+}
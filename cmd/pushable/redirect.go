@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/pushable/types"
+	"github.com/oliverisaac/pushable/views"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// signLink ties a link to the notification it was sent in, so /redirect only
+// follows links Pushable actually sent
+func signLink(cfg types.Config, notificationID uint, link string) string {
+	mac := hmac.New(sha256.New, cfg.CookeSecret)
+	fmt.Fprintf(mac, "%d:%s", notificationID, link)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifiedNotification returns the notification target was sent in, if sig
+// shows Pushable sent it
+func verifiedNotification(cfg types.Config, db *gorm.DB, nid string, sig string, target string) (types.Notification, bool, error) {
+	id, err := strconv.ParseUint(nid, 10, 64)
+	if err != nil || target == "" || sig == "" {
+		return types.Notification{}, false, nil
+	}
+	if !hmac.Equal([]byte(sig), []byte(signLink(cfg, uint(id), target))) {
+		return types.Notification{}, false, nil
+	}
+
+	var notification types.Notification
+	err = db.First(&notification, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return notification, false, nil
+	}
+	if err != nil {
+		return notification, false, errors.Wrap(err, "finding notification")
+	}
+	return notification, true, nil
+}
+
+// linkable reports whether target is safe to put in an href
+func linkable(target string) bool {
+	// Browsers read backslashes as slashes and skip leading spaces, so
+	// " /\evil.com" is another host
+	target = strings.TrimSpace(strings.ReplaceAll(target, "\\", "/"))
+	if strings.HasPrefix(target, "//") {
+		return false
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == "http" || u.Scheme == "https" || (u.Scheme == "" && u.Host == "")
+}
+
+// withoutQuery strips the credentials, query and fragment from link, where
+// tokens such as a reset token would be
+func withoutQuery(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	u.User = nil
+	u.RawQuery, u.ForceQuery = "", false
+	u.Fragment, u.RawFragment = "", ""
+	return u.String()
+}
+
+func redirect(cfg types.Config, db *gorm.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		target := c.FormValue("target")
+
+		notification, ok, err := verifiedNotification(cfg, db, c.FormValue("nid"), c.FormValue("sig"), target)
+		if err != nil {
+			return err
+		}
+
+		if !ok || !linkable(target) {
+			var user *types.User
+			if u, ok := GetSessionUser(c); ok {
+				user = &u
+			}
+			return render(c, 200, views.LeavingPage(cfg, user, target, linkable(target)))
+		}
+
+		event := types.NotificationEvent{
+			NotificationID: notification.ID,
+			Type:           types.NotificationEventOpen,
+			IP:             c.RealIP(),
+			UserAgent:      c.Request().UserAgent(),
+		}
+		if err := db.Create(&event).Error; err != nil {
+			return errors.Wrap(err, "recording notification open")
+		}
+		emitWebhook(db.WithContext(c.Request().Context()), types.WebhookNotificationClicked, map[string]interface{}{
+			"notification_id": notification.ID,
+			"topic":           notification.Topic,
+			"link":            withoutQuery(target),
+		})
+
+		return c.Redirect(http.StatusFound, target)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/pushable/types"
+	"github.com/oliverisaac/pushable/views"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+const SessionTokenKey = "token"
+const SessionVersionKey = "version"
+const SessionRecordKey = "session-record"
+
+// lastSeenResolution limits how often a session's last seen time is written
+const lastSeenResolution = time.Minute
+
+// startSession records a new session for user and stores it in the cookie
+func startSession(c echo.Context, db *gorm.DB, user types.User) error {
+	token, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+
+	record := types.Session{
+		UserID:     user.ID,
+		TokenHash:  hashToken(token),
+		UserAgent:  c.Request().UserAgent(),
+		IP:         c.RealIP(),
+		LastSeenAt: time.Now(),
+	}
+	if err := db.Create(&record).Error; err != nil {
+		return errors.Wrap(err, "saving session")
+	}
+
+	sess, _ := session.Get(SessionKey, c)
+	sess.Values[SessionUserIDKey] = user.ID
+	sess.Values[SessionTokenKey] = token
+	sess.Values[SessionVersionKey] = user.SessionVersion
+
+	return errors.Wrap(sess.Save(c.Request(), c.Response()), "saving session cookie")
+}
+
+// sessionUser returns the user of the cookie session if the session is still
+// valid server side
+func sessionUser(c echo.Context, db *gorm.DB) (types.User, types.Session, bool, error) {
+	sess, _ := session.Get(SessionKey, c)
+	userID, ok := sess.Values[SessionUserIDKey].(uint)
+	if !ok {
+		return types.User{}, types.Session{}, false, nil
+	}
+	token, _ := sess.Values[SessionTokenKey].(string)
+	version, _ := sess.Values[SessionVersionKey].(int)
+	if token == "" {
+		return types.User{}, types.Session{}, false, nil
+	}
+
+	var record types.Session
+	err := db.First(&record, "token_hash = ? AND revoked_at IS NULL", hashToken(token)).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return types.User{}, record, false, nil
+	}
+	if err != nil {
+		return types.User{}, record, false, errors.Wrap(err, "finding session")
+	}
+	if record.UserID != userID {
+		return types.User{}, record, false, nil
+	}
+
+	user, err := getUserByID(db, userID)
+	if err != nil {
+		return user, record, false, errors.Wrap(err, "getting user by id")
+	}
+	if user.Disabled || user.SessionVersion != version {
+		return user, record, false, nil
+	}
+
+	if time.Since(record.LastSeenAt) > lastSeenResolution {
+		record.LastSeenAt = time.Now()
+		record.IP = c.RealIP()
+		err := db.Model(&record).Updates(map[string]interface{}{"last_seen_at": record.LastSeenAt, "ip": record.IP}).Error
+		if err != nil {
+			return user, record, false, errors.Wrap(err, "updating session last seen")
+		}
+	}
+
+	return user, record, true, nil
+}
+
+func GetSessionRecord(c echo.Context) (types.Session, bool) {
+	s, ok := c.Get(SessionRecordKey).(types.Session)
+	return s, ok
+}
+
+// revokeAllSessions signs the user out everywhere by revoking every session
+// record and bumping the session version baked into their cookies
+func revokeAllSessions(tx *gorm.DB, userID uint) error {
+	err := tx.Model(&types.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+	if err != nil {
+		return errors.Wrap(err, "revoking sessions")
+	}
+
+	err = tx.Model(&types.User{}).
+		Where("id = ?", userID).
+		Update("session_version", gorm.Expr("COALESCE(session_version, 0) + 1")).Error
+	return errors.Wrap(err, "bumping session version")
+}
+
+func sessionsPageData(cfg types.Config, db *gorm.DB, c echo.Context, user types.User) (types.SessionsPageData, error) {
+	pageData := types.SessionsPageData{Config: cfg, User: &user}
+	if current, ok := GetSessionRecord(c); ok {
+		pageData.CurrentID = current.ID
+	}
+
+	err := db.Where("user_id = ? AND revoked_at IS NULL", user.ID).Order("last_seen_at desc").Find(&pageData.Sessions).Error
+	return pageData, errors.Wrap(err, "listing sessions")
+}
+
+func sessionsPage(cfg types.Config, db *gorm.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, ok := GetSessionUser(c)
+		if !ok {
+			return c.Redirect(http.StatusFound, "/")
+		}
+
+		pageData, err := sessionsPageData(cfg, db, c, user)
+		if err != nil {
+			return err
+		}
+		return render(c, 200, views.SessionsPage(pageData))
+	}
+}
+
+func revokeSession(cfg types.Config, db *gorm.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, ok := GetSessionUser(c)
+		if !ok {
+			return c.String(http.StatusUnauthorized, "unauthorized")
+		}
+
+		var revokeErr error
+		res := db.Model(&types.Session{}).
+			Where("id = ? AND user_id = ? AND revoked_at IS NULL", c.Param("id"), user.ID).
+			Update("revoked_at", time.Now())
+		if res.Error != nil {
+			return errors.Wrap(res.Error, "revoking session")
+		}
+		if res.RowsAffected == 0 {
+			revokeErr = fmt.Errorf("Oops! That session does not exist")
+		} else {
+			audit(db, c, &user, types.AuditSessionRevoked, c.Param("id"))
+		}
+
+		pageData, err := sessionsPageData(cfg, db, c, user)
+		if err != nil {
+			return err
+		}
+		pageData.Err = revokeErr
+
+		// Revoking the current session signs this browser out
+		if pageData.CurrentID == 0 || fmt.Sprint(pageData.CurrentID) == c.Param("id") {
+			c.Response().Header().Set("HX-Redirect", "/")
+		}
+		return render(c, 200, views.SessionsPanel(pageData))
+	}
+}
+
+func revokeOtherSessions(cfg types.Config, db *gorm.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, ok := GetSessionUser(c)
+		if !ok {
+			return c.String(http.StatusUnauthorized, "unauthorized")
+		}
+		current, _ := GetSessionRecord(c)
+
+		err := db.Model(&types.Session{}).
+			Where("user_id = ? AND id <> ? AND revoked_at IS NULL", user.ID, current.ID).
+			Update("revoked_at", time.Now()).Error
+		if err != nil {
+			return errors.Wrap(err, "revoking sessions")
+		}
+		audit(db, c, &user, types.AuditSessionRevoked, "others")
+
+		pageData, err := sessionsPageData(cfg, db, c, user)
+		if err != nil {
+			return err
+		}
+		return render(c, 200, views.SessionsPanel(pageData))
+	}
+}
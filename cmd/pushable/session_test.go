@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/pushable/types"
+	"gorm.io/gorm"
+)
+
+// sessionsServer is a sessionServer with the session routes and a route that
+// answers 200 when the request is signed in
+func sessionsServer(db *gorm.DB) *echo.Echo {
+	e := sessionServer(db, types.Config{})
+	e.GET("/test/signed-in", func(c echo.Context) error {
+		if _, ok := GetSessionUser(c); !ok {
+			return c.String(http.StatusUnauthorized, "unauthorized")
+		}
+		return c.String(http.StatusOK, "ok")
+	})
+	e.POST("/account/sessions/:id/revoke", revokeSession(types.Config{}, db))
+	e.POST("/account/sessions/revoke-others", revokeOtherSessions(types.Config{}, db))
+	return e
+}
+
+func signedIn(e *echo.Echo, cookies []*http.Cookie) bool {
+	return serveWithCookies(e, http.MethodGet, "/test/signed-in", nil, cookies).Code == http.StatusOK
+}
+
+func TestRevokeAllSessions(t *testing.T) {
+	tests := []struct {
+		name        string
+		version     int
+		wantVersion int
+	}{
+		{name: "first revocation", wantVersion: 1},
+		{name: "revoked before", version: 3, wantVersion: 4},
+	}
+	for _, tt := range tests {
+		for driver, db := range testDialects(t) {
+			t.Run(tt.name+"/"+driver, func(t *testing.T) {
+				e := sessionsServer(db)
+				users := []types.User{{Email: "a@example.com", Role: types.RoleUser, SessionVersion: tt.version}, {Email: "b@example.com", Role: types.RoleUser}}
+				for i := range users {
+					if err := db.Create(&users[i]).Error; err != nil {
+						t.Fatal(err)
+					}
+				}
+				first := signInCookies(t, e, users[0])
+				second := signInCookies(t, e, users[0])
+				other := signInCookies(t, e, users[1])
+
+				if err := revokeAllSessions(db, users[0].ID); err != nil {
+					t.Fatal(err)
+				}
+
+				var user types.User
+				if err := db.First(&user, users[0].ID).Error; err != nil {
+					t.Fatal(err)
+				}
+				if user.SessionVersion != tt.wantVersion {
+					t.Errorf("session version = %d, want %d", user.SessionVersion, tt.wantVersion)
+				}
+				var live int64
+				if err := db.Model(&types.Session{}).Where("user_id = ? AND revoked_at IS NULL", users[0].ID).Count(&live).Error; err != nil {
+					t.Fatal(err)
+				}
+				if live != 0 {
+					t.Errorf("%d sessions left unrevoked", live)
+				}
+				if signedIn(e, first) || signedIn(e, second) {
+					t.Error("revoked session still signed in")
+				}
+				if !signedIn(e, other) {
+					t.Error("another user's session was signed out")
+				}
+			})
+		}
+	}
+}
+
+func TestSessionUserStaleVersion(t *testing.T) {
+	db := testDialects(t)[types.DBDriverSQLite]
+	e := sessionsServer(db)
+	user := types.User{Email: "a@example.com", Role: types.RoleUser}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	cookies := signInCookies(t, e, user)
+
+	// Only the version moves, so the session record itself is still live
+	if err := db.Model(&user).Update("session_version", 1).Error; err != nil {
+		t.Fatal(err)
+	}
+	if signedIn(e, cookies) {
+		t.Error("session with a stale version still signed in")
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	tests := []struct {
+		name string
+		// revoke is the session the current one revokes: "other", "current"
+		// or "others" for every other session
+		revoke string
+		// otherUser has the other session belong to someone else
+		otherUser    bool
+		wantCurrent  bool
+		wantOther    bool
+		wantRedirect bool
+	}{
+		{name: "another session", revoke: "other", wantCurrent: true},
+		{name: "another user's session", revoke: "other", otherUser: true, wantCurrent: true, wantOther: true},
+		{name: "the current session", revoke: "current", wantOther: true, wantRedirect: true},
+		{name: "every other session", revoke: "others", wantCurrent: true},
+		{name: "every other session keeps another user's", revoke: "others", otherUser: true, wantCurrent: true, wantOther: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := testDialects(t)[types.DBDriverSQLite]
+			e := sessionsServer(db)
+			users := []types.User{{Email: "a@example.com", Role: types.RoleUser}, {Email: "b@example.com", Role: types.RoleUser}}
+			for i := range users {
+				if err := db.Create(&users[i]).Error; err != nil {
+					t.Fatal(err)
+				}
+			}
+			current := signInCookies(t, e, users[0])
+			otherOwner := users[0]
+			if tt.otherUser {
+				otherOwner = users[1]
+			}
+			other := signInCookies(t, e, otherOwner)
+
+			var sessions []types.Session
+			if err := db.Order("id").Find(&sessions).Error; err != nil {
+				t.Fatal(err)
+			}
+			targets := map[string]string{
+				"other":   fmt.Sprintf("/account/sessions/%d/revoke", sessions[1].ID),
+				"current": fmt.Sprintf("/account/sessions/%d/revoke", sessions[0].ID),
+				"others":  "/account/sessions/revoke-others",
+			}
+			target := targets[tt.revoke]
+
+			rec := serveWithCookies(e, http.MethodPost, target, nil, current)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("POST %s = %d %s", target, rec.Code, rec.Body)
+			}
+			if got := rec.Header().Get("HX-Redirect") != ""; got != tt.wantRedirect {
+				t.Errorf("redirected = %v, want %v", got, tt.wantRedirect)
+			}
+			if got := signedIn(e, current); got != tt.wantCurrent {
+				t.Errorf("current session signed in = %v, want %v", got, tt.wantCurrent)
+			}
+			if got := signedIn(e, other); got != tt.wantOther {
+				t.Errorf("other session signed in = %v, want %v", got, tt.wantOther)
+			}
+		})
+	}
+}
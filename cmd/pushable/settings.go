@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/oliverisaac/pushable/types"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+func getSetting(db *gorm.DB, key string) (string, bool, error) {
+	var setting types.Setting
+	err := db.First(&setting, "key = ?", key).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, errors.Wrapf(err, "getting setting %q", key)
+	}
+	return setting.Value, true, nil
+}
+
+func setSetting(db *gorm.DB, key string, value string) error {
+	err := db.Save(&types.Setting{Key: key, Value: value}).Error
+	return errors.Wrapf(err, "saving setting %q", key)
+}
+
+func deleteSetting(db *gorm.DB, key string) error {
+	err := db.Delete(&types.Setting{}, "key = ?", key).Error
+	return errors.Wrapf(err, "deleting setting %q", key)
+}
+
+func getBoolSetting(db *gorm.DB, key string) (*bool, error) {
+	value, ok, err := getSetting(db, key)
+	if err != nil || !ok {
+		return nil, err
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing setting %q", key)
+	}
+	return &b, nil
+}
+
+// effectiveConfig applies the runtime overrides stored in the DB to cfg
+func effectiveConfig(db *gorm.DB, cfg types.Config) (types.Config, error) {
+	allowSignup, err := getBoolSetting(db, types.SettingAllowSignup)
+	if err != nil {
+		return cfg, err
+	}
+	if allowSignup != nil {
+		cfg.AllowSignup = *allowSignup
+	}
+	return cfg, nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/pushable/types"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// abandonGrace is how long fan-outs get to save their remaining deliveries
+// once the drain timeout has passed
+const abandonGrace = 5 * time.Second
+
+var (
+	// draining is set once shutdown starts, taking the server out of
+	// rotation
+	draining atomic.Bool
+	// abandon is closed when the drain timeout passes, telling fan-outs to
+	// save what's left instead of delivering it
+	abandon = make(chan struct{})
+	// fanouts tracks the fan-outs in progress. Once fanoutsClosed is set
+	// no more are started, so Add can't race the drain's Wait.
+	fanouts       sync.WaitGroup
+	fanoutsMu     sync.Mutex
+	fanoutsClosed bool
+)
+
+// startFanOut adds a fan-out to fanouts, unless the drain has stopped
+// waiting for new ones
+func startFanOut() bool {
+	fanoutsMu.Lock()
+	defer fanoutsMu.Unlock()
+	if fanoutsClosed {
+		return false
+	}
+	fanouts.Add(1)
+	return true
+}
+
+// closeFanOuts stops new fan-outs from starting
+func closeFanOuts() {
+	fanoutsMu.Lock()
+	defer fanoutsMu.Unlock()
+	fanoutsClosed = true
+}
+
+// abandonable returns a context that is cancelled when the drain timeout
+// passes
+func abandonable(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-abandon:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// readyz reports whether the server should receive traffic. Unlike /healthz
+// it fails while the server drains.
+func readyz() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if draining.Load() {
+			return c.String(http.StatusServiceUnavailable, "draining")
+		}
+		return c.String(http.StatusOK, "ok")
+	}
+}
+
+func savePendingDeliveries(db *gorm.DB, notification types.Notification, subscriptions []types.PushSubscription) error {
+	if len(subscriptions) == 0 {
+		return nil
+	}
+	if notification.Secret {
+		loggerFrom(db.Statement.Context).WithField("notification_id", notification.ID).Warnf("Dropped %d deliveries of a secret notification", len(subscriptions))
+		return nil
+	}
+	var pending []types.PendingDelivery
+	for _, sub := range subscriptions {
+		pending = append(pending, types.PendingDelivery{NotificationID: notification.ID, SubscriptionID: sub.ID, Link: notification.Link})
+	}
+	if err := db.Create(&pending).Error; err != nil {
+		return errors.Wrap(err, "saving pending deliveries")
+	}
+	loggerFrom(db.Statement.Context).WithField("notification_id", notification.ID).Warnf("Saved %d deliveries to send after restart", len(pending))
+	return nil
+}
+
+// resumePendingDeliveries sends the deliveries left over from the last
+// shutdown
+func resumePendingDeliveries(cfg types.Config, db *gorm.DB) error {
+	var pending []types.PendingDelivery
+	if err := db.Order("id").Find(&pending).Error; err != nil {
+		return errors.Wrap(err, "finding pending deliveries")
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	// Rows claimed before an error are still sent, or they'd be lost
+	claimed, err := claimPendingDeliveries(db, pending)
+	if err != nil {
+		logrus.Error(err)
+	}
+
+	subscriptionIDs := map[uint][]uint{}
+	links := map[uint]string{}
+	var notificationIDs []uint
+	for _, p := range claimed {
+		if _, ok := subscriptionIDs[p.NotificationID]; !ok {
+			notificationIDs = append(notificationIDs, p.NotificationID)
+		}
+		subscriptionIDs[p.NotificationID] = append(subscriptionIDs[p.NotificationID], p.SubscriptionID)
+		links[p.NotificationID] = p.Link
+	}
+
+	for _, id := range notificationIDs {
+		var notification types.Notification
+		if err := db.First(&notification, id).Error; err != nil {
+			logrus.Error(errors.Wrapf(err, "finding notification %d", id))
+			continue
+		}
+		notification.Link = links[id]
+		var subscriptions []types.PushSubscription
+		if err := db.Find(&subscriptions, subscriptionIDs[id]).Error; err != nil {
+			logrus.Error(errors.Wrapf(err, "finding subscriptions for notification %d", id))
+			continue
+		}
+
+		logrus.Infof("Resuming %d deliveries of notification %d", len(subscriptions), id)
+		if err := fanOut(cfg, db, notification, subscriptions); err != nil {
+			logrus.Error(errors.Wrapf(err, "resuming notification %d", id))
+		}
+	}
+	return nil
+}
+
+// claimPendingDeliveries deletes each of pending, returning the ones this
+// call deleted. Replicas starting together can both find the same rows, but
+// only one of them claims each, and a crash mid-way can't send it twice.
+func claimPendingDeliveries(db *gorm.DB, pending []types.PendingDelivery) ([]types.PendingDelivery, error) {
+	var claimed []types.PendingDelivery
+	for _, p := range pending {
+		res := db.Unscoped().Delete(&types.PendingDelivery{}, p.ID)
+		if res.Error != nil {
+			return claimed, errors.Wrap(res.Error, "claiming pending delivery")
+		}
+		if res.RowsAffected == 1 {
+			claimed = append(claimed, p)
+		}
+	}
+	return claimed, nil
+}
+
+// drain stops taking requests and waits up to the drain timeout for the ones
+// in flight. Fan-outs still running after that save what's left.
+func drain(cfg types.Config, e *echo.Echo, redirectServer *http.Server) {
+	draining.Store(true)
+	if cfg.ShutdownDelay > 0 {
+		logrus.Infof("Shutting down, failing readiness for %s before draining", cfg.ShutdownDelay)
+		time.Sleep(cfg.ShutdownDelay)
+	}
+	logrus.Infof("Draining for up to %s", cfg.DrainTimeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.DrainTimeout)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if redirectServer != nil {
+			redirectServer.Shutdown(ctx)
+		}
+		if err := e.Shutdown(ctx); err != nil {
+			logrus.Warn(errors.Wrap(err, "draining requests"))
+		}
+		closeFanOuts()
+		fanouts.Wait()
+	}()
+
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+
+	logrus.Warn("Drain timeout passed, saving undelivered pushes")
+	close(abandon)
+	select {
+	case <-done:
+	case <-time.After(abandonGrace):
+		logrus.Error("Fan-outs did not stop in time, some deliveries may be lost")
+	}
+	e.Close()
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	webpush "github.com/SherClockHolmes/webpush-go"
+	"github.com/oliverisaac/pushable/types"
+	"gorm.io/gorm"
+)
+
+// pendingFixture saves a notification with a pending delivery to each of
+// n subscriptions on pushService
+func pendingFixture(t *testing.T, db *gorm.DB, pushService string, n int) (types.Notification, []types.PushSubscription) {
+	private, public, err := webpush.GenerateVAPIDKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := types.VapidKey{PublicKey: public, PrivateKey: private, Active: true}
+	user := types.User{Email: "a@example.com", Role: types.RoleUser}
+	notification := types.Notification{Title: "hello"}
+	for _, row := range []interface{}{&key, &user, &notification} {
+		if err := db.Create(row).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var subscriptions []types.PushSubscription
+	for i := range n {
+		keys := testSubscription(t, "").Keys
+		sub := types.PushSubscription{UserID: user.ID, VapidKeyID: key.ID, Endpoint: fmt.Sprintf("%s/%d", pushService, i), P256DH: keys.P256dh, Auth: keys.Auth}
+		if err := db.Create(&sub).Error; err != nil {
+			t.Fatal(err)
+		}
+		subscriptions = append(subscriptions, sub)
+	}
+	return notification, subscriptions
+}
+
+func TestResumePendingDeliveries(t *testing.T) {
+	for name, db := range testDialects(t) {
+		t.Run(name, func(t *testing.T) {
+			var mu sync.Mutex
+			received := map[string]int{}
+			pushService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				received[r.URL.Path]++
+				mu.Unlock()
+				w.WriteHeader(http.StatusCreated)
+			}))
+			defer pushService.Close()
+
+			notification, subscriptions := pendingFixture(t, db, pushService.URL, 3)
+			if err := savePendingDeliveries(db, notification, subscriptions); err != nil {
+				t.Fatal(err)
+			}
+
+			// A second replica that found the same rows claims none of them
+			var found []types.PendingDelivery
+			if err := db.Find(&found).Error; err != nil {
+				t.Fatal(err)
+			}
+			if err := resumePendingDeliveries(types.Config{}, db); err != nil {
+				t.Fatal(err)
+			}
+			claimed, err := claimPendingDeliveries(db, found)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(claimed) != 0 {
+				t.Errorf("second replica claimed %d deliveries, want 0", len(claimed))
+			}
+
+			for i := range subscriptions {
+				if got := received[fmt.Sprintf("/%d", i)]; got != 1 {
+					t.Errorf("subscription %d got %d pushes, want 1", i, got)
+				}
+			}
+			var left int64
+			db.Model(&types.PendingDelivery{}).Count(&left)
+			if left != 0 {
+				t.Errorf("%d pending deliveries left", left)
+			}
+		})
+	}
+}
+
+func TestFanOutAfterDrain(t *testing.T) {
+	db := testDialects(t)[types.DBDriverSQLite]
+	pushService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("push sent to %s after the drain stopped waiting", r.URL.Path)
+	}))
+	defer pushService.Close()
+
+	notification, subscriptions := pendingFixture(t, db, pushService.URL, 3)
+
+	closeFanOuts()
+	t.Cleanup(func() { fanoutsClosed = false })
+	if err := fanOut(types.Config{}, db, notification, subscriptions); err != nil {
+		t.Fatal(err)
+	}
+
+	var pending int64
+	db.Model(&types.PendingDelivery{}).Where("notification_id = ?", notification.ID).Count(&pending)
+	if pending != int64(len(subscriptions)) {
+		t.Errorf("%d pending deliveries saved, want %d", pending, len(subscriptions))
+	}
+}
+
+func TestSavePendingDeliveries(t *testing.T) {
+	tests := []struct {
+		name        string
+		secret      bool
+		wantPending int
+	}{
+		{name: "notification", wantPending: 2},
+		{name: "secret notification", secret: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := testDialects(t)[types.DBDriverSQLite]
+			notification, subscriptions := pendingFixture(t, db, "https://push.example.com", 2)
+			notification.Link = "https://example.com/reset?token=secret"
+			notification.Secret = tt.secret
+			if err := savePendingDeliveries(db, notification, subscriptions); err != nil {
+				t.Fatal(err)
+			}
+
+			var pending []types.PendingDelivery
+			if err := db.Find(&pending).Error; err != nil {
+				t.Fatal(err)
+			}
+			if len(pending) != tt.wantPending {
+				t.Fatalf("%d pending deliveries saved, want %d", len(pending), tt.wantPending)
+			}
+			for _, p := range pending {
+				if p.Link != notification.Link {
+					t.Errorf("pending delivery link = %q, want %q", p.Link, notification.Link)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/oliverisaac/pushable/types"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// certReloadInterval is how often the certificate files are checked for
+// changes
+const certReloadInterval = 10 * time.Second
+
+// certReloader serves the certificate from disk, picking up renewals without
+// a restart
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func newCertReloader(certFile string, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// latestModTime is the newer of the two files' modification times, so
+// replacing either one triggers a reload
+func (r *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return latest, errors.Wrapf(err, "reading %s", name)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// reload loads the key pair if either file changed since the last load
+func (r *certReloader) reload() (bool, error) {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return false, err
+	}
+
+	r.mu.RLock()
+	unchanged := r.cert != nil && modTime.Equal(r.modTime)
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, errors.Wrap(err, "loading TLS key pair")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.modTime = modTime
+	return true, nil
+}
+
+// watch reloads the certificate as the files change. A bad renewal is
+// logged and the current certificate kept.
+func (r *certReloader) watch() {
+	for range time.Tick(certReloadInterval) {
+		reloaded, err := r.reload()
+		if err != nil {
+			logrus.Error(errors.Wrap(err, "reloading TLS certificate"))
+			continue
+		}
+		if reloaded {
+			logrus.Infof("Reloaded TLS certificate from %s", r.certFile)
+		}
+	}
+}
+
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// tlsConfig returns the TLS config for serving HTTPS with the configured
+// certificate
+func tlsConfig(cfg types.Config) (*tls.Config, error) {
+	reloader, err := newCertReloader(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	go reloader.watch()
+
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}, nil
+}
+
+// secureConfig adds the configured HSTS header to echo's default security
+// headers
+func secureConfig(cfg types.Config) middleware.SecureConfig {
+	secure := middleware.DefaultSecureConfig
+	secure.HSTSMaxAge = int(cfg.HSTS.MaxAge.Seconds())
+	secure.HSTSExcludeSubdomains = !cfg.HSTS.IncludeSubdomains
+	secure.HSTSPreloadEnabled = cfg.HSTS.Preload
+	return secure
+}
+
+// redirectToHTTPS sends plain HTTP requests to the same path over HTTPS on
+// the configured hostname. The request's Host header isn't used, so the
+// redirect can't be pointed at another site.
+func redirectToHTTPS(cfg types.Config) http.Handler {
+	_, tlsPort, _ := net.SplitHostPort(cfg.ListenAddress)
+	host := cfg.Hostname
+	if _, _, err := net.SplitHostPort(host); err != nil && tlsPort != "" && tlsPort != "443" {
+		host = net.JoinHostPort(host, tlsPort)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/oliverisaac/pushable/types"
+)
+
+func TestRedirectToHTTPS(t *testing.T) {
+	tests := []struct {
+		name     string
+		hostname string
+		listen   string
+		host     string
+		target   string
+		want     string
+	}{
+		{
+			name:     "default port",
+			hostname: "push.example.com",
+			listen:   ":443",
+			host:     "push.example.com",
+			target:   "/admin?tab=users",
+			want:     "https://push.example.com/admin?tab=users",
+		},
+		{
+			name:     "other port",
+			hostname: "push.example.com",
+			listen:   ":8443",
+			host:     "push.example.com:8080",
+			target:   "/",
+			want:     "https://push.example.com:8443/",
+		},
+		{
+			name:     "hostname with a port",
+			hostname: "push.example.com:9443",
+			listen:   ":8443",
+			host:     "push.example.com",
+			target:   "/",
+			want:     "https://push.example.com:9443/",
+		},
+		{
+			name:     "forged host header",
+			hostname: "push.example.com",
+			listen:   ":443",
+			host:     "evil.example.net",
+			target:   "/auth/sign-in",
+			want:     "https://push.example.com/auth/sign-in",
+		},
+		{
+			name:     "path that looks like a host",
+			hostname: "push.example.com",
+			listen:   ":443",
+			host:     "push.example.com",
+			target:   "//evil.example.net/",
+			want:     "https://push.example.com//evil.example.net/",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := redirectToHTTPS(types.Config{Hostname: tt.hostname, ListenAddress: tt.listen})
+			req := httptest.NewRequest(http.MethodGet, "http://"+tt.host+tt.target, nil)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusMovedPermanently {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusMovedPermanently)
+			}
+			if got := rec.Header().Get("Location"); got != tt.want {
+				t.Errorf("Location = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
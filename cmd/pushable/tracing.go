@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/oliverisaac/pushable/types"
+	"github.com/oliverisaac/pushable/version"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+const tracerName = "github.com/oliverisaac/pushable"
+
+var tracer = otel.Tracer(tracerName)
+
+// setupTracing exports spans over OTLP/HTTP when tracing is enabled. The
+// exporter reads the standard OTEL_EXPORTER_OTLP_* env vars. The returned
+// func flushes any buffered spans.
+func setupTracing(ctx context.Context, cfg types.Config) (func(context.Context) error, error) {
+	noop := func(context.Context) error { return nil }
+	if !cfg.Tracing {
+		return noop, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return noop, errors.Wrap(err, "creating otlp exporter")
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName("pushable"), semconv.ServiceVersion(version.Tag)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return noop, errors.Wrap(err, "building trace resource")
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(redactingSpanProcessor{}),
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	installTracing(provider)
+
+	return provider.Shutdown, nil
+}
+
+// installTracing makes provider the global one and reads incoming
+// traceparent and baggage headers
+func installTracing(provider *sdktrace.TracerProvider) {
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+}
+
+// uriAttributes are the span attributes otelecho and the semconv helpers
+// fill with the request path or URL
+var uriAttributes = []attribute.Key{"http.target", "http.url", "url.path", "url.full", "url.query"}
+
+// redactingSpanProcessor blanks credentials in request URIs before spans are
+// exported, as the request log does
+type redactingSpanProcessor struct{}
+
+func (redactingSpanProcessor) OnStart(_ context.Context, s sdktrace.ReadWriteSpan) {
+	for _, kv := range s.Attributes() {
+		if !slices.Contains(uriAttributes, kv.Key) || kv.Value.Type() != attribute.STRING {
+			continue
+		}
+		value := kv.Value.AsString()
+		if kv.Key == "url.query" {
+			value = strings.TrimPrefix(redactURI("/?"+value), "/?")
+		} else {
+			value = redactURI(value)
+		}
+		s.SetAttributes(kv.Key.String(value))
+	}
+}
+
+func (redactingSpanProcessor) OnEnd(sdktrace.ReadOnlySpan)      {}
+func (redactingSpanProcessor) Shutdown(context.Context) error   { return nil }
+func (redactingSpanProcessor) ForceFlush(context.Context) error { return nil }
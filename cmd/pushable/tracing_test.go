@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	webpush "github.com/SherClockHolmes/webpush-go"
+	"github.com/labstack/echo/v4"
+	sqlite "github.com/ncruces/go-sqlite3/gormlite"
+	"github.com/oliverisaac/pushable/types"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/labstack/echo/otelecho"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"gorm.io/gorm"
+	"gorm.io/plugin/opentelemetry/tracing"
+)
+
+// testExporter installs tracing once per process, since the package tracer
+// only ever delegates to the first global provider
+var testExporter = sync.OnceValue(func() *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	installTracing(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(redactingSpanProcessor{}), sdktrace.WithSyncer(exporter)))
+	return exporter
+})
+
+func TestTracing(t *testing.T) {
+	exporter := testExporter()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Use(tracing.NewPlugin(tracing.WithoutMetrics(), tracing.WithoutQueryVariables())); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&types.User{}); err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	e.Use(otelecho.Middleware("pushable"))
+	e.GET("/users", func(c echo.Context) error {
+		var count int64
+		if err := db.WithContext(c.Request().Context()).Model(&types.User{}).Count(&count).Error; err != nil {
+			return err
+		}
+		return c.String(http.StatusOK, "ok")
+	})
+	e.POST("/hooks/:token", func(c echo.Context) error {
+		return c.String(http.StatusOK, "ok")
+	})
+
+	pushService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer pushService.Close()
+	pushHost, _ := url.Parse(pushService.URL)
+
+	// A span from another process, which reaches us as a traceparent header
+	callerCtx, caller := sdktrace.NewTracerProvider().Tracer("caller").Start(context.Background(), "caller")
+	caller.End()
+
+	tests := []struct {
+		name string
+		run  func(t *testing.T)
+		// want maps each expected span to the span it should be a child
+		// of, "caller" being the remote one
+		want  map[string]string
+		attrs map[string]map[string]string
+	}{
+		{
+			name: "request",
+			run: func(t *testing.T) {
+				req := httptest.NewRequest(http.MethodGet, "/users", nil)
+				propagation.TraceContext{}.Inject(callerCtx, propagation.HeaderCarrier(req.Header))
+				rec := httptest.NewRecorder()
+				e.ServeHTTP(rec, req)
+				if rec.Code != http.StatusOK {
+					t.Fatalf("GET /users = %d", rec.Code)
+				}
+			},
+			want: map[string]string{"GET /users": "caller", "select users": "GET /users"},
+		},
+		{
+			name: "inbound webhook token",
+			run: func(t *testing.T) {
+				rec := httptest.NewRecorder()
+				e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/hooks/s3cret", nil))
+				if rec.Code != http.StatusOK {
+					t.Fatalf("POST /hooks/s3cret = %d", rec.Code)
+				}
+			},
+			want: map[string]string{"POST /hooks/:token": ""},
+			attrs: map[string]map[string]string{
+				"POST /hooks/:token": {"http.target": "/hooks/%5BREDACTED%5D"},
+			},
+		},
+		{
+			name: "delivery",
+			run: func(t *testing.T) {
+				ctx, fanOut := tracer.Start(context.Background(), "fanout")
+				defer fanOut.End()
+				resp, err := deliver(ctx, 7, []byte("{}"), testSubscription(t, pushService.URL), testVapidOptions(t))
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+			},
+			want: map[string]string{"webpush.SendNotification": "fanout", "fanout": ""},
+			attrs: map[string]map[string]string{
+				"webpush.SendNotification": {
+					"server.address":            pushHost.Host,
+					"pushable.subscription.id":  "7",
+					"http.response.status_code": "201",
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter.Reset()
+			tt.run(t)
+
+			spans := map[string]tracetest.SpanStub{}
+			for _, span := range exporter.GetSpans() {
+				spans[span.Name] = span
+			}
+			for name, parent := range tt.want {
+				span, ok := spans[name]
+				if !ok {
+					t.Fatalf("no %q span in %v", name, spanNames(exporter.GetSpans()))
+				}
+
+				switch parent {
+				case "":
+					if span.Parent.IsValid() {
+						t.Errorf("%q has a parent, want a root span", name)
+					}
+				case "caller":
+					if span.Parent.SpanID() != caller.SpanContext().SpanID() || !span.Parent.IsRemote() {
+						t.Errorf("%q parent = %s, want the remote caller", name, span.Parent.SpanID())
+					}
+				default:
+					if span.Parent.SpanID() != spans[parent].SpanContext.SpanID() {
+						t.Errorf("%q parent = %s, want %q", name, span.Parent.SpanID(), parent)
+					}
+				}
+
+				got := map[string]string{}
+				for _, attr := range span.Attributes {
+					got[string(attr.Key)] = attr.Value.Emit()
+				}
+				for key, value := range tt.attrs[name] {
+					if got[key] != value {
+						t.Errorf("%q %s = %q, want %q", name, key, got[key], value)
+					}
+				}
+			}
+		})
+	}
+}
+
+func spanNames(spans tracetest.SpanStubs) []string {
+	var names []string
+	for _, span := range spans {
+		names = append(names, span.Name)
+	}
+	return names
+}
+
+// testSubscription is a browser subscription with freshly made keys
+func testSubscription(t *testing.T, endpoint string) *webpush.Subscription {
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := make([]byte, 16)
+	if _, err := rand.Read(auth); err != nil {
+		t.Fatal(err)
+	}
+	return &webpush.Subscription{
+		Endpoint: endpoint,
+		Keys: webpush.Keys{
+			P256dh: base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()),
+			Auth:   base64.RawURLEncoding.EncodeToString(auth),
+		},
+	}
+}
+
+func testVapidOptions(t *testing.T) *webpush.Options {
+	private, public, err := webpush.GenerateVAPIDKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &webpush.Options{Subscriber: "mailto:test@example.com", VAPIDPublicKey: public, VAPIDPrivateKey: private, TTL: 60}
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/pushable/lib/totp"
+	"github.com/oliverisaac/pushable/types"
+	"github.com/oliverisaac/pushable/views"
+	"github.com/pkg/errors"
+	"github.com/skip2/go-qrcode"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const SessionPending2FAUserIDKey = "pending-2fa-userid"
+const SessionPending2FAAtKey = "pending-2fa-at"
+
+// pending2FATTL is how long a user has to enter their code after their password
+const pending2FATTL = 5 * time.Minute
+
+const recoveryCodeCount = 10
+
+func require2FA(db *gorm.DB) (bool, error) {
+	required, err := getBoolSetting(db, types.SettingRequire2FA)
+	return required != nil && *required, err
+}
+
+// verifyTOTP checks a code for user and records its time step so the same
+// code cannot be used twice
+func verifyTOTP(db *gorm.DB, user types.User, secret string, code string) (bool, error) {
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok || step <= user.TOTPLastStep {
+		return false, nil
+	}
+
+	res := db.Model(&types.User{}).
+		Where("id = ? AND (totp_last_step IS NULL OR totp_last_step < ?)", user.ID, step).
+		Update("totp_last_step", step)
+	if res.Error != nil {
+		return false, errors.Wrap(res.Error, "saving totp step")
+	}
+	return res.RowsAffected == 1, nil
+}
+
+// useRecoveryCode consumes one of the user's unused recovery codes
+func useRecoveryCode(db *gorm.DB, user types.User, code string) (bool, error) {
+	code = strings.ToLower(strings.TrimSpace(code))
+
+	var codes []types.RecoveryCode
+	if err := db.Find(&codes, "user_id = ? AND used_at IS NULL", user.ID).Error; err != nil {
+		return false, errors.Wrap(err, "finding recovery codes")
+	}
+
+	for _, rc := range codes {
+		if bcrypt.CompareHashAndPassword([]byte(rc.CodeHash), []byte(code)) != nil {
+			continue
+		}
+		res := db.Model(&rc).Where("used_at IS NULL").Update("used_at", time.Now())
+		if res.Error != nil {
+			return false, errors.Wrap(res.Error, "using recovery code")
+		}
+		return res.RowsAffected == 1, nil
+	}
+	return false, nil
+}
+
+// newRecoveryCodes replaces the user's recovery codes and returns the new
+// plain text codes, which are only ever shown once
+func newRecoveryCodes(tx *gorm.DB, user types.User) ([]string, error) {
+	if err := tx.Where("user_id = ?", user.ID).Delete(&types.RecoveryCode{}).Error; err != nil {
+		return nil, errors.Wrap(err, "deleting recovery codes")
+	}
+
+	var codes []string
+	for i := 0; i < recoveryCodeCount; i++ {
+		token, err := randomToken(8)
+		if err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(token)
+		hash, err := bcrypt.GenerateFromPassword([]byte(code), 10)
+		if err != nil {
+			return nil, errors.Wrap(err, "hashing recovery code")
+		}
+		if err := tx.Create(&types.RecoveryCode{UserID: user.ID, CodeHash: string(hash)}).Error; err != nil {
+			return nil, errors.Wrap(err, "saving recovery code")
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+func twoFactorPageData(cfg types.Config, db *gorm.DB, user types.User) (types.TwoFactorPageData, error) {
+	pageData := types.TwoFactorPageData{Config: cfg, User: &user}
+
+	required, err := require2FA(db)
+	if err != nil {
+		return pageData, err
+	}
+	pageData.Required = required
+
+	if user.TOTPEnabled {
+		return pageData, nil
+	}
+
+	// Enrollment reuses the pending secret so a reload doesn't invalidate a
+	// code that was already scanned
+	if user.TOTPSecret == "" {
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			return pageData, err
+		}
+		if err := db.Model(&user).Update("totp_secret", secret).Error; err != nil {
+			return pageData, errors.Wrap(err, "saving totp secret")
+		}
+		user.TOTPSecret = secret
+	}
+	pageData.Secret = user.TOTPSecret
+
+	png, err := qrcode.Encode(totp.URI("Pushable "+cfg.Hostname, user.Email, user.TOTPSecret), qrcode.Medium, 256)
+	if err != nil {
+		return pageData, errors.Wrap(err, "rendering totp qr code")
+	}
+	pageData.QRCode = "data:image/png;base64," + base64.StdEncoding.EncodeToString(png)
+
+	return pageData, nil
+}
+
+func twoFactorPage(cfg types.Config, db *gorm.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, ok := GetSessionUser(c)
+		if !ok {
+			return c.Redirect(http.StatusFound, "/")
+		}
+
+		pageData, err := twoFactorPageData(cfg, db, user)
+		if err != nil {
+			return err
+		}
+		return render(c, 200, views.TwoFactorPage(pageData))
+	}
+}
+
+func enableTwoFactor(cfg types.Config, db *gorm.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, ok := GetSessionUser(c)
+		if !ok {
+			return c.String(http.StatusUnauthorized, "unauthorized")
+		}
+
+		pageData, err := twoFactorPageData(cfg, db, user)
+		if err != nil {
+			return err
+		}
+		if user.TOTPEnabled {
+			return render(c, 200, views.TwoFactorPanel(pageData))
+		}
+
+		valid, err := verifyTOTP(db, user, pageData.Secret, c.FormValue("code"))
+		if err != nil {
+			return err
+		}
+		if !valid {
+			pageData.Err = fmt.Errorf("Oops! That code is incorrect, check your device's clock and try again")
+			return render(c, 422, views.TwoFactorPanel(pageData))
+		}
+
+		var codes []string
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&user).Update("totp_enabled", true).Error; err != nil {
+				return errors.Wrap(err, "enabling totp")
+			}
+			codes, err = newRecoveryCodes(tx, user)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		audit(db, c, &user, types.AuditTwoFactorEnabled, user.Email)
+
+		user.TOTPEnabled = true
+		pageData, err = twoFactorPageData(cfg, db, user)
+		if err != nil {
+			return err
+		}
+		pageData.RecoveryCodes = codes
+		pageData.Message = "Two-factor authentication is now enabled"
+		return render(c, 200, views.TwoFactorPanel(pageData))
+	}
+}
+
+func disableTwoFactor(cfg types.Config, db *gorm.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, ok := GetSessionUser(c)
+		if !ok {
+			return c.String(http.StatusUnauthorized, "unauthorized")
+		}
+
+		pageData, err := twoFactorPageData(cfg, db, user)
+		if err != nil {
+			return err
+		}
+		if pageData.Required {
+			pageData.Err = fmt.Errorf("Oops! An admin requires two-factor authentication for every account")
+			return render(c, 422, views.TwoFactorPanel(pageData))
+		}
+
+		// Accounts made through OIDC or proxy auth have no password to ask for
+		if user.Password != "" {
+			if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(c.FormValue("password"))); err != nil {
+				pageData.Err = fmt.Errorf("Oops! Your password is incorrect")
+				return render(c, 422, views.TwoFactorPanel(pageData))
+			}
+		}
+
+		valid, err := verifyTOTP(db, user, user.TOTPSecret, c.FormValue("code"))
+		if err != nil {
+			return err
+		}
+		if !valid {
+			pageData.Err = fmt.Errorf("Oops! That code is incorrect")
+			return render(c, 422, views.TwoFactorPanel(pageData))
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			err := tx.Model(&user).Updates(map[string]interface{}{"totp_enabled": false, "totp_secret": ""}).Error
+			if err != nil {
+				return errors.Wrap(err, "disabling totp")
+			}
+			return errors.Wrap(tx.Where("user_id = ?", user.ID).Delete(&types.RecoveryCode{}).Error, "deleting recovery codes")
+		})
+		if err != nil {
+			return err
+		}
+
+		audit(db, c, &user, types.AuditTwoFactorDisabled, user.Email)
+
+		user.TOTPEnabled = false
+		user.TOTPSecret = ""
+		pageData, err = twoFactorPageData(cfg, db, user)
+		if err != nil {
+			return err
+		}
+		pageData.Message = "Two-factor authentication has been disabled"
+		return render(c, 200, views.TwoFactorPanel(pageData))
+	}
+}
+
+func regenerateRecoveryCodes(cfg types.Config, db *gorm.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, ok := GetSessionUser(c)
+		if !ok {
+			return c.String(http.StatusUnauthorized, "unauthorized")
+		}
+
+		pageData, err := twoFactorPageData(cfg, db, user)
+		if err != nil {
+			return err
+		}
+		if !user.TOTPEnabled {
+			return render(c, 422, views.TwoFactorPanel(pageData))
+		}
+
+		pageData.RecoveryCodes, err = newRecoveryCodes(db, user)
+		if err != nil {
+			return err
+		}
+		pageData.Message = "Your old recovery codes no longer work"
+		return render(c, 200, views.TwoFactorPanel(pageData))
+	}
+}
+
+// beginTwoFactorSignIn remembers that user passed the password check. The
+// session cookie is only issued once signInWithTOTP checks their code.
+func beginTwoFactorSignIn(c echo.Context, user types.User) error {
+	sess, _ := session.Get(SessionKey, c)
+	sess.Values[SessionPending2FAUserIDKey] = user.ID
+	sess.Values[SessionPending2FAAtKey] = time.Now().Unix()
+	return errors.Wrap(sess.Save(c.Request(), c.Response()), "saving session cookie")
+}
+
+// endTwoFactorSignIn forgets a pending sign in
+func endTwoFactorSignIn(c echo.Context) error {
+	sess, _ := session.Get(SessionKey, c)
+	delete(sess.Values, SessionPending2FAUserIDKey)
+	delete(sess.Values, SessionPending2FAAtKey)
+	return errors.Wrap(sess.Save(c.Request(), c.Response()), "saving session cookie")
+}
+
+// twoFactorSignIn renders the code form for sign ins that arrive by redirect
+func twoFactorSignIn(cfg types.Config) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return render(c, 200, views.TwoFactorSignInPage(cfg))
+	}
+}
+
+func signInWithTOTP(cfg types.Config, db *gorm.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		sess, _ := session.Get(SessionKey, c)
+		userID, ok := sess.Values[SessionPending2FAUserIDKey].(uint)
+		startedAt, _ := sess.Values[SessionPending2FAAtKey].(int64)
+		if !ok || time.Since(time.Unix(startedAt, 0)) > pending2FATTL {
+			return render(c, 422, views.TwoFactorSignInForm(fmt.Errorf("Oops! Your sign in expired, please start again")))
+		}
+
+		user, err := getUserByID(db, userID)
+		if err != nil {
+			return err
+		}
+		// An admin may have disabled the account, or the user turned 2FA
+		// off elsewhere, since the password step
+		if user.Disabled || !user.TOTPEnabled {
+			if err := endTwoFactorSignIn(c); err != nil {
+				return err
+			}
+			if user.Disabled {
+				return render(c, 422, views.TwoFactorSignInForm(fmt.Errorf("This account has been disabled")))
+			}
+			return render(c, 422, views.TwoFactorSignInForm(fmt.Errorf("Oops! Your sign in expired, please start again")))
+		}
+
+		wait, err := loginWait(cfg, db, user.Email, c.RealIP())
+		if err != nil {
+			return err
+		}
+		if wait > 0 {
+			return render(c, 422, views.TwoFactorSignInForm(tooManySignIns(c, wait)))
+		}
+
+		code := c.FormValue("code")
+		valid, err := verifyTOTP(db, user, user.TOTPSecret, code)
+		if err != nil {
+			return err
+		}
+		if !valid {
+			valid, err = useRecoveryCode(db, user, code)
+			if err != nil {
+				return err
+			}
+		}
+		if !valid {
+			if err := recordLoginAttempt(db, c, user.Email, false); err != nil {
+				return err
+			}
+			return render(c, 422, views.TwoFactorSignInForm(fmt.Errorf("Oops! That code is incorrect")))
+		}
+
+		// Saved with the new session
+		delete(sess.Values, SessionPending2FAUserIDKey)
+		delete(sess.Values, SessionPending2FAAtKey)
+		if err := completeSignIn(cfg, db, c, user); err != nil {
+			return render(c, 422, views.TwoFactorSignInForm(errors.Wrap(err, "Internal server error")))
+		}
+
+		return c.Redirect(http.StatusFound, "/")
+	}
+}
+
+// Require2FAMiddleware sends signed in users without 2FA to the enrollment
+// page when an admin requires it
+func Require2FAMiddleware(db *gorm.DB) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			user, ok := GetSessionUser(c)
+			if !ok || user.TOTPEnabled || c.Get(ProxyAuthKey) != nil {
+				return next(c)
+			}
+
+			path := c.Request().URL.Path
+			for _, prefix := range []string{"/account/2fa", "/auth/sign-out", "/static/", "/serviceWorker.js"} {
+				if strings.HasPrefix(path, prefix) {
+					return next(c)
+				}
+			}
+
+			required, err := require2FA(db)
+			if err != nil {
+				return err
+			}
+			if !required {
+				return next(c)
+			}
+
+			if isHTMX(c) {
+				c.Response().Header().Set("HX-Redirect", "/account/2fa")
+				return c.NoContent(http.StatusOK)
+			}
+			return c.Redirect(http.StatusFound, "/account/2fa")
+		}
+	}
+}
+
+func adminSetRequire2FA(cfg types.Config, db *gorm.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		required := c.FormValue("require") == "true"
+		if err := setSetting(db, types.SettingRequire2FA, fmt.Sprint(required)); err != nil {
+			return err
+		}
+
+		msg := "Two-factor authentication is now optional"
+		if required {
+			msg = "Two-factor authentication is now required for every account"
+		}
+		return renderAdminPanel(cfg, db, c, 200, msg, nil)
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+	sqlite "github.com/ncruces/go-sqlite3/gormlite"
+	"github.com/oliverisaac/pushable/lib/totp"
+	"github.com/oliverisaac/pushable/types"
+	"gorm.io/gorm"
+)
+
+func TestVerifyTOTPReplay(t *testing.T) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := totp.Step(time.Now())
+	code := func(step int64) string {
+		c, err := totp.CodeAt(secret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name string
+		// lastStep is the stored totp_last_step, nil for a row that
+		// predates the column
+		lastStep *int64
+		codes    []string
+		want     []bool
+	}{
+		{"fresh code", ptr(int64(0)), []string{code(now)}, []bool{true}},
+		{"same code twice", ptr(int64(0)), []string{code(now), code(now)}, []bool{true, false}},
+		{"older code after newer", ptr(int64(0)), []string{code(now), code(now - 1)}, []bool{true, false}},
+		{"newer code after older", ptr(int64(0)), []string{code(now - 1), code(now)}, []bool{true, true}},
+		{"step already used", ptr(now), []string{code(now)}, []bool{false}},
+		{"NULL last step", nil, []string{code(now), code(now)}, []bool{true, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := db.AutoMigrate(&types.User{}); err != nil {
+				t.Fatal(err)
+			}
+
+			user := types.User{Email: "a@example.com", TOTPSecret: secret, TOTPEnabled: true}
+			if err := db.Create(&user).Error; err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Model(&user).Update("totp_last_step", tt.lastStep).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			for i, c := range tt.codes {
+				// Later attempts forget the step they loaded, as a
+				// concurrent sign in holding the old row would, so only
+				// the database guard can reject a replay
+				var stale types.User
+				if err := db.First(&stale, user.ID).Error; err != nil {
+					t.Fatal(err)
+				}
+				if i > 0 {
+					stale.TOTPLastStep = 0
+				}
+
+				got, err := verifyTOTP(db, stale, secret, c)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != tt.want[i] {
+					t.Errorf("attempt %d: verifyTOTP() = %t, want %t", i+1, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestUseRecoveryCode(t *testing.T) {
+	db := testDialects(t)[types.DBDriverSQLite]
+	user := types.User{Email: "a@example.com", Role: types.RoleUser}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	codes, err := newRecoveryCodes(db, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		code string
+		want bool
+	}{
+		{"unknown code", "not-a-code", false},
+		{"first use", codes[0], true},
+		{"second use", codes[0], false},
+		{"another code, shouted", " " + strings.ToUpper(codes[1]) + " ", true},
+	}
+	for _, tt := range tests {
+		got, err := useRecoveryCode(db, user, tt.code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: useRecoveryCode() = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSignInWithTOTP(t *testing.T) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := totp.Step(time.Now())
+	code, err := totp.CodeAt(secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		// between changes the user after the password step
+		between  func(db *gorm.DB, user *types.User)
+		code     string
+		wantCode int
+		wantErr  string
+	}{
+		{name: "code", code: code, wantCode: http.StatusFound},
+		{
+			name:     "replayed step",
+			between:  func(db *gorm.DB, user *types.User) { db.Model(user).Update("totp_last_step", now) },
+			code:     code,
+			wantCode: http.StatusUnprocessableEntity,
+			wantErr:  "incorrect",
+		},
+		{
+			name:     "disabled after the password step",
+			between:  func(db *gorm.DB, user *types.User) { db.Model(user).Update("disabled", true) },
+			code:     code,
+			wantCode: http.StatusUnprocessableEntity,
+			wantErr:  "disabled",
+		},
+		{
+			name:     "2FA turned off after the password step",
+			between:  func(db *gorm.DB, user *types.User) { db.Model(user).Update("totp_enabled", false) },
+			code:     code,
+			wantCode: http.StatusUnprocessableEntity,
+			wantErr:  "expired",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := testDialects(t)[types.DBDriverSQLite]
+			user := types.User{Email: "a@example.com", Role: types.RoleUser, TOTPSecret: secret, TOTPEnabled: true}
+			if err := db.Create(&user).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			e := echo.New()
+			e.Use(session.Middleware(sessions.NewCookieStore([]byte("test"))))
+			e.POST("/begin", func(c echo.Context) error { return beginTwoFactorSignIn(c, user) })
+			e.POST("/2fa", signInWithTOTP(types.Config{}, db))
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/begin", nil))
+			cookies := rec.Result().Cookies()
+			if tt.between != nil {
+				tt.between(db, &user)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/2fa", strings.NewReader(url.Values{"code": {tt.code}}.Encode()))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+			for _, cookie := range cookies {
+				req.AddCookie(cookie)
+			}
+			rec = httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode || !strings.Contains(rec.Body.String(), tt.wantErr) {
+				t.Errorf("sign in = %d, want %d with %q in\n%s", rec.Code, tt.wantCode, tt.wantErr, rec.Body)
+			}
+			var sessionCount int64
+			db.Model(&types.Session{}).Where("user_id = ?", user.ID).Count(&sessionCount)
+			if signedIn := sessionCount > 0; signedIn != (tt.wantCode == http.StatusFound) {
+				t.Errorf("signed in = %t", signedIn)
+			}
+		})
+	}
+}
+
+func TestDisableTwoFactor(t *testing.T) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := totp.CodeAt(secret, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := hashPassword("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		hash         string
+		password     string
+		code         string
+		wantDisabled bool
+	}{
+		{name: "password and code", hash: hash, password: "password", code: code, wantDisabled: true},
+		{name: "code without password", hash: hash, code: code},
+		{name: "wrong password", hash: hash, password: "guess", code: code},
+		{name: "password without code", hash: hash, password: "password", code: "000000"},
+		{name: "account without a password", code: code, wantDisabled: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := testDialects(t)[types.DBDriverSQLite]
+			user := types.User{Email: "a@example.com", Role: types.RoleUser, Password: tt.hash, TOTPSecret: secret, TOTPEnabled: true}
+			if err := db.Create(&user).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			e := echo.New()
+			e.POST("/disable", disableTwoFactor(types.Config{}, db), func(next echo.HandlerFunc) echo.HandlerFunc {
+				return func(c echo.Context) error {
+					c.Set(UserKey, user)
+					return next(c)
+				}
+			})
+			form := url.Values{"password": {tt.password}, "code": {tt.code}}
+			req := httptest.NewRequest(http.MethodPost, "/disable", strings.NewReader(form.Encode()))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+			e.ServeHTTP(httptest.NewRecorder(), req)
+
+			if err := db.First(&user, user.ID).Error; err != nil {
+				t.Fatal(err)
+			}
+			if disabled := !user.TOTPEnabled; disabled != tt.wantDisabled {
+				t.Errorf("2FA disabled = %t, want %t", disabled, tt.wantDisabled)
+			}
+		})
+	}
+}
@@ -8,7 +8,6 @@ import (
 	"slices"
 	"time"
 
-	"github.com/gorilla/sessions"
 	"github.com/labstack/echo-contrib/session"
 	"github.com/labstack/echo/v4"
 	"github.com/oliverisaac/pushable/types"
@@ -32,30 +31,72 @@ func userExists(email string, db *gorm.DB) bool {
 	return err != gorm.ErrRecordNotFound
 }
 
-func signUp() echo.HandlerFunc {
+func signUp(db *gorm.DB, cfg types.Config) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		return render(c, 200, views.SignUpForm(nil))
+		cfg, err := effectiveConfig(db, cfg)
+		if err != nil {
+			return err
+		}
+
+		inviteToken := c.QueryParam("invite")
+		_, hasInvite, err := getUsableInvite(db, inviteToken)
+		if err != nil {
+			return err
+		}
+
+		var formErr error
+		if inviteToken != "" && !hasInvite {
+			inviteToken = ""
+			formErr = fmt.Errorf("Oops! That invitation is invalid or has expired")
+		}
+
+		if !hasInvite && !cfg.SignupEnabled() && formErr == nil {
+			return echo.ErrNotFound
+		}
+
+		// invite links are opened directly rather than through htmx
+		if !isHTMX(c) {
+			return render(c, 200, views.SignUpPage(cfg, inviteToken, formErr))
+		}
+		return render(c, 200, views.SignUpForm(inviteToken, formErr))
 	}
 }
 
 func signUpWithEmailAndPassword(db *gorm.DB, cfg types.Config) echo.HandlerFunc {
 	return func(c echo.Context) error {
+		cfg, err := effectiveConfig(db, cfg)
+		if err != nil {
+			return err
+		}
+
+		inviteToken := c.FormValue("invite")
+		invite, hasInvite, err := getUsableInvite(db, inviteToken)
+		if err != nil {
+			return err
+		}
+		if inviteToken != "" && !hasInvite {
+			return render(c, 422, views.SignUpForm("", fmt.Errorf("Oops! That invitation is invalid or has expired")))
+		}
+		if !hasInvite && !cfg.SignupEnabled() {
+			return echo.ErrNotFound
+		}
+
 		name := c.FormValue("name")
 		email := c.FormValue("email")
 		password := c.FormValue("password")
 
 		parsedEmail, err := mail.ParseAddress(email)
 		if err != nil {
-			return render(c, 422, views.SignUpForm(fmt.Errorf("Oops! That email address appears to be invalid")))
+			return render(c, 422, views.SignUpForm(inviteToken, fmt.Errorf("Oops! That email address appears to be invalid")))
 		}
 		email = parsedEmail.Address
 
-		if len(cfg.AllowSignupEmails) > 0 && !slices.Contains(cfg.AllowSignupEmails, email) {
-			return render(c, 422, views.SignUpForm(fmt.Errorf("Oops! That email address is banned")))
+		if !hasInvite && len(cfg.AllowSignupEmails) > 0 && !slices.Contains(cfg.AllowSignupEmails, email) {
+			return render(c, 422, views.SignUpForm(inviteToken, fmt.Errorf("Oops! That email address is banned")))
 		}
 
 		if userExists(email, db) {
-			return render(c, 422, views.SignUpForm(fmt.Errorf("Oops! It appears you are already registered")))
+			return render(c, 422, views.SignUpForm(inviteToken, fmt.Errorf("Oops! It appears you are already registered")))
 		}
 
 		hash, err := bcrypt.GenerateFromPassword([]byte(password), 10)
@@ -67,33 +108,47 @@ func signUpWithEmailAndPassword(db *gorm.DB, cfg types.Config) echo.HandlerFunc
 		var count int64
 		if err := db.Model(&types.User{}).Count(&count).Error; err != nil {
 			err := errors.Wrap(err, "Internal server error")
-			return render(c, 422, views.SignUpForm(err))
+			return render(c, 422, views.SignUpForm(inviteToken, err))
 		}
 
-		role := "user"
+		role := types.RoleUser
+		if hasInvite && invite.Role != "" {
+			role = invite.Role
+		}
 		if count == 0 {
-			role = "admin"
+			role = types.RoleAdmin
 		}
 
 		user := types.User{
-			Name:      name,
-			Email:     email,
-			Password:  string(hash),
-			Role:      role,
-			CreatedAt: time.Now(),
+			Name:     name,
+			Email:    email,
+			Password: string(hash),
+			Role:     role,
 		}
 
-		if err := db.Create(&user).Error; err != nil {
-			err := errors.Wrap(err, "Create user error")
-			return render(c, 422, views.SignUpForm(err))
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if hasInvite {
+				if err := redeemInvite(tx, invite); err != nil {
+					return err
+				}
+			}
+			return errors.Wrap(tx.Create(&user).Error, "Create user error")
+		})
+		if err != nil {
+			return render(c, 422, views.SignUpForm(inviteToken, err))
 		}
+		audit(db, c, &user, types.AuditSignUp, user.Role)
 
-		return render(c, 200, views.SignUpForm(nil))
+		return render(c, 200, views.SignUpForm("", nil))
 	}
 }
 
-func signIn(cfg types.Config) echo.HandlerFunc {
+func signIn(db *gorm.DB, cfg types.Config) echo.HandlerFunc {
 	return func(c echo.Context) error {
+		cfg, err := effectiveConfig(db, cfg)
+		if err != nil {
+			return err
+		}
 		return render(c, 200, views.SignInForm(cfg, nil))
 	}
 }
@@ -108,22 +163,35 @@ func signInWithEmailAndPassword(db *gorm.DB, cfg types.Config) echo.HandlerFunc
 			return render(c, 422, views.SignInForm(cfg, fmt.Errorf("Invalid email")))
 		}
 
+		wait, err := loginWait(cfg, db, email, c.RealIP())
+		if err != nil {
+			return err
+		}
+		if wait > 0 {
+			return render(c, 422, views.SignInForm(cfg, tooManySignIns(c, wait)))
+		}
+
 		var user types.User
 		db.First(&user, "email = ?", email)
 		if compareErr := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); compareErr != nil {
+			if err := recordLoginAttempt(db, c, email, false); err != nil {
+				return err
+			}
 			return render(c, 422, views.SignInForm(cfg, fmt.Errorf("Invalid email or password")))
 		}
 
-		sess, _ := session.Get(SessionKey, c)
-		sess.Options = &sessions.Options{
-			Path:     "/",
-			MaxAge:   3600 * 24 * 365,
-			HttpOnly: true,
+		if user.Disabled {
+			return render(c, 422, views.SignInForm(cfg, fmt.Errorf("This account has been disabled")))
 		}
 
-		sess.Values[SessionUserIDKey] = user.ID
+		if user.TOTPEnabled {
+			if err := beginTwoFactorSignIn(c, user); err != nil {
+				return render(c, 422, views.SignInForm(cfg, errors.Wrap(err, "Internal server error")))
+			}
+			return render(c, 200, views.TwoFactorSignInForm(nil))
+		}
 
-		err = sess.Save(c.Request(), c.Response())
+		err = completeSignIn(cfg, db, c, user)
 		if err != nil {
 			return render(c, 422, views.SignInForm(cfg, errors.Wrap(err, "Internal server error")))
 		}
@@ -132,8 +200,15 @@ func signInWithEmailAndPassword(db *gorm.DB, cfg types.Config) echo.HandlerFunc
 	}
 }
 
-func signOut() echo.HandlerFunc {
+func signOut(db *gorm.DB) echo.HandlerFunc {
 	return func(c echo.Context) error {
+		auditSessionUser(db, c, types.AuditSignOut, "")
+		if record, ok := GetSessionRecord(c); ok {
+			if err := db.Model(&record).Update("revoked_at", time.Now()).Error; err != nil {
+				return errors.Wrap(err, "revoking session")
+			}
+		}
+
 		sess, _ := session.Get("session", c)
 		sess.Options.MaxAge = -1
 		err := sess.Save(c.Request(), c.Response())
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	webpush "github.com/SherClockHolmes/webpush-go"
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/pushable/types"
+	"github.com/oliverisaac/pushable/views"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+func vapidCommand(args []string) error {
+	if len(args) == 0 || args[0] != "generate" {
+		return fmt.Errorf("usage: pushable vapid generate")
+	}
+
+	privateKey, publicKey, err := webpush.GenerateVAPIDKeys()
+	if err != nil {
+		return errors.Wrap(err, "generating vapid keys")
+	}
+
+	fmt.Printf("VAPID_PUBLIC_KEY=%s\n", publicKey)
+	fmt.Printf("VAPID_PRIVATE_KEY=%s\n", privateKey)
+	return nil
+}
+
+// activeKeyTTL is how long the active key is cached before it is read again,
+// so a rotation on another replica is picked up
+const activeKeyTTL = 30 * time.Second
+
+// Keyring caches the active VAPID key that new subscriptions are made with
+type Keyring struct {
+	db *gorm.DB
+
+	mu       sync.RWMutex
+	active   types.VapidKey
+	loadedAt time.Time
+}
+
+// NewKeyring stores the configured key pair, or a generated one when auto
+// generation is on, and loads the active key. A configured key that hasn't
+// been seen before becomes the active key, so changing VAPID_PUBLIC_KEY and
+// VAPID_PRIVATE_KEY rotates keys.
+func NewKeyring(cfg types.Config, db *gorm.DB) (*Keyring, error) {
+	k := &Keyring{db: db}
+
+	publicKey, privateKey := cfg.VapidPublicKey, cfg.VapidPrivateKey
+	if publicKey == "" || privateKey == "" {
+		// Keys generated before they were kept in their own table
+		var hasPublic, hasPrivate bool
+		var err error
+		publicKey, hasPublic, err = getSetting(db, types.SettingVapidPublicKey)
+		if err != nil {
+			return nil, err
+		}
+		privateKey, hasPrivate, err = getSetting(db, types.SettingVapidPrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		if !hasPublic || !hasPrivate {
+			publicKey, privateKey = "", ""
+		}
+	}
+
+	var count int64
+	if err := db.Model(&types.VapidKey{}).Count(&count).Error; err != nil {
+		return nil, errors.Wrap(err, "counting vapid keys")
+	}
+
+	if publicKey != "" {
+		if _, err := k.add(publicKey, privateKey, false); err != nil {
+			return nil, err
+		}
+	} else if count == 0 {
+		if _, err := k.Rotate(); err != nil {
+			return nil, err
+		}
+		logrus.Info("Generated a VAPID key pair and stored it in the database")
+	}
+
+	if err := k.load(); err != nil {
+		return nil, err
+	}
+
+	// Subscriptions from before keys were tracked were made with the key
+	// configured at the time, or the generated one
+	key := k.Active()
+	if publicKey != "" {
+		if err := db.First(&key, "public_key = ?", publicKey).Error; err != nil {
+			return nil, errors.Wrap(err, "finding configured vapid key")
+		}
+	}
+	err := db.Model(&types.PushSubscription{}).Where("vapid_key_id = 0 OR vapid_key_id IS NULL").Update("vapid_key_id", key.ID).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "assigning vapid key to subscriptions")
+	}
+
+	return k, nil
+}
+
+// add stores a key pair, making it active if it is new or activate is set
+func (k *Keyring) add(publicKey string, privateKey string, activate bool) (types.VapidKey, error) {
+	var key types.VapidKey
+	err := k.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.First(&key, "public_key = ?", publicKey).Error
+		if err == nil && !activate {
+			return nil
+		}
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.Wrap(err, "finding vapid key")
+		}
+
+		if err := tx.Model(&types.VapidKey{}).Where("active = ?", true).Update("active", false).Error; err != nil {
+			return errors.Wrap(err, "deactivating vapid keys")
+		}
+		key.PublicKey = publicKey
+		key.PrivateKey = privateKey
+		key.Active = true
+		return errors.Wrap(tx.Save(&key).Error, "saving vapid key")
+	})
+	return key, err
+}
+
+func (k *Keyring) load() error {
+	var active types.VapidKey
+	if err := k.db.First(&active, "active = ?", true).Error; err != nil {
+		return errors.Wrap(err, "finding active vapid key")
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.active = active
+	k.loadedAt = time.Now()
+	return nil
+}
+
+// Active returns the active key, reading it again once the cached copy is
+// older than activeKeyTTL. If that fails the cached key is kept.
+func (k *Keyring) Active() types.VapidKey {
+	k.mu.RLock()
+	active, stale := k.active, time.Since(k.loadedAt) > activeKeyTTL
+	k.mu.RUnlock()
+	if !stale {
+		return active
+	}
+
+	if err := k.load(); err != nil {
+		logrus.Error(err)
+		// Not tried again until the TTL passes
+		k.mu.Lock()
+		k.loadedAt = time.Now()
+		k.mu.Unlock()
+		return active
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.active
+}
+
+// Rotate generates a new key pair and makes it the active key. Existing
+// subscriptions keep using their key until their devices re-subscribe.
+func (k *Keyring) Rotate() (types.VapidKey, error) {
+	privateKey, publicKey, err := webpush.GenerateVAPIDKeys()
+	if err != nil {
+		return types.VapidKey{}, errors.Wrap(err, "generating vapid keys")
+	}
+
+	key, err := k.add(publicKey, privateKey, true)
+	if err != nil {
+		return key, err
+	}
+	return key, k.load()
+}
+
+// KeyFor returns the key to use for a subscription made with publicKey,
+// falling back to the active key
+func (k *Keyring) KeyFor(publicKey string) (types.VapidKey, error) {
+	active := k.Active()
+	if publicKey == "" || publicKey == active.PublicKey {
+		return active, nil
+	}
+
+	var key types.VapidKey
+	err := k.db.First(&key, "public_key = ?", publicKey).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return active, nil
+	}
+	return key, errors.Wrap(err, "finding vapid key")
+}
+
+// VapidKeyMiddleware passes the active public key on to the templates
+func VapidKeyMiddleware(keyring *Keyring) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			c.SetRequest(req.WithContext(views.WithVapidPublicKey(req.Context(), keyring.Active().PublicKey)))
+			return next(c)
+		}
+	}
+}
+
+// vapidPublicKey lets service workers find the key to re-subscribe with
+func vapidPublicKey(keyring *Keyring) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return c.String(http.StatusOK, keyring.Active().PublicKey)
+	}
+}
+
+// vapidKeyUsage lists every key with the number of subscriptions using it
+func vapidKeyUsage(db *gorm.DB) ([]types.VapidKeyUsage, error) {
+	var keys []types.VapidKey
+	if err := db.Order("id desc").Find(&keys).Error; err != nil {
+		return nil, errors.Wrap(err, "listing vapid keys")
+	}
+
+	var usage []types.VapidKeyUsage
+	for _, key := range keys {
+		u := types.VapidKeyUsage{VapidKey: key}
+		if err := db.Model(&types.PushSubscription{}).Where("vapid_key_id = ?", key.ID).Count(&u.Subscriptions).Error; err != nil {
+			return nil, errors.Wrap(err, "counting subscriptions")
+		}
+		usage = append(usage, u)
+	}
+	return usage, nil
+}
+
+func adminRotateVapidKey(cfg types.Config, db *gorm.DB, keyring *Keyring) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if _, err := keyring.Rotate(); err != nil {
+			return err
+		}
+		return renderAdminPanel(cfg, db, c, 200, "Rotated the VAPID key, devices will move to it as they receive pushes or open Pushable", nil)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	webpush "github.com/SherClockHolmes/webpush-go"
+	"github.com/oliverisaac/pushable/types"
+)
+
+func TestKeyringActiveReloads(t *testing.T) {
+	db := testDialects(t)[types.DBDriverSQLite]
+
+	replicaA, err := NewKeyring(types.Config{}, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replicaB, err := NewKeyring(types.Config{}, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := replicaB.Active()
+
+	rotated, err := replicaA.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := replicaB.Active(); got.ID != before.ID {
+		t.Errorf("active key changed to %d before the TTL passed", got.ID)
+	}
+
+	replicaB.loadedAt = time.Now().Add(-activeKeyTTL - time.Second)
+	if got := replicaB.Active(); got.ID != rotated.ID {
+		t.Errorf("active key = %d after the TTL, want the rotated key %d", got.ID, rotated.ID)
+	}
+}
+
+func TestNewKeyringBackfill(t *testing.T) {
+	private, public, err := webpush.GenerateVAPIDKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		cfg  types.Config
+		// configured says whether the subscription should get the
+		// configured key rather than a generated one
+		configured bool
+	}{
+		{name: "configured key", cfg: types.Config{VapidPublicKey: public, VapidPrivateKey: private}, configured: true},
+		{name: "generated key", cfg: types.Config{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := testDialects(t)[types.DBDriverSQLite]
+			user := types.User{Email: "a@example.com", Role: types.RoleUser}
+			if err := db.Create(&user).Error; err != nil {
+				t.Fatal(err)
+			}
+			sub := types.PushSubscription{UserID: user.ID, Endpoint: "https://push.example.com/1"}
+			if err := db.Create(&sub).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			keyring, err := NewKeyring(tt.cfg, db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.configured && keyring.Active().PublicKey != public {
+				t.Fatalf("active key is not the configured one")
+			}
+
+			if err := db.First(&sub, sub.ID).Error; err != nil {
+				t.Fatal(err)
+			}
+			if sub.VapidKeyID != keyring.Active().ID {
+				t.Errorf("subscription has key %d, want %d", sub.VapidKeyID, keyring.Active().ID)
+			}
+		})
+	}
+}
+
+func TestKeyringRotate(t *testing.T) {
+	db := testDialects(t)[types.DBDriverSQLite]
+	keyring, err := NewKeyring(types.Config{}, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := keyring.Active()
+	second, err := keyring.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	third, err := keyring.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyring.Active().ID != third.ID {
+		t.Errorf("active key = %d after rotating, want %d", keyring.Active().ID, third.ID)
+	}
+
+	var active []types.VapidKey
+	if err := db.Find(&active, "active = ?", true).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(active) != 1 || active[0].ID != third.ID {
+		t.Errorf("active keys = %+v, want only %d", active, third.ID)
+	}
+
+	tests := []struct {
+		name      string
+		publicKey string
+		want      uint
+	}{
+		{name: "first key", publicKey: first.PublicKey, want: first.ID},
+		{name: "rotated out key", publicKey: second.PublicKey, want: second.ID},
+		{name: "active key", publicKey: third.PublicKey, want: third.ID},
+		{name: "no key", want: third.ID},
+		{name: "unknown key", publicKey: "unknown", want: third.ID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := keyring.KeyFor(tt.publicKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if key.ID != tt.want {
+				t.Errorf("KeyFor = %d, want %d", key.ID, tt.want)
+			}
+		})
+	}
+}
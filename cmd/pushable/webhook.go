@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oliverisaac/pushable/types"
+	"github.com/oliverisaac/pushable/version"
+	"github.com/oliverisaac/pushable/views"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const (
+	// maxWebhookAttempts is how many times a delivery is tried before it is
+	// marked failed
+	maxWebhookAttempts = 8
+	// webhookBackoff is the wait after the first failure, doubling after
+	// each one up to maxWebhookBackoff
+	webhookBackoff    = 30 * time.Second
+	maxWebhookBackoff = time.Hour
+	// webhookLease holds a claimed delivery back from other replicas while
+	// it is being sent
+	webhookLease        = time.Minute
+	webhookPollInterval = 5 * time.Second
+	webhookBatch        = 50
+	// webhookConcurrency is how many deliveries are sent at once, so one
+	// slow endpoint doesn't hold up the rest
+	webhookConcurrency = 4
+	webhookLogLimit    = 100
+)
+
+var (
+	// webhookWake starts the worker early when events are queued
+	webhookWake   = make(chan struct{}, 1)
+	webhookClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// emitWebhook queues event for every enabled webhook that wants it. Failing
+// to queue is logged rather than failing what triggered it.
+func emitWebhook(db *gorm.DB, event string, data map[string]interface{}) {
+	log := loggerFrom(db.Statement.Context).WithField("event", event)
+
+	var webhooks []types.Webhook
+	if err := db.Where("enabled = ?", true).Find(&webhooks).Error; err != nil {
+		log.Error(errors.Wrap(err, "finding webhooks"))
+		return
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"event":      event,
+		"created_at": time.Now().UTC(),
+		"data":       data,
+	})
+	if err != nil {
+		log.Error(errors.Wrap(err, "marshalling webhook payload"))
+		return
+	}
+
+	queued := false
+	for _, webhook := range webhooks {
+		if !webhook.Subscribes(event) {
+			continue
+		}
+		delivery := types.WebhookDelivery{
+			WebhookID:     webhook.ID,
+			Event:         event,
+			Payload:       string(payload),
+			NextAttemptAt: time.Now(),
+		}
+		if err := db.Create(&delivery).Error; err != nil {
+			log.Error(errors.Wrapf(err, "queueing webhook %d", webhook.ID))
+			continue
+		}
+		queued = true
+	}
+
+	if queued {
+		select {
+		case webhookWake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// runWebhooks sends queued deliveries until ctx is done
+func runWebhooks(ctx context.Context, db *gorm.DB) {
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-webhookWake:
+		}
+
+		if err := sendDueWebhooks(ctx, db); err != nil {
+			logrus.Error(err)
+		}
+	}
+}
+
+func sendDueWebhooks(ctx context.Context, db *gorm.DB) error {
+	var due []types.WebhookDelivery
+	err := db.Preload("Webhook").
+		Where("delivered_at IS NULL AND failed = ? AND next_attempt_at <= ?", false, time.Now()).
+		Order("id").Limit(webhookBatch).Find(&due).Error
+	if err != nil {
+		return errors.Wrap(err, "finding due webhook deliveries")
+	}
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, webhookConcurrency)
+	for _, delivery := range due {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			if err := claimAndSendWebhook(ctx, db, delivery); err != nil {
+				logrus.WithField("delivery_id", delivery.ID).Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// claimAndSendWebhook claims a due delivery by bumping its attempt count, so
+// replicas sharing the database don't both send it, then sends it
+func claimAndSendWebhook(ctx context.Context, db *gorm.DB, delivery types.WebhookDelivery) error {
+	res := db.Model(&types.WebhookDelivery{}).
+		Where("id = ? AND attempts = ?", delivery.ID, delivery.Attempts).
+		Updates(map[string]interface{}{"attempts": delivery.Attempts + 1, "next_attempt_at": time.Now().Add(webhookLease)})
+	if res.Error != nil {
+		return errors.Wrap(res.Error, "claiming webhook delivery")
+	}
+	if res.RowsAffected == 0 {
+		return nil
+	}
+	delivery.Attempts++
+	return sendWebhook(ctx, db, delivery)
+}
+
+// webhookSignature is the hex HMAC-SHA256 of the timestamp and body joined
+// by a dot, sent as X-Pushable-Signature: sha256=<signature>
+func webhookSignature(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// sendWebhook makes one attempt at a claimed delivery and records the result
+func sendWebhook(ctx context.Context, db *gorm.DB, delivery types.WebhookDelivery) error {
+	updates := map[string]interface{}{}
+	statusCode, sendErr := postWebhook(ctx, delivery)
+	updates["status_code"] = statusCode
+	updates["error"] = ""
+	if sendErr != nil {
+		updates["error"] = sendErr.Error()
+	}
+
+	log := logrus.WithFields(logrus.Fields{"webhook_id": delivery.WebhookID, "delivery_id": delivery.ID, "event": delivery.Event, "attempt": delivery.Attempts})
+	switch {
+	case sendErr == nil:
+		updates["delivered_at"] = time.Now()
+		log.Info("webhook delivered")
+	case delivery.Attempts >= maxWebhookAttempts || delivery.Webhook.ID == 0 || !delivery.Webhook.Enabled:
+		updates["failed"] = true
+		log.WithError(sendErr).Warn("webhook delivery failed, giving up")
+	default:
+		backoff := min(webhookBackoff<<(delivery.Attempts-1), maxWebhookBackoff)
+		updates["next_attempt_at"] = time.Now().Add(backoff)
+		log.WithError(sendErr).Warnf("webhook delivery failed, retrying in %s", backoff)
+	}
+
+	return errors.Wrap(db.Model(&delivery).Updates(updates).Error, "saving webhook delivery")
+}
+
+func postWebhook(ctx context.Context, delivery types.WebhookDelivery) (int, error) {
+	webhook := delivery.Webhook
+	if webhook.ID == 0 {
+		return 0, fmt.Errorf("webhook was deleted")
+	}
+	if !webhook.Enabled {
+		return 0, fmt.Errorf("webhook is disabled")
+	}
+
+	body := []byte(delivery.Payload)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, errors.Wrap(err, "creating request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Pushable-Webhook/"+version.Tag)
+	req.Header.Set("X-Pushable-Event", delivery.Event)
+	req.Header.Set("X-Pushable-Delivery", strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set("X-Pushable-Timestamp", timestamp)
+	req.Header.Set("X-Pushable-Signature", "sha256="+webhookSignature(webhook.Secret, timestamp, body))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+func webhooksPageData(cfg types.Config, db *gorm.DB, c echo.Context) (types.WebhooksPageData, error) {
+	pageData := types.WebhooksPageData{Config: cfg}
+	if user, ok := GetSessionUser(c); ok {
+		pageData.User = &user
+	}
+
+	if err := db.Order("id").Find(&pageData.Webhooks).Error; err != nil {
+		return pageData, errors.Wrap(err, "listing webhooks")
+	}
+	if err := db.Order("id").Find(&pageData.Inbound).Error; err != nil {
+		return pageData, errors.Wrap(err, "listing inbound webhooks")
+	}
+
+	err := db.Preload("Webhook", func(tx *gorm.DB) *gorm.DB { return tx.Unscoped() }).
+		Order("id desc").Limit(webhookLogLimit).Find(&pageData.Deliveries).Error
+	if err != nil {
+		return pageData, errors.Wrap(err, "listing webhook deliveries")
+	}
+
+	return pageData, nil
+}
+
+// renderWebhooksPanel re-renders the webhooks panel after an action
+func renderWebhooksPanel(cfg types.Config, db *gorm.DB, c echo.Context, status int, msg string, actionErr error) error {
+	pageData, err := webhooksPageData(cfg, db, c)
+	if err != nil {
+		return err
+	}
+	return render(c, status, views.WebhooksPanel(pageData.WithMessage(msg).WithError(actionErr)))
+}
+
+func webhooksPage(cfg types.Config, db *gorm.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		pageData, err := webhooksPageData(cfg, db, c)
+		if err != nil {
+			return err
+		}
+		return render(c, 200, views.WebhooksPage(pageData))
+	}
+}
+
+func adminCreateWebhook(cfg types.Config, db *gorm.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		name := strings.TrimSpace(c.FormValue("name"))
+		if name == "" {
+			return renderWebhooksPanel(cfg, db, c, 422, "", fmt.Errorf("Give the webhook a name"))
+		}
+
+		target, err := url.Parse(c.FormValue("url"))
+		if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+			return renderWebhooksPanel(cfg, db, c, 422, "", fmt.Errorf("Invalid URL %q, it must be http or https", c.FormValue("url")))
+		}
+
+		form, err := c.FormParams()
+		if err != nil {
+			return errors.Wrap(err, "reading form")
+		}
+		events := form["events"]
+		if len(events) == 0 {
+			return renderWebhooksPanel(cfg, db, c, 422, "", fmt.Errorf("Choose at least one event"))
+		}
+		for _, event := range events {
+			if !slices.Contains(types.WebhookEvents, event) {
+				return renderWebhooksPanel(cfg, db, c, 422, "", fmt.Errorf("Unknown event %q", event))
+			}
+		}
+
+		secret, err := randomToken(32)
+		if err != nil {
+			return err
+		}
+
+		webhook := types.Webhook{
+			Name:    name,
+			URL:     target.String(),
+			Secret:  secret,
+			Events:  strings.Join(events, ","),
+			Enabled: true,
+		}
+		if err := db.Create(&webhook).Error; err != nil {
+			return errors.Wrap(err, "creating webhook")
+		}
+
+		return renderWebhooksPanel(cfg, db, c, 200, fmt.Sprintf("Webhook %s created, its signing secret is %s", name, secret), nil)
+	}
+}
+
+func adminSetWebhookEnabled(cfg types.Config, db *gorm.DB, enabled bool) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		res := db.Model(&types.Webhook{}).Where("id = ?", c.Param("id")).Update("enabled", enabled)
+		if res.Error != nil {
+			return errors.Wrap(res.Error, "updating webhook")
+		}
+		if res.RowsAffected == 0 {
+			return renderWebhooksPanel(cfg, db, c, 422, "", fmt.Errorf("Webhook not found"))
+		}
+
+		msg := "Webhook disabled"
+		if enabled {
+			msg = "Webhook enabled"
+		}
+		return renderWebhooksPanel(cfg, db, c, 200, msg, nil)
+	}
+}
+
+func adminDeleteWebhook(cfg types.Config, db *gorm.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if err := db.Delete(&types.Webhook{}, "id = ?", c.Param("id")).Error; err != nil {
+			return errors.Wrap(err, "deleting webhook")
+		}
+		return renderWebhooksPanel(cfg, db, c, 200, "Webhook deleted", nil)
+	}
+}
+
+// adminRetryWebhookDelivery gives a failed delivery one more attempt
+func adminRetryWebhookDelivery(cfg types.Config, db *gorm.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		res := db.Model(&types.WebhookDelivery{}).
+			Where("id = ? AND failed = ?", c.Param("id"), true).
+			Updates(map[string]interface{}{"failed": false, "attempts": maxWebhookAttempts - 1, "next_attempt_at": time.Now()})
+		if res.Error != nil {
+			return errors.Wrap(res.Error, "retrying webhook delivery")
+		}
+		if res.RowsAffected == 0 {
+			return renderWebhooksPanel(cfg, db, c, 422, "", fmt.Errorf("Only failed deliveries can be retried"))
+		}
+
+		select {
+		case webhookWake <- struct{}{}:
+		default:
+		}
+		return renderWebhooksPanel(cfg, db, c, 200, "Delivery queued for another attempt", nil)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/oliverisaac/pushable/types"
+)
+
+func TestSendDueWebhooks(t *testing.T) {
+	for name, db := range testDialects(t) {
+		t.Run(name, func(t *testing.T) {
+			// The slow endpoint holds its request until the fast one has
+			// been sent, so a batch sent one at a time never finishes
+			fastSent := make(chan struct{})
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/slow":
+					select {
+					case <-fastSent:
+					case <-time.After(5 * time.Second):
+					}
+				case "/fast":
+					close(fastSent)
+				default:
+					w.WriteHeader(http.StatusInternalServerError)
+				}
+			}))
+			defer server.Close()
+
+			for _, path := range []string{"/slow", "/broken", "/fast"} {
+				webhook := types.Webhook{Name: path, URL: server.URL + path, Events: types.WebhookNotificationSent, Enabled: true}
+				if err := db.Create(&webhook).Error; err != nil {
+					t.Fatal(err)
+				}
+				delivery := types.WebhookDelivery{WebhookID: webhook.ID, Event: types.WebhookNotificationSent, Payload: "{}", NextAttemptAt: time.Now()}
+				if err := db.Create(&delivery).Error; err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			start := time.Now()
+			if err := sendDueWebhooks(context.Background(), db); err != nil {
+				t.Fatal(err)
+			}
+			if elapsed := time.Since(start); elapsed > 4*time.Second {
+				t.Errorf("batch took %s, the fast delivery waited on the slow one", elapsed)
+			}
+
+			var deliveries []types.WebhookDelivery
+			if err := db.Preload("Webhook").Order("id").Find(&deliveries).Error; err != nil {
+				t.Fatal(err)
+			}
+			for _, d := range deliveries {
+				wantDelivered := d.Webhook.Name != "/broken"
+				if (d.DeliveredAt != nil) != wantDelivered || d.Attempts != 1 {
+					t.Errorf("%s: delivered %v after %d attempts, want delivered %v after 1", d.Webhook.Name, d.DeliveredAt != nil, d.Attempts, wantDelivered)
+				}
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package pushclient
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
@@ -8,9 +9,16 @@ import (
 	"strings"
 
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/propagation"
 )
 
 func SendPush(endpointHostname string, push Push) error {
+	return SendPushContext(context.Background(), endpointHostname, push)
+}
+
+// SendPushContext sends push and propagates the trace in ctx, if any, with a
+// traceparent header
+func SendPushContext(ctx context.Context, endpointHostname string, push Push) error {
 	formData := url.Values{}
 	formData.Set("topic", push.Topic)
 	formData.Set("title", push.Title)
@@ -20,7 +28,16 @@ func SendPush(endpointHostname string, push Push) error {
 	formData.Set("badge", push.Badge)
 
 	endpoint := fmt.Sprintf("https://%s/push", endpointHostname)
-	resp, err := http.Post(endpoint, "application/x-www-form-urlencoded", strings.NewReader(formData.Encode()))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(formData.Encode()))
+	if err != nil {
+		return errors.Wrap(err, "Failed to build request")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	// Injected directly rather than through the global propagator, which
+	// is a no-op unless the calling program registers one
+	propagation.TraceContext{}.Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return err
 	}
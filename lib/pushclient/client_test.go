@@ -0,0 +1,54 @@
+package pushclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSendPushContextTraceparent(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	tracedCtx, span := provider.Tracer("test").Start(context.Background(), "caller")
+	span.End()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		// want is the trace ID the header must carry, empty for no header
+		want string
+	}{
+		{"traced caller", tracedCtx, span.SpanContext().TraceID().String()},
+		{"untraced caller", context.Background(), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var traceparent string
+			srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				traceparent = r.Header.Get("traceparent")
+			}))
+			defer srv.Close()
+
+			transport := http.DefaultClient.Transport
+			http.DefaultClient.Transport = srv.Client().Transport
+			defer func() { http.DefaultClient.Transport = transport }()
+
+			err := SendPushContext(tt.ctx, srv.Listener.Addr().String(), Push{Topic: "test", Title: "Test"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			switch {
+			case tt.want == "" && traceparent != "":
+				t.Errorf("traceparent = %q, want none", traceparent)
+			case tt.want != "" && !strings.Contains(traceparent, tt.want):
+				t.Errorf("traceparent = %q, want trace %s", traceparent, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 seed from RFC 6238 appendix B, base32 encoded
+var rfcSecret = encoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeAt(t *testing.T) {
+	// The RFC lists 8 digit codes, these are their last 6 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := CodeAt(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("CodeAt(%d): %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("CodeAt(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+	code := func(step int64) string {
+		c, err := CodeAt(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", rfcSecret, code(step), step, true},
+		{"previous step", rfcSecret, code(step - 1), step - 1, true},
+		{"next step", rfcSecret, code(step + 1), step + 1, true},
+		{"outside skew", rfcSecret, code(step - 2), 0, false},
+		{"spaces", rfcSecret, code(step)[:3] + " " + code(step)[3:], step, true},
+		{"lowercase secret", strings.ToLower(rfcSecret), code(step), step, true},
+		{"wrong code", rfcSecret, "000000", 0, false},
+		{"too short", rfcSecret, code(step)[:5], 0, false},
+		{"bad secret", "not base32!", code(step), 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, gotOK := Validate(tt.secret, tt.code, now)
+			if gotOK != tt.wantOK || gotStep != tt.wantStep {
+				t.Errorf("Validate() = %d, %t, want %d, %t", gotStep, gotOK, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// The schema as AutoMigrate left it. On a database that predates versioned
+// migrations this only fills in anything missing.
+
+type v1User struct {
+	gorm.Model
+	Name              string
+	Email             string
+	Password          string
+	Role              string
+	Disabled          bool
+	SessionVersion    int
+	TOTPSecret        string
+	TOTPEnabled       bool
+	TOTPLastStep      int64
+	PushSubscriptions []v1PushSubscription `gorm:"foreignKey:UserID"`
+	CreatedAt         time.Time            `gorm:"autoCreateTime"`
+	UpdatedAt         *time.Time           `gorm:"autoUpdateTime"`
+	DeletedAt         *time.Time
+}
+
+func (v1User) TableName() string { return "users" }
+
+type v1PushSubscription struct {
+	gorm.Model
+	UserID     uint
+	VapidKeyID uint
+	Endpoint   string
+	P256DH     string
+	Auth       string
+	Keys       string
+}
+
+func (v1PushSubscription) TableName() string { return "push_subscriptions" }
+
+type v1Setting struct {
+	Key       string `gorm:"primaryKey"`
+	Value     string
+	UpdatedAt time.Time
+}
+
+func (v1Setting) TableName() string { return "settings" }
+
+type v1Invite struct {
+	gorm.Model
+	Token       string `gorm:"uniqueIndex:idx_invites_token"`
+	CreatedByID uint
+	Role        string
+	MaxUses     int
+	Uses        int
+	ExpiresAt   time.Time
+}
+
+func (v1Invite) TableName() string { return "invites" }
+
+type v1PasswordReset struct {
+	gorm.Model
+	UserID    uint
+	TokenHash string `gorm:"uniqueIndex:idx_password_resets_token_hash"`
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+func (v1PasswordReset) TableName() string { return "password_resets" }
+
+type v1Session struct {
+	gorm.Model
+	UserID     uint
+	TokenHash  string `gorm:"uniqueIndex:idx_sessions_token_hash"`
+	UserAgent  string
+	IP         string
+	LastSeenAt time.Time
+	RevokedAt  *time.Time
+}
+
+func (v1Session) TableName() string { return "sessions" }
+
+type v1RecoveryCode struct {
+	gorm.Model
+	UserID   uint
+	CodeHash string
+	UsedAt   *time.Time
+}
+
+func (v1RecoveryCode) TableName() string { return "recovery_codes" }
+
+type v1Notification struct {
+	gorm.Model
+	Topic string
+	Title string
+	Body  string
+	Icon  string
+	Badge string
+	Link  string
+}
+
+func (v1Notification) TableName() string { return "notifications" }
+
+type v1NotificationEvent struct {
+	gorm.Model
+	NotificationID uint `gorm:"index:idx_notification_events_notification_id"`
+	Type           string
+	IP             string
+	UserAgent      string
+}
+
+func (v1NotificationEvent) TableName() string { return "notification_events" }
+
+type v1PendingDelivery struct {
+	gorm.Model
+	NotificationID uint `gorm:"index:idx_pending_deliveries_notification_id"`
+	SubscriptionID uint
+}
+
+func (v1PendingDelivery) TableName() string { return "pending_deliveries" }
+
+type v1RateLimitBucket struct {
+	Key        string `gorm:"primaryKey"`
+	Tokens     float64
+	Suppressed int
+	UpdatedAt  time.Time
+}
+
+func (v1RateLimitBucket) TableName() string { return "rate_limit_buckets" }
+
+type v1LoginAttempt struct {
+	gorm.Model
+	Email     string `gorm:"index:idx_login_attempts_email"`
+	IP        string `gorm:"index:idx_login_attempts_ip"`
+	UserAgent string
+	Success   bool
+}
+
+func (v1LoginAttempt) TableName() string { return "login_attempts" }
+
+type v1AuditEvent struct {
+	ID        uint      `gorm:"primarykey"`
+	CreatedAt time.Time `gorm:"index:idx_audit_events_created_at"`
+	ActorID   *uint     `gorm:"index:idx_audit_events_actor_id"`
+	Actor     string
+	Action    string `gorm:"index:idx_audit_events_action"`
+	Target    string
+	IP        string
+	UserAgent string
+}
+
+func (v1AuditEvent) TableName() string { return "audit_events" }
+
+type v1VapidKey struct {
+	gorm.Model
+	PublicKey  string `gorm:"uniqueIndex:idx_vapid_keys_public_key"`
+	PrivateKey string
+	Active     bool
+}
+
+func (v1VapidKey) TableName() string { return "vapid_keys" }
+
+var v1Tables = []interface{}{
+	&v1User{}, &v1PushSubscription{}, &v1Setting{}, &v1Invite{}, &v1PasswordReset{}, &v1Session{},
+	&v1RecoveryCode{}, &v1Notification{}, &v1NotificationEvent{}, &v1PendingDelivery{},
+	&v1RateLimitBucket{}, &v1LoginAttempt{}, &v1AuditEvent{}, &v1VapidKey{},
+}
+
+func init() {
+	register(Migration{
+		Version: 1,
+		Name:    "initial_schema",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(v1Tables...)
+		},
+		Down: func(tx *gorm.DB) error {
+			// Dropped in reverse so push_subscriptions goes before users
+			for i := len(v1Tables) - 1; i >= 0; i-- {
+				if err := tx.Migrator().DropTable(v1Tables[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+}
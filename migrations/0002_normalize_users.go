@@ -0,0 +1,34 @@
+package migrations
+
+import (
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// v2User drops the CreatedAt, UpdatedAt and DeletedAt fields User declared
+// on top of gorm.Model, which left updated_at nullable and deleted_at
+// without its index
+type v2User struct {
+	gorm.Model
+}
+
+func (v2User) TableName() string { return "users" }
+
+func init() {
+	register(Migration{
+		Version: 2,
+		Name:    "normalize_users",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.Exec("UPDATE users SET updated_at = created_at WHERE updated_at IS NULL").Error; err != nil {
+				return errors.Wrap(err, "filling in users.updated_at")
+			}
+			if tx.Migrator().HasIndex(&v2User{}, "DeletedAt") {
+				return nil
+			}
+			return errors.Wrap(tx.Migrator().CreateIndex(&v2User{}, "DeletedAt"), "indexing users.deleted_at")
+		},
+		Down: func(tx *gorm.DB) error {
+			return errors.Wrap(tx.Migrator().DropIndex(&v2User{}, "DeletedAt"), "dropping users.deleted_at index")
+		},
+	})
+}
@@ -0,0 +1,46 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type v3Webhook struct {
+	gorm.Model
+	Name    string
+	URL     string
+	Secret  string
+	Events  string
+	Enabled bool
+}
+
+func (v3Webhook) TableName() string { return "webhooks" }
+
+type v3WebhookDelivery struct {
+	gorm.Model
+	WebhookID     uint `gorm:"index:idx_webhook_deliveries_webhook_id"`
+	Event         string
+	Payload       string
+	Attempts      int
+	NextAttemptAt time.Time `gorm:"index:idx_webhook_deliveries_next_attempt_at"`
+	StatusCode    int
+	Error         string
+	DeliveredAt   *time.Time
+	Failed        bool
+}
+
+func (v3WebhookDelivery) TableName() string { return "webhook_deliveries" }
+
+func init() {
+	register(Migration{
+		Version: 3,
+		Name:    "webhooks",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().CreateTable(&v3Webhook{}, &v3WebhookDelivery{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&v3WebhookDelivery{}, &v3Webhook{})
+		},
+	})
+}
@@ -0,0 +1,35 @@
+package migrations
+
+import (
+	"gorm.io/gorm"
+)
+
+type v4InboundWebhook struct {
+	gorm.Model
+	Name            string
+	Token           string `gorm:"uniqueIndex:idx_inbound_webhooks_token"`
+	Verify          string
+	Secret          string
+	SignatureHeader string
+	Topic           string
+	TitleTemplate   string
+	BodyTemplate    string
+	LinkTemplate    string
+	IconTemplate    string
+	Enabled         bool
+}
+
+func (v4InboundWebhook) TableName() string { return "inbound_webhooks" }
+
+func init() {
+	register(Migration{
+		Version: 4,
+		Name:    "inbound_webhooks",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().CreateTable(&v4InboundWebhook{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&v4InboundWebhook{})
+		},
+	})
+}
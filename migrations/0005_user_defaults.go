@@ -0,0 +1,66 @@
+package migrations
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// v5User gives the columns AutoMigrate added to users after the baseline a
+// default. Rows that predate those columns were left NULL, which no query
+// comparing them matches.
+type v5User struct {
+	gorm.Model
+	Disabled       bool   `gorm:"not null;default:false"`
+	SessionVersion int    `gorm:"not null;default:0"`
+	TOTPSecret     string `gorm:"not null;default:''"`
+	TOTPEnabled    bool   `gorm:"not null;default:false"`
+	TOTPLastStep   int64  `gorm:"not null;default:0"`
+}
+
+func (v5User) TableName() string { return "users" }
+
+// v5UserDefaults are the values NULLs are backfilled with, by column
+var v5UserDefaults = []struct {
+	field  string
+	column string
+	value  interface{}
+}{
+	{"Disabled", "disabled", false},
+	{"SessionVersion", "session_version", 0},
+	{"TOTPSecret", "totp_secret", ""},
+	{"TOTPEnabled", "totp_enabled", false},
+	{"TOTPLastStep", "totp_last_step", 0},
+}
+
+func init() {
+	register(Migration{
+		Version: 5,
+		Name:    "user_defaults",
+		// SQLite adds a default by rebuilding users, which
+		// push_subscriptions refers to
+		RebuildsTables: true,
+		Up: func(tx *gorm.DB) error {
+			for _, d := range v5UserDefaults {
+				err := tx.Exec(fmt.Sprintf("UPDATE users SET %s = ? WHERE %s IS NULL", d.column, d.column), d.value).Error
+				if err != nil {
+					return errors.Wrapf(err, "filling in users.%s", d.column)
+				}
+				if err := tx.Migrator().AlterColumn(&v5User{}, d.field); err != nil {
+					return errors.Wrapf(err, "adding a default to users.%s", d.column)
+				}
+			}
+			// Rebuilding users on SQLite drops its indexes
+			if tx.Migrator().HasIndex(&v5User{}, "DeletedAt") {
+				return nil
+			}
+			return errors.Wrap(tx.Migrator().CreateIndex(&v5User{}, "DeletedAt"), "indexing users.deleted_at")
+		},
+		Down: func(tx *gorm.DB) error {
+			// The defaults are harmless to keep and SQLite can only drop
+			// them by rebuilding the table
+			return nil
+		},
+	})
+}
@@ -0,0 +1,93 @@
+package migrations
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+type v6Invite struct {
+	gorm.Model
+	Token       string `gorm:"uniqueIndex:idx_invites_token"`
+	TokenHash   string `gorm:"uniqueIndex:idx_invites_token_hash"`
+	CreatedByID uint
+	Role        string
+	MaxUses     int
+	Uses        int
+	ExpiresAt   time.Time
+}
+
+func (v6Invite) TableName() string { return "invites" }
+
+func init() {
+	register(Migration{
+		Version: 6,
+		Name:    "hash_invite_tokens",
+		Up: func(tx *gorm.DB) error {
+			m := tx.Migrator()
+			if err := m.AddColumn(&v6Invite{}, "TokenHash"); err != nil {
+				return errors.Wrap(err, "adding invites.token_hash")
+			}
+
+			var invites []v6Invite
+			if err := tx.Unscoped().Select("id", "token").Find(&invites).Error; err != nil {
+				return errors.Wrap(err, "listing invites")
+			}
+			for _, invite := range invites {
+				sum := sha256.Sum256([]byte(invite.Token))
+				err := tx.Model(&v6Invite{}).Unscoped().Where("id = ?", invite.ID).
+					Update("token_hash", hex.EncodeToString(sum[:])).Error
+				if err != nil {
+					return errors.Wrap(err, "hashing invite token")
+				}
+			}
+
+			if err := m.DropIndex(&v6Invite{}, "idx_invites_token"); err != nil {
+				return errors.Wrap(err, "dropping invites.token index")
+			}
+			if err := m.DropColumn(&v6Invite{}, "Token"); err != nil {
+				return errors.Wrap(err, "dropping invites.token")
+			}
+			// Dropping a column on SQLite rebuilds the table without its
+			// indexes
+			for _, index := range []string{"idx_invites_token_hash", "DeletedAt"} {
+				if m.HasIndex(&v6Invite{}, index) {
+					continue
+				}
+				if err := m.CreateIndex(&v6Invite{}, index); err != nil {
+					return errors.Wrapf(err, "creating invites index %s", index)
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			// The raw tokens can't be recovered, so outstanding invites
+			// stop working
+			m := tx.Migrator()
+			if err := tx.Unscoped().Where("1 = 1").Delete(&v6Invite{}).Error; err != nil {
+				return errors.Wrap(err, "deleting invites")
+			}
+			if err := m.DropIndex(&v6Invite{}, "idx_invites_token_hash"); err != nil {
+				return errors.Wrap(err, "dropping invites.token_hash index")
+			}
+			if err := m.DropColumn(&v6Invite{}, "TokenHash"); err != nil {
+				return errors.Wrap(err, "dropping invites.token_hash")
+			}
+			if err := m.AddColumn(&v6Invite{}, "Token"); err != nil {
+				return errors.Wrap(err, "adding invites.token")
+			}
+			for _, index := range []string{"idx_invites_token", "DeletedAt"} {
+				if m.HasIndex(&v6Invite{}, index) {
+					continue
+				}
+				if err := m.CreateIndex(&v6Invite{}, index); err != nil {
+					return errors.Wrapf(err, "creating invites index %s", index)
+				}
+			}
+			return nil
+		},
+	})
+}
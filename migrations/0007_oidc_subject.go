@@ -0,0 +1,44 @@
+package migrations
+
+import (
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+type v7User struct {
+	gorm.Model
+	OIDCSubject string `gorm:"column:oidc_subject;not null;default:'';index"`
+}
+
+func (v7User) TableName() string { return "users" }
+
+func init() {
+	register(Migration{
+		Version: 7,
+		Name:    "oidc_subject",
+		// Dropping the column on SQLite rebuilds users, which
+		// push_subscriptions refers to
+		RebuildsTables: true,
+		Up: func(tx *gorm.DB) error {
+			m := tx.Migrator()
+			if err := m.AddColumn(&v7User{}, "OIDCSubject"); err != nil {
+				return errors.Wrap(err, "adding users.oidc_subject")
+			}
+			return errors.Wrap(m.CreateIndex(&v7User{}, "OIDCSubject"), "indexing users.oidc_subject")
+		},
+		Down: func(tx *gorm.DB) error {
+			m := tx.Migrator()
+			if err := m.DropIndex(&v7User{}, "OIDCSubject"); err != nil {
+				return errors.Wrap(err, "dropping users.oidc_subject index")
+			}
+			if err := m.DropColumn(&v7User{}, "OIDCSubject"); err != nil {
+				return errors.Wrap(err, "dropping users.oidc_subject")
+			}
+			// Rebuilding users on SQLite drops its indexes
+			if m.HasIndex(&v7User{}, "DeletedAt") {
+				return nil
+			}
+			return errors.Wrap(m.CreateIndex(&v7User{}, "DeletedAt"), "indexing users.deleted_at")
+		},
+	})
+}
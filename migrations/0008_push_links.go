@@ -0,0 +1,64 @@
+package migrations
+
+import (
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+type v8Notification struct {
+	gorm.Model
+	Link string
+}
+
+func (v8Notification) TableName() string { return "notifications" }
+
+type v8PendingDelivery struct {
+	gorm.Model
+	NotificationID uint `gorm:"index:idx_pending_deliveries_notification_id"`
+	Link           string
+}
+
+func (v8PendingDelivery) TableName() string { return "pending_deliveries" }
+
+// restoreIndexes recreates the indexes SQLite drops when it rebuilds a table
+// to drop a column
+func restoreIndexes(m gorm.Migrator, model interface{}, indexes ...string) error {
+	for _, index := range indexes {
+		if m.HasIndex(model, index) {
+			continue
+		}
+		if err := m.CreateIndex(model, index); err != nil {
+			return errors.Wrapf(err, "recreating index %s", index)
+		}
+	}
+	return nil
+}
+
+func init() {
+	register(Migration{
+		Version: 8,
+		Name:    "push_links",
+		// Links can carry credentials, such as reset tokens, so they're only
+		// kept for deliveries waiting to be resumed
+		Up: func(tx *gorm.DB) error {
+			m := tx.Migrator()
+			if err := m.DropColumn(&v8Notification{}, "Link"); err != nil {
+				return errors.Wrap(err, "dropping notifications.link")
+			}
+			if err := restoreIndexes(m, &v8Notification{}, "DeletedAt"); err != nil {
+				return err
+			}
+			return errors.Wrap(m.AddColumn(&v8PendingDelivery{}, "Link"), "adding pending_deliveries.link")
+		},
+		Down: func(tx *gorm.DB) error {
+			m := tx.Migrator()
+			if err := m.DropColumn(&v8PendingDelivery{}, "Link"); err != nil {
+				return errors.Wrap(err, "dropping pending_deliveries.link")
+			}
+			if err := restoreIndexes(m, &v8PendingDelivery{}, "DeletedAt", "idx_pending_deliveries_notification_id"); err != nil {
+				return err
+			}
+			return errors.Wrap(m.AddColumn(&v8Notification{}, "Link"), "adding notifications.link")
+		},
+	})
+}
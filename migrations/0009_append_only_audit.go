@@ -0,0 +1,60 @@
+package migrations
+
+import (
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// appendOnlyAudit has the database refuse changes to audit_events, so the
+// log stays append-only for anything that bypasses the gorm hooks. A later
+// migration that rebuilds audit_events on SQLite has to recreate these.
+var appendOnlyAudit = map[string]struct{ up, down []string }{
+	"sqlite": {
+		up: []string{
+			`CREATE TRIGGER audit_events_no_update BEFORE UPDATE ON audit_events
+			BEGIN SELECT RAISE(ABORT, 'audit events cannot be changed'); END`,
+			`CREATE TRIGGER audit_events_no_delete BEFORE DELETE ON audit_events
+			BEGIN SELECT RAISE(ABORT, 'audit events cannot be deleted'); END`,
+		},
+		down: []string{
+			"DROP TRIGGER audit_events_no_update",
+			"DROP TRIGGER audit_events_no_delete",
+		},
+	},
+	"postgres": {
+		up: []string{
+			`CREATE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+			BEGIN RAISE EXCEPTION 'audit events cannot be changed or deleted'; END
+			$$ LANGUAGE plpgsql`,
+			`CREATE TRIGGER audit_events_append_only BEFORE UPDATE OR DELETE OR TRUNCATE ON audit_events
+			FOR EACH STATEMENT EXECUTE FUNCTION audit_events_append_only()`,
+		},
+		down: []string{
+			"DROP TRIGGER audit_events_append_only ON audit_events",
+			"DROP FUNCTION audit_events_append_only()",
+		},
+	},
+}
+
+func init() {
+	register(Migration{
+		Version: 9,
+		Name:    "append_only_audit",
+		Up: func(tx *gorm.DB) error {
+			for _, stmt := range appendOnlyAudit[tx.Dialector.Name()].up {
+				if err := tx.Exec(stmt).Error; err != nil {
+					return errors.Wrap(err, "guarding audit_events")
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			for _, stmt := range appendOnlyAudit[tx.Dialector.Name()].down {
+				if err := tx.Exec(stmt).Error; err != nil {
+					return errors.Wrap(err, "unguarding audit_events")
+				}
+			}
+			return nil
+		},
+	})
+}
@@ -0,0 +1,84 @@
+package migrations
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+type v10InboundWebhook struct {
+	gorm.Model
+	Token     string `gorm:"uniqueIndex:idx_inbound_webhooks_token"`
+	TokenHash string `gorm:"uniqueIndex:idx_inbound_webhooks_token_hash"`
+}
+
+func (v10InboundWebhook) TableName() string { return "inbound_webhooks" }
+
+func init() {
+	register(Migration{
+		Version: 10,
+		Name:    "hash_inbound_tokens",
+		Up: func(tx *gorm.DB) error {
+			m := tx.Migrator()
+			if err := m.AddColumn(&v10InboundWebhook{}, "TokenHash"); err != nil {
+				return errors.Wrap(err, "adding inbound_webhooks.token_hash")
+			}
+
+			var hooks []v10InboundWebhook
+			if err := tx.Unscoped().Select("id", "token").Find(&hooks).Error; err != nil {
+				return errors.Wrap(err, "listing inbound webhooks")
+			}
+			for _, hook := range hooks {
+				sum := sha256.Sum256([]byte(hook.Token))
+				err := tx.Model(&v10InboundWebhook{}).Unscoped().Where("id = ?", hook.ID).
+					Update("token_hash", hex.EncodeToString(sum[:])).Error
+				if err != nil {
+					return errors.Wrap(err, "hashing inbound webhook token")
+				}
+			}
+
+			if err := m.DropIndex(&v10InboundWebhook{}, "idx_inbound_webhooks_token"); err != nil {
+				return errors.Wrap(err, "dropping inbound_webhooks.token index")
+			}
+			if err := m.DropColumn(&v10InboundWebhook{}, "Token"); err != nil {
+				return errors.Wrap(err, "dropping inbound_webhooks.token")
+			}
+			return restoreIndexes(m, &v10InboundWebhook{}, "idx_inbound_webhooks_token_hash", "DeletedAt")
+		},
+		Down: func(tx *gorm.DB) error {
+			// The raw tokens can't be recovered, so every endpoint gets a
+			// new URL that has to be handed out again
+			m := tx.Migrator()
+			if err := m.DropIndex(&v10InboundWebhook{}, "idx_inbound_webhooks_token_hash"); err != nil {
+				return errors.Wrap(err, "dropping inbound_webhooks.token_hash index")
+			}
+			if err := m.DropColumn(&v10InboundWebhook{}, "TokenHash"); err != nil {
+				return errors.Wrap(err, "dropping inbound_webhooks.token_hash")
+			}
+			if err := m.AddColumn(&v10InboundWebhook{}, "Token"); err != nil {
+				return errors.Wrap(err, "adding inbound_webhooks.token")
+			}
+
+			var hooks []v10InboundWebhook
+			if err := tx.Unscoped().Select("id").Find(&hooks).Error; err != nil {
+				return errors.Wrap(err, "listing inbound webhooks")
+			}
+			for _, hook := range hooks {
+				b := make([]byte, 24)
+				if _, err := rand.Read(b); err != nil {
+					return errors.Wrap(err, "reading random bytes")
+				}
+				err := tx.Model(&v10InboundWebhook{}).Unscoped().Where("id = ?", hook.ID).
+					Update("token", base64.RawURLEncoding.EncodeToString(b)).Error
+				if err != nil {
+					return errors.Wrap(err, "replacing inbound webhook token")
+				}
+			}
+			return restoreIndexes(m, &v10InboundWebhook{}, "idx_inbound_webhooks_token", "DeletedAt")
+		},
+	})
+}
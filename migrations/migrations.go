@@ -0,0 +1,210 @@
+// Package migrations holds the versioned schema changes, compiled into the
+// binary and applied in order. Each migration defines the shape of the
+// tables it touches as of that version instead of using the types package,
+// so later changes to the types can't change what an old migration does.
+package migrations
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/oliverisaac/pushable/types"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+	// RebuildsTables runs Up and Down with SQLite's foreign key checks
+	// off, so a table other tables refer to can be rebuilt. The keys are
+	// checked once they are done instead.
+	RebuildsTables bool
+}
+
+var all []Migration
+
+// register adds a migration, called from each migration file's init
+func register(m Migration) {
+	all = append(all, m)
+	sort.Slice(all, func(i, j int) bool { return all[i].Version < all[j].Version })
+}
+
+func applied(db *gorm.DB) (map[int]types.SchemaMigration, error) {
+	if err := db.AutoMigrate(&types.SchemaMigration{}); err != nil {
+		return nil, errors.Wrap(err, "creating schema_migrations")
+	}
+
+	var rows []types.SchemaMigration
+	if err := db.Find(&rows).Error; err != nil {
+		return nil, errors.Wrap(err, "listing applied migrations")
+	}
+	ret := map[int]types.SchemaMigration{}
+	for _, row := range rows {
+		ret[row.Version] = row
+	}
+	return ret, nil
+}
+
+// Status lists every migration with when it was applied
+func Status(db *gorm.DB) ([]types.MigrationStatus, error) {
+	done, err := applied(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []types.MigrationStatus
+	for _, m := range all {
+		status := types.MigrationStatus{Version: m.Version, Name: m.Name}
+		if row, ok := done[m.Version]; ok {
+			status.AppliedAt = &row.AppliedAt
+		}
+		ret = append(ret, status)
+	}
+	return ret, nil
+}
+
+// Pending counts the migrations that haven't been applied
+func Pending(db *gorm.DB) (int, error) {
+	status, err := Status(db)
+	if err != nil {
+		return 0, err
+	}
+	pending := 0
+	for _, s := range status {
+		if s.AppliedAt == nil {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+// lock stops replicas sharing a Postgres database from running the same
+// migration at once. SQLite already allows only one writer.
+func lock(tx *gorm.DB) error {
+	if tx.Dialector.Name() != "postgres" {
+		return nil
+	}
+	return errors.Wrap(tx.Exec("SELECT pg_advisory_xact_lock(?)", int64(0x70757368)).Error, "locking schema_migrations")
+}
+
+// withoutForeignKeys runs fn on a single SQLite connection with foreign key
+// checks off. SQLite ignores the pragma inside a transaction, so it has to
+// be set before fn opens one.
+func withoutForeignKeys(db *gorm.DB, fn func(db *gorm.DB) error) error {
+	return db.Connection(func(conn *gorm.DB) error {
+		if err := conn.Exec("PRAGMA foreign_keys = OFF").Error; err != nil {
+			return errors.Wrap(err, "turning off foreign keys")
+		}
+		defer conn.Exec("PRAGMA foreign_keys = ON")
+		return fn(conn)
+	})
+}
+
+// checkForeignKeys fails if anything Up did left a dangling foreign key
+func checkForeignKeys(tx *gorm.DB) error {
+	var violations []map[string]interface{}
+	if err := tx.Raw("PRAGMA foreign_key_check").Scan(&violations).Error; err != nil {
+		return errors.Wrap(err, "checking foreign keys")
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("%d rows fail their foreign key, first %v", len(violations), violations[0])
+	}
+	return nil
+}
+
+// Up applies every pending migration in order, each in its own transaction.
+// It returns the migrations it applied.
+func Up(db *gorm.DB) ([]Migration, error) {
+	if _, err := applied(db); err != nil {
+		return nil, err
+	}
+
+	var ran []Migration
+	for _, m := range all {
+		didRun := false
+		rebuild := m.RebuildsTables && db.Dialector.Name() == "sqlite"
+		apply := func(db *gorm.DB) error {
+			return db.Transaction(func(tx *gorm.DB) error {
+				if err := lock(tx); err != nil {
+					return err
+				}
+				var count int64
+				if err := tx.Model(&types.SchemaMigration{}).Where("version = ?", m.Version).Count(&count).Error; err != nil {
+					return errors.Wrap(err, "checking migration")
+				}
+				if count > 0 {
+					return nil
+				}
+
+				if err := m.Up(tx); err != nil {
+					return err
+				}
+				if rebuild {
+					if err := checkForeignKeys(tx); err != nil {
+						return err
+					}
+				}
+				didRun = true
+				return errors.Wrap(tx.Create(&types.SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error, "recording migration")
+			})
+		}
+
+		var err error
+		if rebuild {
+			err = withoutForeignKeys(db, apply)
+		} else {
+			err = apply(db)
+		}
+		if err != nil {
+			return ran, errors.Wrapf(err, "migration %d %s", m.Version, m.Name)
+		}
+		if didRun {
+			ran = append(ran, m)
+		}
+	}
+	return ran, nil
+}
+
+// Down reverts the most recently applied migration
+func Down(db *gorm.DB) (Migration, error) {
+	done, err := applied(db)
+	if err != nil {
+		return Migration{}, err
+	}
+
+	for i := len(all) - 1; i >= 0; i-- {
+		m := all[i]
+		if _, ok := done[m.Version]; !ok {
+			continue
+		}
+
+		rebuild := m.RebuildsTables && db.Dialector.Name() == "sqlite"
+		revert := func(db *gorm.DB) error {
+			return db.Transaction(func(tx *gorm.DB) error {
+				if err := lock(tx); err != nil {
+					return err
+				}
+				if err := m.Down(tx); err != nil {
+					return err
+				}
+				if rebuild {
+					if err := checkForeignKeys(tx); err != nil {
+						return err
+					}
+				}
+				return errors.Wrap(tx.Delete(&types.SchemaMigration{}, m.Version).Error, "removing migration record")
+			})
+		}
+		if rebuild {
+			err = withoutForeignKeys(db, revert)
+		} else {
+			err = revert(db)
+		}
+		return m, errors.Wrapf(err, "reverting migration %d %s", m.Version, m.Name)
+	}
+	return Migration{}, fmt.Errorf("no migrations have been applied")
+}
@@ -0,0 +1,96 @@
+package migrations
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/ncruces/go-sqlite3/embed"
+	sqlite "github.com/ncruces/go-sqlite3/gormlite"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestUpDown(t *testing.T) {
+	dialectors := map[string]func(t *testing.T) gorm.Dialector{
+		"sqlite": func(t *testing.T) gorm.Dialector {
+			return sqlite.Open(filepath.Join(t.TempDir(), "test.db"))
+		},
+		"postgres": func(t *testing.T) gorm.Dialector {
+			pgURL := os.Getenv("PUSHABLE_TEST_POSTGRES_URL")
+			if pgURL == "" {
+				t.Skip("PUSHABLE_TEST_POSTGRES_URL is not set")
+			}
+			admin, err := gorm.Open(postgres.Open(pgURL), &gorm.Config{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			schema := fmt.Sprintf("pushable_test_%d", time.Now().UnixNano())
+			if err := admin.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { admin.Exec("DROP SCHEMA " + schema + " CASCADE") })
+
+			u, err := url.Parse(pgURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			q := u.Query()
+			q.Set("search_path", schema)
+			u.RawQuery = q.Encode()
+			return postgres.Open(u.String())
+		},
+	}
+
+	for name, dialector := range dialectors {
+		t.Run(name, func(t *testing.T) {
+			db, err := gorm.Open(dialector(t), &gorm.Config{Logger: logger.Discard})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			steps := []struct {
+				name        string
+				run         func() error
+				wantPending int
+			}{
+				{"up from empty", func() error { _, err := Up(db); return err }, 0},
+				{"up again is a no-op", func() error { _, err := Up(db); return err }, 0},
+				{"down one", func() error { _, err := Down(db); return err }, 1},
+				{"up the reverted one", func() error { _, err := Up(db); return err }, 0},
+				{"down to empty", func() error {
+					for range all {
+						if _, err := Down(db); err != nil {
+							return err
+						}
+					}
+					return nil
+				}, len(all)},
+				{"up from scratch", func() error { _, err := Up(db); return err }, 0},
+			}
+			for _, step := range steps {
+				if err := step.run(); err != nil {
+					t.Fatalf("%s: %v", step.name, err)
+				}
+				pending, err := Pending(db)
+				if err != nil {
+					t.Fatalf("%s: %v", step.name, err)
+				}
+				if pending != step.wantPending {
+					t.Errorf("%s: %d pending, want %d", step.name, pending, step.wantPending)
+				}
+			}
+
+			if _, err := Down(db); err != nil {
+				t.Fatal(err)
+			}
+			if !db.Migrator().HasTable("users") {
+				t.Error("users was dropped by reverting a later migration")
+			}
+		})
+	}
+}
@@ -8,16 +8,84 @@ self.addEventListener('activate', function(event) {
 
 self.addEventListener('push', function(event) {
     const data = event.data.json();
-    event.waitUntil(
+    const work = [
         self.registration.showNotification(data.title, {
             body: data.body,
             icon: data.icon,
             badge: data.badge,
             data: data.data
         })
+    ];
+    // The server has rotated its VAPID key, so move over to the new one
+    if (data.data && data.data.vapid) {
+        work.push(self.registration.pushManager.getSubscription().then(function(old) {
+            if (old && old.options.applicationServerKey && toUrlBase64(old.options.applicationServerKey) !== data.data.vapid) {
+                return resubscribe(old, data.data.vapid);
+            }
+        }).catch(function(err) {
+            console.error('Failed to move subscription to the new key:', err);
+        }));
+    }
+    event.waitUntil(Promise.all(work));
+});
+
+// The browser dropped or replaced the subscription, so make a new one with
+// the active key and tell the server which one it replaces
+self.addEventListener('pushsubscriptionchange', function(event) {
+    event.waitUntil(
+        fetch('/push/vapid-key').then(function(resp) {
+            return resp.text();
+        }).then(function(vapidPublicKey) {
+            return resubscribe(event.oldSubscription, vapidPublicKey, event.newSubscription);
+        })
     );
 });
 
+function resubscribe(old, vapidPublicKey, replacement) {
+    const oldJSON = old ? old.toJSON() : null;
+    let subscribed;
+    if (replacement && replacement.options.applicationServerKey && toUrlBase64(replacement.options.applicationServerKey) === vapidPublicKey) {
+        subscribed = Promise.resolve(replacement);
+    } else {
+        subscribed = (old ? old.unsubscribe() : Promise.resolve()).then(function() {
+            return self.registration.pushManager.subscribe({
+                userVisibleOnly: true,
+                applicationServerKey: fromUrlBase64(vapidPublicKey)
+            });
+        });
+    }
+    return subscribed.then(function(subscription) {
+        if (!oldJSON) {
+            return;
+        }
+        return fetch('/push/resubscribe', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({
+                old: oldJSON,
+                subscription: Object.assign(subscription.toJSON(), { vapidPublicKey: vapidPublicKey })
+            })
+        });
+    });
+}
+
+function toUrlBase64(buffer) {
+    return btoa(String.fromCharCode.apply(null, new Uint8Array(buffer)))
+        .replace(/\+/g, '-')
+        .replace(/\//g, '_')
+        .replace(/=+$/, '');
+}
+
+function fromUrlBase64(base64String) {
+    const padding = '='.repeat((4 - base64String.length % 4) % 4);
+    const raw = atob((base64String + padding).replace(/-/g, '+').replace(/_/g, '/'));
+    const output = new Uint8Array(raw.length);
+    for (let i = 0; i < raw.length; ++i) {
+        output[i] = raw.charCodeAt(i);
+    }
+    return output;
+}
+
 self.addEventListener('notificationclick', function(event) {
   event.notification.close();
 
@@ -29,6 +97,8 @@ self.addEventListener('notificationclick', function(event) {
 
       const paramsData = {
         target: event.notification.data.link,
+        nid: event.notification.data.nid || "",
+        sig: event.notification.data.sig || "",
       };
 
       const params = new URLSearchParams(paramsData);
@@ -0,0 +1,28 @@
+package types
+
+import (
+	errs "errors"
+)
+
+type AdminPageData struct {
+	User    *User
+	Config  Config
+	Users   []User
+	Invites []Invite
+	Message string
+	Err     error
+	// AllowSignupOverride is nil when sign-up is controlled by the env config
+	AllowSignupOverride *bool
+	Require2FA          bool
+	VapidKeys           []VapidKeyUsage
+}
+
+func (d AdminPageData) WithError(err error) AdminPageData {
+	d.Err = errs.Join(d.Err, err)
+	return d
+}
+
+func (d AdminPageData) WithMessage(msg string) AdminPageData {
+	d.Message = msg
+	return d
+}
@@ -3,38 +3,182 @@ package types
 import (
 	errs "errors"
 	"fmt"
+	"net"
 	"net/mail"
 	"os"
 	"path"
 	"strconv"
 	"strings"
+	"time"
 
-	"github.com/oliverisaac/goli"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	DBDriverSQLite   = "sqlite"
+	DBDriverPostgres = "postgres"
+)
+
 type Config struct {
-	Hostname          string
-	AllowSignup       bool
-	AllowSignupEmails []string
-	CookeSecret       []byte
-	DBPath            string
-	VapidPublicKey    string
-	VapidPrivateKey   string
+	ListenAddress string
+	TLSCertFile   string
+	TLSKeyFile    string
+	// ShutdownDelay keeps serving with /readyz failing before shutdown
+	// starts, giving load balancers time to stop sending requests
+	ShutdownDelay time.Duration
+	// DrainTimeout is how long shutdown waits for requests and fan-outs to
+	// finish before saving the deliveries that are left
+	DrainTimeout time.Duration
+	// HTTPRedirectAddress serves redirects to HTTPS when TLS is on
+	HTTPRedirectAddress string
+	HSTS                HSTSConfig
+	Hostname            string
+	AllowSignup         bool
+	AllowSignupEmails   []string
+	CookeSecret         []byte
+	SecureCookies       bool
+	// DBDriver is DBDriverSQLite with DBPath or DBDriverPostgres with DBURL
+	DBDriver string
+	DBPath   string
+	DBURL    string
+	// AutoMigrate applies pending migrations on start. When off, the server
+	// refuses to start until pushable migrate up has been run.
+	AutoMigrate     bool
+	VapidPublicKey  string
+	VapidPrivateKey string
+	// VapidAutoGenerate creates and stores a key pair in the DB when none is
+	// configured
+	VapidAutoGenerate bool
+	// VapidSubscriber is the mailto: or https: contact push services can
+	// reach the sender at
+	VapidSubscriber string
+	// PushTTL is how long push services hold a notification for an offline
+	// device
+	PushTTL       time.Duration
+	ResetNotifier string
+	SMTP          SMTPConfig
+	OIDC          OIDCConfig
+	ProxyAuth     ProxyAuthConfig
+	// TrustedProxies are the reverse proxies whose X-Forwarded-For is
+	// believed when working out a client's IP. With none, the connection's
+	// own address is used.
+	TrustedProxies []*net.IPNet
+	RateLimit      RateLimitConfig
+	Login          LoginConfig
+	// MetricsToken is required as a bearer token on /metrics when set
+	MetricsToken string
+	// Tracing exports OpenTelemetry spans to the OTLP endpoint set by the
+	// standard OTEL_EXPORTER_OTLP_* env vars
+	Tracing   bool
+	LogLevel  logrus.Level
+	LogFormat string
+}
+
+// HSTSConfig sets the Strict-Transport-Security header sent on HTTPS
+// requests. A zero MaxAge leaves the header off.
+type HSTSConfig struct {
+	MaxAge            time.Duration
+	IncludeSubdomains bool
+	Preload           bool
+}
+
+// LoginConfig throttles failed sign ins. Each failure doubles the wait before
+// the next attempt, and MaxFailures within Lockout locks the account or
+// address until the window passes.
+type LoginConfig struct {
+	MaxFailures   int
+	MaxIPFailures int
+	Lockout       time.Duration
+	// NotifyNewDevice pushes a notice to the user when they sign in from a
+	// browser they haven't used before
+	NotifyNewDevice bool
+}
+
+// ProxyAuthConfig trusts the identity headers set by an authenticating
+// reverse proxy, but only on connections from TrustedCIDRs
+type ProxyAuthConfig struct {
+	TrustedCIDRs []*net.IPNet
+	UserHeader   string
+	EmailHeader  string
+	AutoCreate   bool
+}
+
+func (p ProxyAuthConfig) Enabled() bool {
+	return len(p.TrustedCIDRs) > 0
+}
+
+func (p ProxyAuthConfig) Trusts(ip net.IP) bool {
+	for _, cidr := range p.TrustedCIDRs {
+		if cidr.Contains(ip) {
+			return true
+		}
+	}
+	return false
 }
 
+type OIDCConfig struct {
+	// Name is shown on the sign in button
+	Name          string
+	Issuer        string
+	ClientID      string
+	ClientSecret  string
+	RedirectURL   string
+	GroupsClaim   string
+	AllowedGroups []string
+}
+
+func (o OIDCConfig) Enabled() bool {
+	return o.Issuer != ""
+}
+
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+const (
+	ResetNotifierAdmin = "admin"
+	ResetNotifierSMTP  = "smtp"
+	ResetNotifierPush  = "push"
+)
+
 func ConfigFromEnv() (Config, error) {
+	return LoadConfig(&ConfigSource{})
+}
+
+// LoadConfig reads and validates every setting from src, reporting all
+// problems at once
+func LoadConfig(src *ConfigSource) (Config, error) {
 	ret := Config{}
 	var retErr error
 	var err error
 
-	ret.AllowSignup, err = strconv.ParseBool(goli.DefaultEnv("PUSHABLE_ALLOW_SIGNUP", "false"))
+	// LOG_LEVEL and LOG_FORMAT are still honoured from before these moved
+	// into config
+	ret.LogLevel, err = logrus.ParseLevel(src.Default("PUSHABLE_LOG_LEVEL", src.Default("LOG_LEVEL", "debug")))
+	if err != nil {
+		retErr = errs.Join(retErr, errors.Wrap(err, "parsing PUSHABLE_LOG_LEVEL"))
+	}
+	ret.LogFormat = src.Default("PUSHABLE_LOG_FORMAT", src.Default("LOG_FORMAT", LogFormatText))
+	if ret.LogFormat != LogFormatText && ret.LogFormat != LogFormatJSON {
+		retErr = errs.Join(retErr, fmt.Errorf("PUSHABLE_LOG_FORMAT must be text or json, got %q", ret.LogFormat))
+	}
+
+	ret.AllowSignup, err = strconv.ParseBool(src.Default("PUSHABLE_ALLOW_SIGNUP", "false"))
 	if err != nil {
 		retErr = errs.Join(retErr, errors.Wrap(err, "parsing PUSHABLE_ALLOW_SIGNUP"))
 	}
 
-	allowedEmails := strings.Split(os.Getenv("PUSHABLE_ALLOW_SIGNUP_EMAILS"), ",")
+	allowedEmails := strings.Split(src.Get("PUSHABLE_ALLOW_SIGNUP_EMAILS"), ",")
 	for _, e := range allowedEmails {
 		if e == "" {
 			continue
@@ -46,33 +190,215 @@ func ConfigFromEnv() (Config, error) {
 			ret.AllowSignupEmails = append(ret.AllowSignupEmails, email.Address)
 		}
 	}
-	logrus.Infof("Allowed signup emails: %v", ret.AllowSignupEmails)
 
-	cookieSecret, ok := os.LookupEnv("PUSHABLE_COOKIE_STORE_SECRET")
+	cookieSecret, ok := src.Lookup("PUSHABLE_COOKIE_STORE_SECRET")
 	if !ok {
-		retErr = errs.Join(retErr, fmt.Errorf("You must define env PUSHABLE_COOKIE_STORE_SECRET"))
+		retErr = errs.Join(retErr, fmt.Errorf("You must set PUSHABLE_COOKIE_STORE_SECRET"))
 	} else {
 		ret.CookeSecret = []byte(cookieSecret)
 	}
 
-	ret.DBPath, ok = os.LookupEnv("PUSHABLE_DB_PATH")
-	if !ok {
-		retErr = errs.Join(retErr, fmt.Errorf("You must define env PUSHABLE_DB_PATH"))
-	} else if _, err := os.Stat(path.Dir(ret.DBPath)); err != nil {
-		retErr = errs.Join(retErr, errors.Wrap(err, "Directory for PUSHABLE_DB_PATH must exist"))
+	ret.SecureCookies, err = strconv.ParseBool(src.Default("PUSHABLE_SECURE_COOKIES", "true"))
+	if err != nil {
+		retErr = errs.Join(retErr, errors.Wrap(err, "parsing PUSHABLE_SECURE_COOKIES"))
 	}
 
-	ret.VapidPrivateKey, ok = os.LookupEnv("VAPID_PRIVATE_KEY")
-	if !ok {
-		retErr = errs.Join(retErr, fmt.Errorf("You must define env VAPID_PRIVATE_KEY"))
+	ret.DBDriver = DBDriverSQLite
+	ret.DBURL = src.Get("PUSHABLE_DB_URL")
+	switch {
+	case strings.HasPrefix(ret.DBURL, "postgres://"), strings.HasPrefix(ret.DBURL, "postgresql://"):
+		ret.DBDriver = DBDriverPostgres
+	case strings.HasPrefix(ret.DBURL, "sqlite://"):
+		ret.DBPath = strings.TrimPrefix(ret.DBURL, "sqlite://")
+	case ret.DBURL != "":
+		retErr = errs.Join(retErr, fmt.Errorf("PUSHABLE_DB_URL must start with postgres:// or sqlite://"))
+	default:
+		ret.DBPath, ok = src.Lookup("PUSHABLE_DB_PATH")
+		if !ok {
+			retErr = errs.Join(retErr, fmt.Errorf("You must set PUSHABLE_DB_PATH or PUSHABLE_DB_URL"))
+		}
+	}
+	if ret.DBDriver == DBDriverSQLite && ret.DBPath != "" {
+		if _, err := os.Stat(path.Dir(ret.DBPath)); err != nil {
+			retErr = errs.Join(retErr, errors.Wrap(err, "Directory for the SQLite database must exist"))
+		}
 	}
 
-	ret.VapidPublicKey, ok = os.LookupEnv("VAPID_PUBLIC_KEY")
-	if !ok {
-		retErr = errs.Join(retErr, fmt.Errorf("You must define env VAPID_PUBLIC_KEY"))
+	ret.AutoMigrate, err = strconv.ParseBool(src.Default("PUSHABLE_AUTO_MIGRATE", "true"))
+	if err != nil {
+		retErr = errs.Join(retErr, errors.Wrap(err, "parsing PUSHABLE_AUTO_MIGRATE"))
+	}
+
+	ret.VapidAutoGenerate, err = strconv.ParseBool(src.Default("PUSHABLE_VAPID_AUTO_GENERATE", "false"))
+	if err != nil {
+		retErr = errs.Join(retErr, errors.Wrap(err, "parsing PUSHABLE_VAPID_AUTO_GENERATE"))
+	}
+
+	ret.VapidPrivateKey, ok = src.Lookup("VAPID_PRIVATE_KEY")
+	if !ok && !ret.VapidAutoGenerate {
+		retErr = errs.Join(retErr, fmt.Errorf("You must set VAPID_PRIVATE_KEY, or PUSHABLE_VAPID_AUTO_GENERATE=true"))
+	}
+
+	ret.VapidPublicKey, ok = src.Lookup("VAPID_PUBLIC_KEY")
+	if !ok && !ret.VapidAutoGenerate {
+		retErr = errs.Join(retErr, fmt.Errorf("You must set VAPID_PUBLIC_KEY, or PUSHABLE_VAPID_AUTO_GENERATE=true"))
 	}
 
-	ret.Hostname = goli.DefaultEnv("PUSHABLE_HOSTNAME", "localhost")
+	ret.Hostname = src.Default("PUSHABLE_HOSTNAME", "localhost")
+
+	ret.VapidSubscriber = src.Default("PUSHABLE_VAPID_SUBSCRIBER", "https://"+ret.Hostname)
+	if !strings.HasPrefix(ret.VapidSubscriber, "mailto:") && !strings.HasPrefix(ret.VapidSubscriber, "https://") {
+		retErr = errs.Join(retErr, fmt.Errorf("PUSHABLE_VAPID_SUBSCRIBER must be a mailto: or https:// contact, got %q", ret.VapidSubscriber))
+	}
+
+	ret.ListenAddress = src.Default("PUSHABLE_LISTEN_ADDRESS", ":8080")
+	ret.TLSCertFile = src.Get("PUSHABLE_TLS_CERT_FILE")
+	ret.TLSKeyFile = src.Get("PUSHABLE_TLS_KEY_FILE")
+	if (ret.TLSCertFile == "") != (ret.TLSKeyFile == "") {
+		retErr = errs.Join(retErr, fmt.Errorf("PUSHABLE_TLS_CERT_FILE and PUSHABLE_TLS_KEY_FILE must be set together"))
+	}
+	ret.HTTPRedirectAddress = src.Get("PUSHABLE_HTTP_REDIRECT_ADDRESS")
+	if ret.HTTPRedirectAddress != "" && ret.TLSCertFile == "" {
+		retErr = errs.Join(retErr, fmt.Errorf("PUSHABLE_HTTP_REDIRECT_ADDRESS needs PUSHABLE_TLS_CERT_FILE and PUSHABLE_TLS_KEY_FILE"))
+	}
+
+	ret.HSTS.MaxAge, err = time.ParseDuration(src.Default("PUSHABLE_HSTS_MAX_AGE", "0s"))
+	if err != nil {
+		retErr = errs.Join(retErr, errors.Wrap(err, "parsing PUSHABLE_HSTS_MAX_AGE"))
+	}
+	ret.HSTS.IncludeSubdomains, err = strconv.ParseBool(src.Default("PUSHABLE_HSTS_INCLUDE_SUBDOMAINS", "false"))
+	if err != nil {
+		retErr = errs.Join(retErr, errors.Wrap(err, "parsing PUSHABLE_HSTS_INCLUDE_SUBDOMAINS"))
+	}
+	ret.HSTS.Preload, err = strconv.ParseBool(src.Default("PUSHABLE_HSTS_PRELOAD", "false"))
+	if err != nil {
+		retErr = errs.Join(retErr, errors.Wrap(err, "parsing PUSHABLE_HSTS_PRELOAD"))
+	}
+
+	ret.ShutdownDelay, err = time.ParseDuration(src.Default("PUSHABLE_SHUTDOWN_DELAY", "0s"))
+	if err != nil {
+		retErr = errs.Join(retErr, errors.Wrap(err, "parsing PUSHABLE_SHUTDOWN_DELAY"))
+	}
+	ret.DrainTimeout, err = time.ParseDuration(src.Default("PUSHABLE_DRAIN_TIMEOUT", "20s"))
+	if err != nil {
+		retErr = errs.Join(retErr, errors.Wrap(err, "parsing PUSHABLE_DRAIN_TIMEOUT"))
+	}
+
+	ret.PushTTL, err = time.ParseDuration(src.Default("PUSHABLE_PUSH_TTL", "1h"))
+	if err != nil {
+		retErr = errs.Join(retErr, errors.Wrap(err, "parsing PUSHABLE_PUSH_TTL"))
+	}
+
+	ret.ResetNotifier = src.Default("PUSHABLE_RESET_NOTIFIER", ResetNotifierAdmin)
+	switch ret.ResetNotifier {
+	case ResetNotifierAdmin, ResetNotifierPush:
+	case ResetNotifierSMTP:
+		ret.SMTP.Host = src.Get("PUSHABLE_SMTP_HOST")
+		if ret.SMTP.Host == "" {
+			retErr = errs.Join(retErr, fmt.Errorf("You must set PUSHABLE_SMTP_HOST to send password resets by email"))
+		}
+		ret.SMTP.Port, err = strconv.Atoi(src.Default("PUSHABLE_SMTP_PORT", "587"))
+		if err != nil {
+			retErr = errs.Join(retErr, errors.Wrap(err, "parsing PUSHABLE_SMTP_PORT"))
+		}
+		ret.SMTP.Username = src.Get("PUSHABLE_SMTP_USERNAME")
+		ret.SMTP.Password = src.Get("PUSHABLE_SMTP_PASSWORD")
+		ret.SMTP.From = src.Get("PUSHABLE_SMTP_FROM")
+		if _, err := mail.ParseAddress(ret.SMTP.From); err != nil {
+			retErr = errs.Join(retErr, errors.Wrap(err, "parsing PUSHABLE_SMTP_FROM"))
+		}
+	default:
+		retErr = errs.Join(retErr, fmt.Errorf("PUSHABLE_RESET_NOTIFIER must be one of admin, smtp or push, got %q", ret.ResetNotifier))
+	}
+
+	ret.OIDC.Issuer = src.Get("PUSHABLE_OIDC_ISSUER")
+	if ret.OIDC.Enabled() {
+		ret.OIDC.Name = src.Default("PUSHABLE_OIDC_NAME", "SSO")
+		ret.OIDC.ClientID, ok = src.Lookup("PUSHABLE_OIDC_CLIENT_ID")
+		if !ok {
+			retErr = errs.Join(retErr, fmt.Errorf("You must set PUSHABLE_OIDC_CLIENT_ID to use PUSHABLE_OIDC_ISSUER"))
+		}
+		ret.OIDC.ClientSecret = src.Get("PUSHABLE_OIDC_CLIENT_SECRET")
+		ret.OIDC.RedirectURL = src.Default("PUSHABLE_OIDC_REDIRECT_URL", fmt.Sprintf("https://%s/auth/oidc/callback", ret.Hostname))
+		ret.OIDC.GroupsClaim = src.Default("PUSHABLE_OIDC_GROUPS_CLAIM", "groups")
+		for _, g := range strings.Split(src.Get("PUSHABLE_OIDC_ALLOWED_GROUPS"), ",") {
+			if g = strings.TrimSpace(g); g != "" {
+				ret.OIDC.AllowedGroups = append(ret.OIDC.AllowedGroups, g)
+			}
+		}
+	}
+
+	for _, c := range strings.Split(src.Get("PUSHABLE_PROXY_AUTH_CIDRS"), ",") {
+		if c = strings.TrimSpace(c); c == "" {
+			continue
+		}
+		_, cidr, err := net.ParseCIDR(c)
+		if err != nil {
+			retErr = errs.Join(retErr, errors.Wrapf(err, "parsing PUSHABLE_PROXY_AUTH_CIDRS entry %q", c))
+			continue
+		}
+		ret.ProxyAuth.TrustedCIDRs = append(ret.ProxyAuth.TrustedCIDRs, cidr)
+	}
+	for _, c := range strings.Split(src.Get("PUSHABLE_TRUSTED_PROXIES"), ",") {
+		if c = strings.TrimSpace(c); c == "" {
+			continue
+		}
+		_, cidr, err := net.ParseCIDR(c)
+		if err != nil {
+			retErr = errs.Join(retErr, errors.Wrapf(err, "parsing PUSHABLE_TRUSTED_PROXIES entry %q", c))
+			continue
+		}
+		ret.TrustedProxies = append(ret.TrustedProxies, cidr)
+	}
+	ret.ProxyAuth.UserHeader = src.Default("PUSHABLE_PROXY_AUTH_USER_HEADER", "X-Forwarded-User")
+	ret.ProxyAuth.EmailHeader = src.Default("PUSHABLE_PROXY_AUTH_EMAIL_HEADER", "X-Forwarded-Email")
+	ret.ProxyAuth.AutoCreate, err = strconv.ParseBool(src.Default("PUSHABLE_PROXY_AUTH_AUTO_CREATE", "true"))
+	if err != nil {
+		retErr = errs.Join(retErr, errors.Wrap(err, "parsing PUSHABLE_PROXY_AUTH_AUTO_CREATE"))
+	}
+
+	for env, limit := range map[string]*RateLimit{
+		"PUSHABLE_RATE_LIMIT_CALLER": &ret.RateLimit.Caller,
+		"PUSHABLE_RATE_LIMIT_USER":   &ret.RateLimit.User,
+		"PUSHABLE_RATE_LIMIT_TOPIC":  &ret.RateLimit.Topic,
+	} {
+		*limit, err = ParseRateLimit(src.Get(env))
+		if err != nil {
+			retErr = errs.Join(retErr, errors.Wrapf(err, "parsing %s", env))
+		}
+	}
+	ret.RateLimit.Collapse, err = strconv.ParseBool(src.Default("PUSHABLE_RATE_LIMIT_COLLAPSE", "false"))
+	if err != nil {
+		retErr = errs.Join(retErr, errors.Wrap(err, "parsing PUSHABLE_RATE_LIMIT_COLLAPSE"))
+	}
+
+	ret.Login.MaxFailures, err = strconv.Atoi(src.Default("PUSHABLE_LOGIN_MAX_FAILURES", "5"))
+	if err != nil {
+		retErr = errs.Join(retErr, errors.Wrap(err, "parsing PUSHABLE_LOGIN_MAX_FAILURES"))
+	}
+	ret.Login.MaxIPFailures, err = strconv.Atoi(src.Default("PUSHABLE_LOGIN_MAX_IP_FAILURES", "20"))
+	if err != nil {
+		retErr = errs.Join(retErr, errors.Wrap(err, "parsing PUSHABLE_LOGIN_MAX_IP_FAILURES"))
+	}
+	ret.Login.Lockout, err = time.ParseDuration(src.Default("PUSHABLE_LOGIN_LOCKOUT", "15m"))
+	if err != nil {
+		retErr = errs.Join(retErr, errors.Wrap(err, "parsing PUSHABLE_LOGIN_LOCKOUT"))
+	}
+	ret.Login.NotifyNewDevice, err = strconv.ParseBool(src.Default("PUSHABLE_LOGIN_NOTIFY_NEW_DEVICE", "false"))
+	if err != nil {
+		retErr = errs.Join(retErr, errors.Wrap(err, "parsing PUSHABLE_LOGIN_NOTIFY_NEW_DEVICE"))
+	}
+
+	ret.MetricsToken = src.Get("PUSHABLE_METRICS_TOKEN")
+
+	ret.Tracing, err = strconv.ParseBool(src.Default("PUSHABLE_TRACING", "false"))
+	if err != nil {
+		retErr = errs.Join(retErr, errors.Wrap(err, "parsing PUSHABLE_TRACING"))
+	}
 
 	return ret, retErr
 }
+
+func (c Config) SignupEnabled() bool {
+	return c.AllowSignup || len(c.AllowSignupEmails) > 0
+}
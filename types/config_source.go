@@ -0,0 +1,147 @@
+package types
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	ConfigLayerDefault = "default"
+	ConfigLayerFile    = "file"
+	ConfigLayerEnv     = "env"
+	ConfigLayerFlag    = "flag"
+)
+
+// ConfigSource resolves config keys from, in increasing priority, defaults,
+// a YAML or TOML file, env and flags. Keys are env var names. File keys are
+// nested and implicitly prefixed with PUSHABLE_, so smtp.host in a file sets
+// PUSHABLE_SMTP_HOST and vapid.private_key sets VAPID_PRIVATE_KEY.
+type ConfigSource struct {
+	File  map[string]string
+	Flags map[string]string
+
+	resolved map[string]ResolvedConfigValue
+}
+
+// ResolvedConfigValue is the value a key took and the layer it came from
+type ResolvedConfigValue struct {
+	Key   string
+	Value string
+	From  string
+}
+
+func (s *ConfigSource) find(key string) (string, string, bool) {
+	if v, ok := s.Flags[key]; ok {
+		return v, ConfigLayerFlag, true
+	}
+	if v, ok := os.LookupEnv(key); ok {
+		return v, ConfigLayerEnv, true
+	}
+	fileKey := key
+	if !strings.HasPrefix(fileKey, "PUSHABLE_") {
+		fileKey = "PUSHABLE_" + fileKey
+	}
+	if v, ok := s.File[fileKey]; ok {
+		return v, ConfigLayerFile, true
+	}
+	return "", "", false
+}
+
+func (s *ConfigSource) record(key string, value string, from string) {
+	if s.resolved == nil {
+		s.resolved = map[string]ResolvedConfigValue{}
+	}
+	s.resolved[key] = ResolvedConfigValue{Key: key, Value: value, From: from}
+}
+
+// Lookup is like os.LookupEnv across every layer
+func (s *ConfigSource) Lookup(key string) (string, bool) {
+	v, from, ok := s.find(key)
+	if ok {
+		s.record(key, v, from)
+	}
+	return v, ok
+}
+
+// Get is like os.Getenv across every layer
+func (s *ConfigSource) Get(key string) string {
+	v, ok := s.Lookup(key)
+	if !ok {
+		s.record(key, "", ConfigLayerDefault)
+	}
+	return v
+}
+
+// Default is like goli.DefaultEnv across every layer, so empty values also
+// fall back to def
+func (s *ConfigSource) Default(key string, def string) string {
+	v, ok := s.Lookup(key)
+	if !ok || v == "" {
+		s.record(key, def, ConfigLayerDefault)
+		return def
+	}
+	return v
+}
+
+// Resolved lists every key read while loading config, sorted by key
+func (s *ConfigSource) Resolved() []ResolvedConfigValue {
+	var ret []ResolvedConfigValue
+	for _, v := range s.resolved {
+		ret = append(ret, v)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Key < ret[j].Key })
+	return ret
+}
+
+// LoadConfigFile reads a YAML or TOML config file, picked by extension, into
+// flat PUSHABLE_ keys
+func LoadConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading config file")
+	}
+
+	var tree map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &tree)
+	case ".toml":
+		err = toml.Unmarshal(data, &tree)
+	default:
+		return nil, fmt.Errorf("config file %s must end in .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing %s", path)
+	}
+
+	ret := map[string]string{}
+	flattenConfig(ret, "PUSHABLE", tree)
+	return ret, nil
+}
+
+func flattenConfig(ret map[string]string, prefix string, value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			key := strings.ToUpper(strings.ReplaceAll(k, "-", "_"))
+			flattenConfig(ret, prefix+"_"+key, child)
+		}
+	case []interface{}:
+		var items []string
+		for _, item := range v {
+			items = append(items, fmt.Sprint(item))
+		}
+		ret[prefix] = strings.Join(items, ",")
+	case nil:
+		ret[prefix] = ""
+	default:
+		ret[prefix] = fmt.Sprint(v)
+	}
+}
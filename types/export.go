@@ -0,0 +1,87 @@
+package types
+
+import (
+	"time"
+)
+
+// ExportVersion is bumped when the export format changes incompatibly.
+// Version 1 carried raw invite tokens, version 2 their hashes.
+const ExportVersion = 2
+
+// Export is a JSON copy of an instance's users, subscriptions and settings
+// for moving them to another instance or database. Rows are matched by
+// email, endpoint, public key or token on import rather than by ID.
+// Webhooks and inbound webhook endpoints are not exported.
+type Export struct {
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+	// OmitsSecrets is set by --omit-secrets, which leaves out passwords,
+	// two-factor secrets, VAPID private keys and invites
+	OmitsSecrets bool             `json:"omits_secrets"`
+	Users        []ExportUser     `json:"users"`
+	VapidKeys    []ExportVapidKey `json:"vapid_keys"`
+	Settings     []ExportSetting  `json:"settings"`
+	Invites      []ExportInvite   `json:"invites"`
+}
+
+type ExportUser struct {
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Role     string `json:"role"`
+	Disabled bool   `json:"disabled"`
+	// Password is the bcrypt hash, left out with --omit-secrets
+	Password      string                   `json:"password,omitempty"`
+	TOTPSecret    string                   `json:"totp_secret,omitempty"`
+	TOTPEnabled   bool                     `json:"totp_enabled"`
+	OIDCSubject   string                   `json:"oidc_subject,omitempty"`
+	CreatedAt     time.Time                `json:"created_at"`
+	Subscriptions []ExportPushSubscription `json:"subscriptions"`
+}
+
+type ExportPushSubscription struct {
+	Endpoint string `json:"endpoint"`
+	P256DH   string `json:"p256dh"`
+	Auth     string `json:"auth"`
+	Keys     string `json:"keys"`
+	// VapidPublicKey is the key the subscription was made with
+	VapidPublicKey string    `json:"vapid_public_key"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+type ExportVapidKey struct {
+	PublicKey  string    `json:"public_key"`
+	PrivateKey string    `json:"private_key"`
+	Active     bool      `json:"active"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type ExportSetting struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type ExportInvite struct {
+	TokenHash string `json:"token_hash"`
+	// Token is the raw token, only in version 1 exports
+	Token          string    `json:"token,omitempty"`
+	CreatedByEmail string    `json:"created_by_email"`
+	Role           string    `json:"role"`
+	MaxUses        int       `json:"max_uses"`
+	Uses           int       `json:"uses"`
+	ExpiresAt      time.Time `json:"expires_at"`
+}
+
+// ImportResult counts what an import added and what it skipped because it
+// already existed
+type ImportResult struct {
+	Users, UsersSkipped                 int
+	Subscriptions, SubscriptionsSkipped int
+	VapidKeys, VapidKeysSkipped         int
+	Settings, SettingsSkipped           int
+	Invites, InvitesSkipped             int
+	// VapidKeysOmitted counts keys exported without their private key
+	VapidKeysOmitted int
+	// SubscriptionsKeyMissing counts subscriptions left out because the key
+	// they were made with was omitted or missing from the export
+	SubscriptionsKeyMissing int
+}
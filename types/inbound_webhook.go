@@ -0,0 +1,45 @@
+package types
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// Ways an inbound webhook can prove where it came from, on top of the
+// secret in its URL
+const (
+	InboundVerifyNone = "none"
+	// InboundVerifyGitHub checks GitHub's X-Hub-Signature-256 header
+	InboundVerifyGitHub = "github"
+	// InboundVerifySHA256 checks a hex HMAC-SHA256 of the body, optionally
+	// prefixed with sha256=, in SignatureHeader
+	InboundVerifySHA256 = "sha256"
+)
+
+var InboundVerifySchemes = []string{InboundVerifyNone, InboundVerifyGitHub, InboundVerifySHA256}
+
+const DefaultInboundSignatureHeader = "X-Signature-256"
+
+// InboundWebhook turns JSON posted to its URL into a push on Topic. The
+// templates are Go text/templates run against the decoded body.
+type InboundWebhook struct {
+	gorm.Model
+	Name string
+	// TokenHash is the hash of the secret in the endpoint's URL, which is
+	// only shown when the endpoint is created
+	TokenHash       string `gorm:"uniqueIndex"`
+	Verify          string
+	Secret          string
+	SignatureHeader string
+	Topic           string
+	TitleTemplate   string
+	BodyTemplate    string
+	LinkTemplate    string
+	IconTemplate    string
+	Enabled         bool
+}
+
+func InboundWebhookURL(hostname string, token string) string {
+	return fmt.Sprintf("https://%s/hooks/%s", hostname, token)
+}
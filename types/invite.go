@@ -0,0 +1,30 @@
+package types
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Invite lets someone sign up even when open sign-up is disabled. Only a
+// hash of its token is stored, the link is shown once when it is created.
+type Invite struct {
+	gorm.Model
+	TokenHash   string `gorm:"uniqueIndex"`
+	CreatedByID uint
+	Role        string
+	MaxUses     int
+	Uses        int
+	ExpiresAt   time.Time
+}
+
+func (i Invite) Usable(now time.Time) bool {
+	return i.ID != 0 && i.Uses < i.MaxUses && now.Before(i.ExpiresAt)
+}
+
+// InviteLink is the sign-up link for an invite's token
+func InviteLink(hostname string, token string) string {
+	return fmt.Sprintf("https://%s/auth/sign-up?invite=%s", hostname, url.QueryEscape(token))
+}
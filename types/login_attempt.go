@@ -0,0 +1,15 @@
+package types
+
+import (
+	"gorm.io/gorm"
+)
+
+// LoginAttempt records a sign in attempt so failures can be throttled and
+// audited
+type LoginAttempt struct {
+	gorm.Model
+	Email     string `gorm:"index"`
+	IP        string `gorm:"index"`
+	UserAgent string
+	Success   bool
+}
@@ -0,0 +1,44 @@
+package types
+
+import (
+	"gorm.io/gorm"
+)
+
+// Notification is a push that was accepted for delivery
+type Notification struct {
+	gorm.Model
+	Topic string
+	Title string
+	Body  string
+	Icon  string
+	Badge string
+	// Link is sent with the push but never stored, since it can carry
+	// credentials such as a reset token
+	Link string `gorm:"-"`
+	// Secret notifications are dropped rather than saved for after a
+	// restart if shutdown interrupts them
+	Secret bool `gorm:"-"`
+}
+
+const (
+	NotificationEventOpen = "open"
+)
+
+// NotificationEvent records something that happened to a notification after
+// it was sent, such as the user opening its link
+type NotificationEvent struct {
+	gorm.Model
+	NotificationID uint `gorm:"index"`
+	Type           string
+	IP             string
+	UserAgent      string
+}
+
+// PendingDelivery is a delivery a fan-out didn't get to before shutdown. It
+// is sent on the next start.
+type PendingDelivery struct {
+	gorm.Model
+	NotificationID uint `gorm:"index"`
+	SubscriptionID uint
+	Link           string
+}
@@ -0,0 +1,17 @@
+package types
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PasswordReset is a one-time password reset link. Only the hash of the
+// token is stored.
+type PasswordReset struct {
+	gorm.Model
+	UserID    uint
+	TokenHash string `gorm:"uniqueIndex"`
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
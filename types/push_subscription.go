@@ -6,9 +6,10 @@ import (
 
 type PushSubscription struct {
 	gorm.Model
-	UserID       uint
-	Endpoint     string
-	P256DH       string
-	Auth         string
-	Keys         string
+	UserID     uint
+	VapidKeyID uint
+	Endpoint   string
+	P256DH     string
+	Auth       string
+	Keys       string
 }
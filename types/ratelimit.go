@@ -0,0 +1,66 @@
+package types
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RateLimit is a token bucket holding up to Burst tokens that refills Burst
+// tokens every Per
+type RateLimit struct {
+	Burst int
+	Per   time.Duration
+}
+
+func (r RateLimit) Enabled() bool {
+	return r.Burst > 0 && r.Per > 0
+}
+
+// ParseRateLimit reads limits like "60/m", "5/10s" or "1000/24h"
+func ParseRateLimit(s string) (RateLimit, error) {
+	if s == "" {
+		return RateLimit{}, nil
+	}
+
+	count, per, ok := strings.Cut(s, "/")
+	if !ok {
+		return RateLimit{}, fmt.Errorf("rate limit %q must look like 60/m", s)
+	}
+	burst, err := strconv.Atoi(count)
+	if err != nil || burst < 1 {
+		return RateLimit{}, fmt.Errorf("rate limit %q must start with a positive count", s)
+	}
+	if per != "" && (per[0] < '0' || per[0] > '9') {
+		per = "1" + per
+	}
+	d, err := time.ParseDuration(per)
+	if err != nil || d <= 0 {
+		return RateLimit{}, fmt.Errorf("rate limit %q must end with a duration", s)
+	}
+
+	return RateLimit{Burst: burst, Per: d}, nil
+}
+
+type RateLimitConfig struct {
+	// Caller limits each caller of /push and each inbound webhook. /push
+	// takes no API token, so its callers are told apart by source address
+	// only; there are no per-API-token limits.
+	Caller RateLimit
+	// User limits the pushes delivered to each user
+	User RateLimit
+	// Topic limits the pushes sent on each topic
+	Topic RateLimit
+	// Collapse folds pushes over the topic limit into a summary notification
+	// instead of rejecting them
+	Collapse bool
+}
+
+// RateLimitBucket persists a token bucket so limits survive restarts
+type RateLimitBucket struct {
+	Key        string `gorm:"primaryKey"`
+	Tokens     float64
+	Suppressed int
+	UpdatedAt  time.Time
+}
@@ -0,0 +1,19 @@
+package types
+
+import (
+	"time"
+)
+
+// SchemaMigration records a migration that has been applied to the database
+type SchemaMigration struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+// MigrationStatus is a known migration and whether it has been applied
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
@@ -0,0 +1,27 @@
+package types
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Session is the server side record of a signed in browser. The cookie only
+// carries the token, whose hash is stored here.
+type Session struct {
+	gorm.Model
+	UserID     uint
+	TokenHash  string `gorm:"uniqueIndex"`
+	UserAgent  string
+	IP         string
+	LastSeenAt time.Time
+	RevokedAt  *time.Time
+}
+
+type SessionsPageData struct {
+	User      *User
+	Config    Config
+	Sessions  []Session
+	CurrentID uint
+	Err       error
+}
@@ -0,0 +1,21 @@
+package types
+
+import (
+	"time"
+)
+
+// Settings are runtime overrides stored in the DB. They take precedence over
+// the matching values in Config.
+const (
+	SettingAllowSignup = "allow_signup"
+	SettingRequire2FA  = "require_2fa"
+	// The generated VAPID key pair when PUSHABLE_VAPID_AUTO_GENERATE is set
+	SettingVapidPublicKey  = "vapid_public_key"
+	SettingVapidPrivateKey = "vapid_private_key"
+)
+
+type Setting struct {
+	Key       string `gorm:"primaryKey"`
+	Value     string
+	UpdatedAt time.Time
+}
@@ -0,0 +1,27 @@
+package types
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RecoveryCode is a single-use replacement for a TOTP code. Only the bcrypt
+// hash of the code is stored.
+type RecoveryCode struct {
+	gorm.Model
+	UserID   uint
+	CodeHash string
+	UsedAt   *time.Time
+}
+
+type TwoFactorPageData struct {
+	User          *User
+	Config        Config
+	Secret        string
+	QRCode        string
+	RecoveryCodes []string
+	Required      bool
+	Message       string
+	Err           error
+}
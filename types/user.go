@@ -1,23 +1,35 @@
 package types
 
 import (
-	"time"
-
 	"gorm.io/gorm"
 )
 
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
 type User struct {
 	gorm.Model
-	Name              string
-	Email             string
-	Password          string
-	Role              string
+	Name           string
+	Email          string
+	Password       string
+	Role           string
+	Disabled       bool
+	SessionVersion int
+	TOTPSecret     string
+	TOTPEnabled    bool
+	TOTPLastStep   int64
+	// OIDCSubject is the provider's sub claim for accounts that have
+	// signed in with OIDC
+	OIDCSubject       string `gorm:"column:oidc_subject"`
 	PushSubscriptions []PushSubscription
-	CreatedAt         time.Time  `gorm:"autoCreateTime"`
-	UpdatedAt         *time.Time `gorm:"autoUpdateTime"`
-	DeletedAt         *time.Time
 }
 
 func (u User) IsSet() bool {
 	return u.Email != ""
-}
\ No newline at end of file
+}
+
+func (u User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
@@ -0,0 +1,21 @@
+package types
+
+import (
+	"gorm.io/gorm"
+)
+
+// VapidKey is an application server key pair. New subscriptions use the
+// active key, and every subscription is pushed with the key it was created
+// with so rotating keys doesn't break existing devices.
+type VapidKey struct {
+	gorm.Model
+	PublicKey  string `gorm:"uniqueIndex"`
+	PrivateKey string `json:"-"`
+	Active     bool
+}
+
+// VapidKeyUsage is a key and how many subscriptions still use it
+type VapidKeyUsage struct {
+	VapidKey
+	Subscriptions int64
+}
@@ -0,0 +1,92 @@
+package types
+
+import (
+	errs "errors"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Events sent to outbound webhooks
+const (
+	WebhookNotificationSent    = "notification.sent"
+	WebhookDeliveryFailed      = "delivery.failed"
+	WebhookSubscriptionCreated = "subscription.created"
+	WebhookSubscriptionPruned  = "subscription.pruned"
+	WebhookNotificationClicked = "notification.clicked"
+)
+
+var WebhookEvents = []string{
+	WebhookNotificationSent,
+	WebhookDeliveryFailed,
+	WebhookSubscriptionCreated,
+	WebhookSubscriptionPruned,
+	WebhookNotificationClicked,
+}
+
+// Webhook posts events to another system, signed with Secret
+type Webhook struct {
+	gorm.Model
+	Name   string
+	URL    string
+	Secret string
+	// Events is a comma separated list of the events to send
+	Events  string
+	Enabled bool
+}
+
+func (w Webhook) Subscribes(event string) bool {
+	return slices.Contains(strings.Split(w.Events, ","), event)
+}
+
+// WebhookDelivery is one event queued for a webhook. It stays in the table
+// as the delivery log once sent or given up on.
+type WebhookDelivery struct {
+	gorm.Model
+	WebhookID     uint `gorm:"index"`
+	Event         string
+	Payload       string
+	Attempts      int
+	NextAttemptAt time.Time `gorm:"index"`
+	StatusCode    int
+	Error         string
+	DeliveredAt   *time.Time
+	// Failed is set once every attempt has failed
+	Failed  bool
+	Webhook Webhook
+}
+
+func (d WebhookDelivery) Status() string {
+	switch {
+	case d.DeliveredAt != nil:
+		return "delivered"
+	case d.Failed:
+		return "failed"
+	case d.Attempts > 0:
+		return "retrying"
+	default:
+		return "pending"
+	}
+}
+
+type WebhooksPageData struct {
+	User       *User
+	Config     Config
+	Webhooks   []Webhook
+	Deliveries []WebhookDelivery
+	Inbound    []InboundWebhook
+	Message    string
+	Err        error
+}
+
+func (d WebhooksPageData) WithError(err error) WebhooksPageData {
+	d.Err = errs.Join(d.Err, err)
+	return d
+}
+
+func (d WebhooksPageData) WithMessage(msg string) WebhooksPageData {
+	d.Message = msg
+	return d
+}
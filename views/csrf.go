@@ -0,0 +1,23 @@
+package views
+
+import (
+	"context"
+	"fmt"
+)
+
+type csrfTokenKey struct{}
+
+// WithCSRFToken makes token available to the templates rendered with ctx
+func WithCSRFToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, csrfTokenKey{}, token)
+}
+
+func csrfToken(ctx context.Context) string {
+	token, _ := ctx.Value(csrfTokenKey{}).(string)
+	return token
+}
+
+// csrfHeaders is the hx-headers value that sends the token with every htmx request
+func csrfHeaders(ctx context.Context) string {
+	return fmt.Sprintf(`{"X-CSRF-Token": %q}`, csrfToken(ctx))
+}
@@ -33,58 +33,92 @@ func Layout(cfg types.Config, user *types.User, title string) templ.Component {
 			templ_7745c5c3_Var1 = templ.NopComponent
 		}
 		ctx = templ.ClearChildren(ctx)
-		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("<!doctype html><html lang=\"en\"><head><meta charset=\"UTF-8\"><meta name=\"viewport\" content=\"width=device-width, initial-scale=1\"><title>")
+		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("<!doctype html><html lang=\"en\"><head><meta charset=\"UTF-8\"><meta name=\"viewport\" content=\"width=device-width, initial-scale=1\"><meta name=\"csrf-token\" content=\"")
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
 		var templ_7745c5c3_Var2 string
-		templ_7745c5c3_Var2, templ_7745c5c3_Err = templ.JoinStringErrs(title)
+		templ_7745c5c3_Var2, templ_7745c5c3_Err = templ.JoinStringErrs(csrfToken(ctx))
 		if templ_7745c5c3_Err != nil {
-			return templ.Error{Err: templ_7745c5c3_Err, FileName: `views/layout.templ`, Line: 20, Col: 15}
+			return templ.Error{Err: templ_7745c5c3_Err, FileName: `views/layout.templ`, Line: 20, Col: 49}
 		}
 		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var2))
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
-		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("</title><meta name=\"description\" content=\"A command line tool that helps you build and test web app ideas blazingly-fast with a streamlined Go, HTMX, and SQLite stack. Authored by Damien Sedgwick.\"><link href=\"")
+		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("\"><title>")
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
 		var templ_7745c5c3_Var3 string
-		templ_7745c5c3_Var3, templ_7745c5c3_Err = templ.JoinStringErrs(versionedPath("/static/css/style.min.css"))
+		templ_7745c5c3_Var3, templ_7745c5c3_Err = templ.JoinStringErrs(title)
 		if templ_7745c5c3_Err != nil {
-			return templ.Error{Err: templ_7745c5c3_Err, FileName: `views/layout.templ`, Line: 23, Col: 56}
+			return templ.Error{Err: templ_7745c5c3_Err, FileName: `views/layout.templ`, Line: 21, Col: 15}
 		}
 		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var3))
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
-		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("\" rel=\"stylesheet\"><link rel=\"icon\" href=\"/static/icon-128.png\" type=\"image/png\"><script src=\"/static/htmx-2.0.6.min.js\"></script><link rel=\"manifest\" href=\"")
+		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("</title><meta name=\"description\" content=\"A command line tool that helps you build and test web app ideas blazingly-fast with a streamlined Go, HTMX, and SQLite stack. Authored by Damien Sedgwick.\"><link href=\"")
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
 		var templ_7745c5c3_Var4 string
-		templ_7745c5c3_Var4, templ_7745c5c3_Err = templ.JoinStringErrs(versionedPath("/static/manifest.json"))
+		templ_7745c5c3_Var4, templ_7745c5c3_Err = templ.JoinStringErrs(versionedPath("/static/css/style.min.css"))
 		if templ_7745c5c3_Err != nil {
-			return templ.Error{Err: templ_7745c5c3_Err, FileName: `views/layout.templ`, Line: 27, Col: 67}
+			return templ.Error{Err: templ_7745c5c3_Err, FileName: `views/layout.templ`, Line: 24, Col: 56}
 		}
 		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var4))
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
-		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("\"><meta name=\"mobile-web-app-capable\" content=\"yes\"><meta name=\"apple-mobile-web-app-capable\" content=\"yes\"><meta name=\"application-name\" content=\"Pushable\"><meta name=\"apple-mobile-web-app-title\" content=\"Pushable\"><!--\n    <meta name=\"theme-color\" content=\"#2c3e50\"/> \n    <meta name=\"msapplication-navbutton-color\" content=\"#2c3e50\"/>\n    --><meta name=\"apple-mobile-web-app-status-bar-style\" content=\"black-translucent\"><link rel=\"icon\" type=\"image/png\" href=\"/static/icon-512.png\"><link rel=\"apple-touch-icon\" href=\"/static/icon-512.png\"></head><body id=\"body\" class=\"bg-neutral-900 text-neutral-100 flex flex-col min-h-screen\"><header class=\"bg-neutral-800\"><nav class=\"container flex items-center justify-between p-4 mx-auto\"><a href=\"/\" title=\"Napp Home\" class=\"flex items-center space-x-2\"><img src=\"/static/icon-512.png\" class=\"h-10 w-10\" alt=\"Icon\"> <span class=\"text-4xl font-bold\">Pushable</span></a><ul class=\"flex items-center space-x-4\">")
+		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("\" rel=\"stylesheet\"><link rel=\"icon\" href=\"/static/icon-128.png\" type=\"image/png\"><script src=\"/static/htmx-2.0.6.min.js\"></script><link rel=\"manifest\" href=\"")
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
-		if user != nil {
-			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("<li><button hx-post=\"/auth/sign-out\" hx-target=\"body\" class=\"px-4 py-2 text-white rounded-md bg-gray-600 hover:bg-gray-700\">Sign Out</button></li>")
+		var templ_7745c5c3_Var5 string
+		templ_7745c5c3_Var5, templ_7745c5c3_Err = templ.JoinStringErrs(versionedPath("/static/manifest.json"))
+		if templ_7745c5c3_Err != nil {
+			return templ.Error{Err: templ_7745c5c3_Err, FileName: `views/layout.templ`, Line: 28, Col: 67}
+		}
+		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var5))
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("\"><meta name=\"mobile-web-app-capable\" content=\"yes\"><meta name=\"apple-mobile-web-app-capable\" content=\"yes\"><meta name=\"application-name\" content=\"Pushable\"><meta name=\"apple-mobile-web-app-title\" content=\"Pushable\"><!--\n    <meta name=\"theme-color\" content=\"#2c3e50\"/> \n    <meta name=\"msapplication-navbutton-color\" content=\"#2c3e50\"/>\n    --><meta name=\"apple-mobile-web-app-status-bar-style\" content=\"black-translucent\"><link rel=\"icon\" type=\"image/png\" href=\"/static/icon-512.png\"><link rel=\"apple-touch-icon\" href=\"/static/icon-512.png\"></head><body id=\"body\" class=\"bg-neutral-900 text-neutral-100 flex flex-col min-h-screen\" hx-headers=\"")
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		var templ_7745c5c3_Var6 string
+		templ_7745c5c3_Var6, templ_7745c5c3_Err = templ.JoinStringErrs(csrfHeaders(ctx))
+		if templ_7745c5c3_Err != nil {
+			return templ.Error{Err: templ_7745c5c3_Err, FileName: `views/layout.templ`, Line: 42, Col: 112}
+		}
+		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var6))
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("\"><header class=\"bg-neutral-800\"><nav class=\"container flex items-center justify-between p-4 mx-auto\"><a href=\"/\" title=\"Napp Home\" class=\"flex items-center space-x-2\"><img src=\"/static/icon-512.png\" class=\"h-10 w-10\" alt=\"Icon\"> <span class=\"text-4xl font-bold\">Pushable</span></a><ul class=\"flex items-center space-x-4\">")
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		if user != nil && user.IsAdmin() {
+			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("<li><a href=\"/admin\" class=\"px-4 py-2 text-white rounded-md bg-gray-600 hover:bg-gray-700\">Admin</a></li>")
 			if templ_7745c5c3_Err != nil {
 				return templ_7745c5c3_Err
 			}
-		} else {
-			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("<li><button hx-get=\"/auth/sign-in\" hx-target=\"body\" class=\"px-4 py-2 text-white rounded-md bg-primary-600 hover:bg-primary-700\">Sign In</button></li>")
-			if templ_7745c5c3_Err != nil {
-				return templ_7745c5c3_Err
+		}
+		if !cfg.ProxyAuth.Enabled() {
+			if user != nil {
+				_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("<li><a href=\"/account/2fa\" class=\"px-4 py-2 text-white rounded-md bg-gray-600 hover:bg-gray-700\">2FA</a></li><li><a href=\"/account/sessions\" class=\"px-4 py-2 text-white rounded-md bg-gray-600 hover:bg-gray-700\">Sessions</a></li><li><button hx-get=\"/auth/password\" hx-target=\"body\" class=\"px-4 py-2 text-white rounded-md bg-gray-600 hover:bg-gray-700\">Password</button></li><li><button hx-post=\"/auth/sign-out\" hx-target=\"body\" class=\"px-4 py-2 text-white rounded-md bg-gray-600 hover:bg-gray-700\">Sign Out</button></li>")
+				if templ_7745c5c3_Err != nil {
+					return templ_7745c5c3_Err
+				}
+			} else {
+				_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("<li><button hx-get=\"/auth/sign-in\" hx-target=\"body\" class=\"px-4 py-2 text-white rounded-md bg-primary-600 hover:bg-primary-700\">Sign In</button></li>")
+				if templ_7745c5c3_Err != nil {
+					return templ_7745c5c3_Err
+				}
 			}
 		}
 		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("</ul></nav></header><main class=\"container p-4 mx-auto flex-grow\">")
@@ -115,20 +149,20 @@ func Layout(cfg types.Config, user *types.User, title string) templ.Component {
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
-		var templ_7745c5c3_Var5 string
-		templ_7745c5c3_Var5, templ_7745c5c3_Err = templ.JoinStringErrs(version.Tag)
+		var templ_7745c5c3_Var7 string
+		templ_7745c5c3_Var7, templ_7745c5c3_Err = templ.JoinStringErrs(version.Tag)
 		if templ_7745c5c3_Err != nil {
-			return templ.Error{Err: templ_7745c5c3_Err, FileName: `views/layout.templ`, Line: 82, Col: 77}
+			return templ.Error{Err: templ_7745c5c3_Err, FileName: `views/layout.templ`, Line: 100, Col: 77}
 		}
-		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var5))
+		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var7))
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
-		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("</p></footer><script type=\"text/javascript\">\n\t\tdocument.addEventListener(\"DOMContentLoaded\", (event) => {\n\t\t\tdocument.body.addEventListener('htmx:beforeSwap', function (evt) {\n\t\t\t\tif (evt.detail.xhr.status === 422 || evt.detail.xhr.status === 500) {\n\t\t\t\t\tconsole.log(\"setting status to paint\");\n\t\t\t\t\t// allow 422 responses to swap as we are using this as a signal that\n\t\t\t\t\t// a form was submitted with bad data and want to rerender with the\n\t\t\t\t\t// errors\n\t\t\t\t\t//\n\t\t\t\t\t// set isError to false to avoid error logging in console\n\t\t\t\t\tevt.detail.shouldSwap = true;\n\t\t\t\t\tevt.detail.isError = false;\n\t\t\t\t}\n\t\t\t});\n\t\t});\n\t</script><script>\n\t\tfunction setupNotifications(vapidPublicKey, serviceworkerPath) {\n\t\t\tlet wakeLock = null;\n\n\t\t\t// Register Service Worker\n\t\t\tif ('serviceWorker' in navigator) {\n\t\t\t\tnavigator.serviceWorker.register(serviceworkerPath, { scope: '/' })\n\t\t\t\t\t.then(function (reg) {\n\t\t\t\t\t\tconsole.log('Service Worker registered successfully.');\n\t\t\t\t\t\tif (document.getElementById('push-subscribe-button')) {\n\t\t\t\t\t\t\tdocument.getElementById('push-subscribe-button').addEventListener('click', function () {\n\t\t\t\t\t\t\t\tconsole.log(\"subscribe button pusshed\")\n\t\t\t\t\t\t\t\tif ('serviceWorker' in navigator && 'PushManager' in window) {\n\t\t\t\t\t\t\t\t\tNotification.requestPermission().then(function (permission) {\n\t\t\t\t\t\t\t\t\t\tif (permission === 'granted') {\n\t\t\t\t\t\t\t\t\t\t\tconsole.log(\"going to subscribe\")\n\t\t\t\t\t\t\t\t\t\t\treg.pushManager.subscribe({\n\t\t\t\t\t\t\t\t\t\t\t\tuserVisibleOnly: true,\n\t\t\t\t\t\t\t\t\t\t\t\tapplicationServerKey: urlBase64ToUint8Array(vapidPublicKey)\n\t\t\t\t\t\t\t\t\t\t\t}).then(function (subscription) {\n\t\t\t\t\t\t\t\t\t\t\t\tconsole.log(\"Posting to /push/subscribe\")\n\t\t\t\t\t\t\t\t\t\t\t\tfetch('/push/subscribe', {\n\t\t\t\t\t\t\t\t\t\t\t\t\tmethod: 'POST',\n\t\t\t\t\t\t\t\t\t\t\t\t\theaders: {\n\t\t\t\t\t\t\t\t\t\t\t\t\t\t'Content-Type': 'application/json'\n\t\t\t\t\t\t\t\t\t\t\t\t\t},\n\t\t\t\t\t\t\t\t\t\t\t\t\tbody: JSON.stringify(subscription)\n\t\t\t\t\t\t\t\t\t\t\t\t});\n\t\t\t\t\t\t\t\t\t\t\t}).then(function (resp) {\n\t\t\t\t\t\t\t\t\t\t\t\talert(\"Subscribed!\")\n\t\t\t\t\t\t\t\t\t\t\t\tdocument.getElementById('push-subscribe-button').remove()\n\t\t\t\t\t\t\t\t\t\t\t}).catch(function (err) {\n\t\t\t\t\t\t\t\t\t\t\t\tconsole.error('Failed to subscribe to push notifications:', err);\n\t\t\t\t\t\t\t\t\t\t\t});\n\t\t\t\t\t\t\t\t\t\t} else {\n\t\t\t\t\t\t\t\t\t\t\tconsole.log(\"Permission not granted for notifications\");\n\t\t\t\t\t\t\t\t\t\t}\n\t\t\t\t\t\t\t\t\t});\n\t\t\t\t\t\t\t\t} else {\n\t\t\t\t\t\t\t\t\tconsole.log(\"Missing deps\")\n\t\t\t\t\t\t\t\t}\n\t\t\t\t\t\t\t});\n\t\t\t\t\t\t}\n\t\t\t\t\t})\n\t\t\t\t\t.catch(err => console.error('Service Worker registration failed:', err));\n\t\t\t}\n\t\t}\n\n\t\tfunction urlBase64ToUint8Array(base64String) {\n\t\t\tconst padding = '='.repeat((4 - base64String.length % 4) % 4);\n\t\t\tconst base64 = (base64String + padding)\n\t\t\t\t.replace(/\\-/g, '+')\n\t\t\t\t.replace(/_/g, '/');\n\n\t\t\tconst rawData = window.atob(base64);\n\t\t\tconst outputArray = new Uint8Array(rawData.length);\n\n\t\t\tfor (let i = 0; i < rawData.length; ++i) {\n\t\t\t\toutputArray[i] = rawData.charCodeAt(i);\n\t\t\t}\n\t\t\treturn outputArray;\n\t\t}\n\t</script>")
+		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("</p></footer><script type=\"text/javascript\">\n\t\tdocument.addEventListener(\"DOMContentLoaded\", (event) => {\n\t\t\tdocument.body.addEventListener('htmx:beforeSwap', function (evt) {\n\t\t\t\tif (evt.detail.xhr.status === 422 || evt.detail.xhr.status === 500) {\n\t\t\t\t\tconsole.log(\"setting status to paint\");\n\t\t\t\t\t// allow 422 responses to swap as we are using this as a signal that\n\t\t\t\t\t// a form was submitted with bad data and want to rerender with the\n\t\t\t\t\t// errors\n\t\t\t\t\t//\n\t\t\t\t\t// set isError to false to avoid error logging in console\n\t\t\t\t\tevt.detail.shouldSwap = true;\n\t\t\t\t\tevt.detail.isError = false;\n\t\t\t\t}\n\t\t\t});\n\t\t});\n\t</script><script>\n\t\tfunction setupNotifications(vapidPublicKey, serviceworkerPath) {\n\t\t\tlet wakeLock = null;\n\n\t\t\t// Register Service Worker\n\t\t\tif ('serviceWorker' in navigator) {\n\t\t\t\tnavigator.serviceWorker.register(serviceworkerPath, { scope: '/' })\n\t\t\t\t\t.then(function (reg) {\n\t\t\t\t\t\tconsole.log('Service Worker registered successfully.');\n\t\t\t\t\t\tmigrateSubscription(reg, vapidPublicKey);\n\t\t\t\t\t\tif (document.getElementById('push-subscribe-button')) {\n\t\t\t\t\t\t\tdocument.getElementById('push-subscribe-button').addEventListener('click', function () {\n\t\t\t\t\t\t\t\tconsole.log(\"subscribe button pusshed\")\n\t\t\t\t\t\t\t\tif ('serviceWorker' in navigator && 'PushManager' in window) {\n\t\t\t\t\t\t\t\t\tNotification.requestPermission().then(function (permission) {\n\t\t\t\t\t\t\t\t\t\tif (permission === 'granted') {\n\t\t\t\t\t\t\t\t\t\t\tconsole.log(\"going to subscribe\")\n\t\t\t\t\t\t\t\t\t\t\treg.pushManager.subscribe({\n\t\t\t\t\t\t\t\t\t\t\t\tuserVisibleOnly: true,\n\t\t\t\t\t\t\t\t\t\t\t\tapplicationServerKey: urlBase64ToUint8Array(vapidPublicKey)\n\t\t\t\t\t\t\t\t\t\t\t}).then(function (subscription) {\n\t\t\t\t\t\t\t\t\t\t\t\tconsole.log(\"Posting to /push/subscribe\")\n\t\t\t\t\t\t\t\t\t\t\t\tfetch('/push/subscribe', {\n\t\t\t\t\t\t\t\t\t\t\t\t\tmethod: 'POST',\n\t\t\t\t\t\t\t\t\t\t\t\t\theaders: {\n\t\t\t\t\t\t\t\t\t\t\t\t\t\t'Content-Type': 'application/json',\n\t\t\t\t\t\t\t\t\t\t\t\t\t\t'X-CSRF-Token': document.querySelector('meta[name=\"csrf-token\"]').content\n\t\t\t\t\t\t\t\t\t\t\t\t\t},\n\t\t\t\t\t\t\t\t\t\t\t\t\tbody: JSON.stringify(Object.assign(subscription.toJSON(), { vapidPublicKey: vapidPublicKey }))\n\t\t\t\t\t\t\t\t\t\t\t\t});\n\t\t\t\t\t\t\t\t\t\t\t}).then(function (resp) {\n\t\t\t\t\t\t\t\t\t\t\t\talert(\"Subscribed!\")\n\t\t\t\t\t\t\t\t\t\t\t\tdocument.getElementById('push-subscribe-button').remove()\n\t\t\t\t\t\t\t\t\t\t\t}).catch(function (err) {\n\t\t\t\t\t\t\t\t\t\t\t\tconsole.error('Failed to subscribe to push notifications:', err);\n\t\t\t\t\t\t\t\t\t\t\t});\n\t\t\t\t\t\t\t\t\t\t} else {\n\t\t\t\t\t\t\t\t\t\t\tconsole.log(\"Permission not granted for notifications\");\n\t\t\t\t\t\t\t\t\t\t}\n\t\t\t\t\t\t\t\t\t});\n\t\t\t\t\t\t\t\t} else {\n\t\t\t\t\t\t\t\t\tconsole.log(\"Missing deps\")\n\t\t\t\t\t\t\t\t}\n\t\t\t\t\t\t\t});\n\t\t\t\t\t\t}\n\t\t\t\t\t})\n\t\t\t\t\t.catch(err => console.error('Service Worker registration failed:', err));\n\t\t\t}\n\t\t}\n\n\t\t// Move a subscription made with a rotated VAPID key over to the active key\n\t\tfunction migrateSubscription(reg, vapidPublicKey) {\n\t\t\tif (!('PushManager' in window)) {\n\t\t\t\treturn;\n\t\t\t}\n\t\t\treg.pushManager.getSubscription().then(function (old) {\n\t\t\t\tif (!old || !old.options.applicationServerKey) {\n\t\t\t\t\treturn;\n\t\t\t\t}\n\t\t\t\tif (uint8ArrayToUrlBase64(old.options.applicationServerKey) === vapidPublicKey) {\n\t\t\t\t\treturn;\n\t\t\t\t}\n\t\t\t\tconst oldJSON = old.toJSON();\n\t\t\t\treturn old.unsubscribe().then(function () {\n\t\t\t\t\treturn reg.pushManager.subscribe({\n\t\t\t\t\t\tuserVisibleOnly: true,\n\t\t\t\t\t\tapplicationServerKey: urlBase64ToUint8Array(vapidPublicKey)\n\t\t\t\t\t});\n\t\t\t\t}).then(function (subscription) {\n\t\t\t\t\treturn fetch('/push/resubscribe', {\n\t\t\t\t\t\tmethod: 'POST',\n\t\t\t\t\t\theaders: { 'Content-Type': 'application/json' },\n\t\t\t\t\t\tbody: JSON.stringify({\n\t\t\t\t\t\t\told: oldJSON,\n\t\t\t\t\t\t\tsubscription: Object.assign(subscription.toJSON(), { vapidPublicKey: vapidPublicKey })\n\t\t\t\t\t\t})\n\t\t\t\t\t});\n\t\t\t\t});\n\t\t\t}).catch(err => console.error('Failed to move subscription to the new key:', err));\n\t\t}\n\n\t\tfunction uint8ArrayToUrlBase64(buffer) {\n\t\t\treturn window.btoa(String.fromCharCode.apply(null, new Uint8Array(buffer)))\n\t\t\t\t.replace(/\\+/g, '-')\n\t\t\t\t.replace(/\\//g, '_')\n\t\t\t\t.replace(/=+$/, '');\n\t\t}\n\n\t\tfunction urlBase64ToUint8Array(base64String) {\n\t\t\tconst padding = '='.repeat((4 - base64String.length % 4) % 4);\n\t\t\tconst base64 = (base64String + padding)\n\t\t\t\t.replace(/\\-/g, '+')\n\t\t\t\t.replace(/_/g, '/');\n\n\t\t\tconst rawData = window.atob(base64);\n\t\t\tconst outputArray = new Uint8Array(rawData.length);\n\n\t\t\tfor (let i = 0; i < rawData.length; ++i) {\n\t\t\t\toutputArray[i] = rawData.charCodeAt(i);\n\t\t\t}\n\t\t\treturn outputArray;\n\t\t}\n\t</script>")
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
-		templ_7745c5c3_Err = templ.JSFuncCall("setupNotifications", cfg.VapidPublicKey, versionedPath("/serviceWorker.js")).Render(ctx, templ_7745c5c3_Buffer)
+		templ_7745c5c3_Err = templ.JSFuncCall("setupNotifications", vapidPublicKey(ctx), versionedPath("/serviceWorker.js")).Render(ctx, templ_7745c5c3_Buffer)
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
@@ -0,0 +1,18 @@
+package views
+
+import (
+	"context"
+)
+
+type vapidPublicKeyKey struct{}
+
+// WithVapidPublicKey makes the active VAPID public key available to the
+// templates rendered with ctx
+func WithVapidPublicKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, vapidPublicKeyKey{}, key)
+}
+
+func vapidPublicKey(ctx context.Context) string {
+	key, _ := ctx.Value(vapidPublicKeyKey{}).(string)
+	return key
+}